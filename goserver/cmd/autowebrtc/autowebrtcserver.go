@@ -4,33 +4,62 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
-	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/pion/webrtc/v4"
-	"github.com/pion/webrtc/v4/pkg/media"
-	"github.com/pion/webrtc/v4/pkg/media/ivfreader"
-)
-
-const (
-	largeVideoFileName = "./resource/output.ivf"
+	"server.firehunter.juhyung.dev/internal/moviecatalog"
+	"server.firehunter.juhyung.dev/internal/playout"
+	"server.firehunter.juhyung.dev/internal/turncred"
 )
 
 var (
+	// movie catalog 에서 -movie 의 IVF, 없으면 MP4 경로로 정한다.
+	largeVideoFileName string
+	// -movie 의 Ogg Opus, 없으면 MP4 경로. 비어 있으면 소리를 보내지 않는다.
+	audioFileName string
+
 	waitingReadForSessionDescription atomic.Bool
 	sessionDescriptionReceived       = false
 	sessionDecriptionChannel         = make(chan string)
 
 	answerChannel = make(chan string)
+
+	turnConfig turncred.Config
 )
 
 func main() {
+	turnSecret := flag.String("turn-secret", os.Getenv("FIREHUNTER_TURN_SECRET"), "Shared secret of the TURN server for ephemeral credentials (env FIREHUNTER_TURN_SECRET). Empty uses STUN only")
+	turnURL := flag.String("turn-url", "turn:turn.i.juhyung.dev:3478", "TURN server URL")
+	catalogPath := flag.String("catalog", moviecatalog.DefaultPath, "Movie catalog file")
+	movieID := flag.String("movie", "1", "ID of the movie to stream. It needs an IVF or MP4 rendition in the catalog")
+	flag.Parse()
+
+	catalog, err := moviecatalog.Load(*catalogPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	largeVideoFileName, err = catalog.File(*movieID, moviecatalog.IVF, moviecatalog.MP4)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	audioFileName, _ = catalog.File(*movieID, moviecatalog.Audio, moviecatalog.MP4)
+
+	turnConfig = turncred.Config{
+		Secret:   *turnSecret,
+		TTL:      12 * time.Hour,
+		STUNURLs: []string{"stun:stun.i.juhyung.dev:3478"},
+		TURNURLs: []string{*turnURL},
+	}
+
 	go webrtcMain()
 	// giuMain()
 
@@ -112,14 +141,18 @@ func webrtcMain() {
 		println("Don't have large video file " + largeVideoFileName)
 	}
 
+	// 임시 계정은 offer 를 받은 뒤에 만든다. 서버를 켜 두고 한참 뒤에 offer 가 와도 TTL 을 온전히 쓴다.
+	// pion 은 PeerConnection 을 만든 뒤에 바꾼 ICE server 를 gatherer 에 반영하지 않으므로 PeerConnection 도 그 뒤에 만든다.
+	offer := webrtc.SessionDescription{}
+	decode(readUntilNewLine(), &offer)
+
+	iceServers, err := freshICEServers()
+	if err != nil {
+		panic(err)
+	}
+
 	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs:       []string{"turn:turn.i.juhyung.dev:3478"},
-				Username:   "juhyung2",
-				Credential: "juhyung2",
-			},
-		},
+		ICEServers: iceServers,
 	})
 	if err != nil {
 		panic(err)
@@ -133,93 +166,97 @@ func webrtcMain() {
 
 	iceConnectedCtx, iceConnectedCtxCancel := context.WithCancel(context.Background())
 
-	file, openErr := os.Open(largeVideoFileName)
-	if openErr != nil {
-		panic(openErr)
+	clip, loadErr := playout.Load(largeVideoFileName, 0)
+	if loadErr == nil && !playout.Sendable(clip.MimeType) {
+		loadErr = fmt.Errorf("can not send %s", clip.MimeType)
 	}
-
-	_, header, openErr := ivfreader.NewWith(file)
-	if openErr != nil {
-		panic(openErr)
-	}
-
-	var trackCodec string
-	// fourcc: https://en.wikipedia.org/wiki/FourCC
-	switch header.FourCC {
-	case "AV01":
-		trackCodec = webrtc.MimeTypeAV1
-	case "VP90":
-		trackCodec = webrtc.MimeTypeVP9
-	case "VP80":
-		trackCodec = webrtc.MimeTypeVP8
-	default:
-		panic(fmt.Sprintf("Unable to handle FourCC %s", header.FourCC))
+	var audio *playout.Clip
+	if audioFileName != "" {
+		var audioErr error
+		if audio, audioErr = playout.LoadAudio(audioFileName); audioErr != nil {
+			fmt.Printf("No audio: %v\n", audioErr)
+		}
 	}
-
-	videoTrack, videoTrackErr := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
-		MimeType: trackCodec,
-	}, "video", "pion")
-	if videoTrackErr != nil {
-		panic(videoTrackErr)
+	// 영상을 못 읽어도 소리가 있으면 소리만 보낸다.
+	if loadErr != nil {
+		if audio == nil {
+			panic(loadErr)
+		}
+		fmt.Printf("Audio only: %v\n", loadErr)
+		clip = nil
 	}
 
-	rtpSender, videoTrackErr := peerConnection.AddTrack(videoTrack)
-	if videoTrackErr != nil {
-		panic(videoTrackErr)
-	}
+	var sender *playout.Sender
+	if clip != nil {
+		videoTrack, videoTrackErr := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
+			MimeType: clip.MimeType,
+		}, "video", "pion")
+		if videoTrackErr != nil {
+			panic(videoTrackErr)
+		}
 
-	// Read incoming RTCP packets
-	// Before these packets are returned they are processed by interceptors. For things
-	// like NACK this needs to be called.
-	go func() {
-		rtcpBuf := make([]byte, 1500)
-		for {
-			if _, _, rtcpErr := rtpSender.Read(rtcpBuf); rtcpErr != nil {
-				return
-			}
+		rtpSender, videoTrackErr := peerConnection.AddTrack(videoTrack)
+		if videoTrackErr != nil {
+			panic(videoTrackErr)
 		}
-	}()
 
-	go func() {
-		// Open a IVF file and start reading using our IVFReader
-		file, ivfErr := os.Open(largeVideoFileName)
-		if ivfErr != nil {
-			panic(ivfErr)
+		// Read incoming RTCP packets
+		// Before these packets are returned they are processed by interceptors. For things
+		// like NACK this needs to be called.
+		// PLI/FIR 이 오면 다음 화면을 keyframe 으로 보낸다.
+		sender = playout.NewSender(clip, videoTrack)
+		go playout.ReadRTCP(rtpSender, playout.RTCPHandler{KeyframeRequest: sender.RequestKeyframe})
+	}
+
+	// 소리는 영상과 같은 stream ID 로 보내서 브라우저가 입 모양을 맞추게 한다.
+	var audioTrack *webrtc.TrackLocalStaticSample
+	if audio != nil {
+		var audioTrackErr error
+		audioTrack, audioTrackErr = webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
+			MimeType: audio.MimeType,
+		}, "audio", "pion")
+		if audioTrackErr != nil {
+			panic(audioTrackErr)
 		}
 
-		ivf, header, ivfErr := ivfreader.NewWith(file)
-		if ivfErr != nil {
-			panic(ivfErr)
+		rtpSender, audioTrackErr := peerConnection.AddTrack(audioTrack)
+		if audioTrackErr != nil {
+			panic(audioTrackErr)
 		}
+		go playout.ReadRTCP(rtpSender, playout.RTCPHandler{})
+	}
 
+	go func() {
 		fmt.Println("Wait for ICE Connection Connected")
 		<-iceConnectedCtx.Done()
-		fmt.Println("ICE Connection Connected, start sending video track")
-
-		ticker := time.NewTicker(
-			time.Millisecond *
-				time.Duration(
-					(float32(header.TimebaseNumerator) /
-						float32(header.TimebaseDenominator) *
-						1000)))
-		for ; true; <-ticker.C {
-			frame, _, ivfErr := ivf.ParseNextFrame()
-			if errors.Is(ivfErr, io.EOF) {
-				fmt.Printf("All video frames parsed and sent\n")
-				os.Exit(0)
-			}
-
-			if ivfErr != nil {
-				panic(ivfErr)
-			}
-
-			// Sample에 Timestamp 안 적어도 되나?
-			// Sample에 duration을 임의로 저렇게 넣어도 되나?
-			if ivfErr = videoTrack.WriteSample(
-				media.Sample{Data: frame, Duration: time.Second}); ivfErr != nil {
-				panic(ivfErr)
-			}
+		fmt.Println("ICE Connection Connected, start sending tracks")
+
+		// 파일에 적힌 timestamp 대로, 영상과 소리를 같은 pacer 로 보낸다.
+		pacer := playout.NewPacer(0)
+		var wg sync.WaitGroup
+		if clip != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if playErr := playout.Play(context.Background(), clip, pacer, playout.PlayOptions{}, sender.Write); playErr != nil {
+					panic(playErr)
+				}
+			}()
+		}
+		if audio != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if playErr := playout.Play(context.Background(), audio, pacer, playout.PlayOptions{}, func(i int) error {
+					return audioTrack.WriteSample(audio.Sample(i))
+				}); playErr != nil {
+					panic(playErr)
+				}
+			}()
 		}
+		wg.Wait()
+		fmt.Printf("All frames parsed and sent\n")
+		os.Exit(0)
 	}()
 
 	peerConnection.OnICEConnectionStateChange(func(conectionState webrtc.ICEConnectionState) {
@@ -245,9 +282,6 @@ func webrtcMain() {
 		}
 	})
 
-	offer := webrtc.SessionDescription{}
-	decode(readUntilNewLine(), &offer)
-
 	// TODO: remote description 의미 찾기
 	if err = peerConnection.SetRemoteDescription(offer); err != nil {
 		panic(err)
@@ -277,6 +311,23 @@ func webrtcMain() {
 	select {}
 }
 
+// freshICEServers는 TURN 서버와 같은 비밀키로 지금부터 TTL 동안 쓸 임시 계정을 만든다.
+func freshICEServers() ([]webrtc.ICEServer, error) {
+	credServers, err := turnConfig.ICEServers("autowebrtc")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TURN credentials: %w", err)
+	}
+	var iceServers []webrtc.ICEServer
+	for _, server := range credServers {
+		iceServers = append(iceServers, webrtc.ICEServer{
+			URLs:       server.URLs,
+			Username:   server.Username,
+			Credential: server.Credential,
+		})
+	}
+	return iceServers, nil
+}
+
 func decode(in string, obj *webrtc.SessionDescription) {
 	b, err := base64.StdEncoding.DecodeString(in)
 	if err != nil {
@@ -0,0 +1,39 @@
+// Package main은 시그널링 서버에 연결할 때 쓰는 토큰을 발급한다.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"server.firehunter.juhyung.dev/internal/authtoken"
+)
+
+func main() {
+	secret := flag.String("secret", os.Getenv("FIREHUNTER_AUTH_SECRET"), "HMAC secret shared with the signalling server (env FIREHUNTER_AUTH_SECRET)")
+	subject := flag.String("subject", "", "Who the token is for (e.g. \"resource-1\", \"tablet-3\")")
+	role := flag.String("role", string(authtoken.RoleTablet), "One of resource, tablet, operator")
+	ttl := flag.Duration("ttl", 24*time.Hour, "How long the token is valid")
+	flag.Parse()
+
+	if len(*secret) == 0 {
+		log.Fatalf("'secret' is required")
+	}
+	if len(*subject) == 0 {
+		log.Fatalf("'subject' is required")
+	}
+
+	parsedRole, err := authtoken.ParseRole(*role)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	token, err := authtoken.Sign([]byte(*secret), authtoken.NewClaims(*subject, parsedRole, *ttl))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(token)
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/rs/cors"
+	"server.firehunter.juhyung.dev/internal/moviecatalog"
 )
 
 func main() {
@@ -30,6 +31,15 @@ func main() {
 
 	fvideos := http.FileServer(http.Dir("./resource/"))
 	http.Handle("/videos/", http.StripPrefix("/videos/", fvideos))
+
+	// 영화 파일 경로는 catalog 의 rendition 경로 그대로다.
+	catalog, err := moviecatalog.Load(moviecatalog.DefaultPath)
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		catalog.RegisterHandler(http.DefaultServeMux)
+	}
+
 	fs := http.FileServer(http.Dir("./resource/root"))
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Println(r.URL.Path)
@@ -6,6 +6,8 @@ import (
 	"net"
 	"net/http"
 	"os"
+
+	"server.firehunter.juhyung.dev/internal/moviecatalog"
 )
 
 func main() {
@@ -33,6 +35,15 @@ func main() {
 
 	fvideos := http.FileServer(http.Dir("./resource/"))
 	http.Handle("/", fvideos)
+
+	// 영화 파일 경로는 catalog 의 rendition 경로 그대로다.
+	catalog, err := moviecatalog.Load(moviecatalog.DefaultPath)
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		catalog.RegisterHandler(http.DefaultServeMux)
+	}
+
 	err = http.ListenAndServe("0.0.0.0:8080", nil)
 	if err != nil {
 		log.Fatal(err)
@@ -6,22 +6,20 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
 	"os"
 	"sync/atomic"
-	"time"
 
 	"github.com/pion/webrtc/v4"
-	"github.com/pion/webrtc/v4/pkg/media"
-	"github.com/pion/webrtc/v4/pkg/media/ivfreader"
+	"server.firehunter.juhyung.dev/internal/moviecatalog"
+	"server.firehunter.juhyung.dev/internal/playout"
 
 	g "github.com/AllenDang/giu"
 )
 
 const (
-	largeVideoFileName = "./resource/output.ivf"
+	// movie catalog 에서 이 영화의 IVF, 없으면 MP4 를 보낸다.
+	sampleMovieID = "1"
 )
 
 var (
@@ -79,7 +77,16 @@ func main() {
 }
 
 func webrtcMain() {
-	_, err := os.Stat(largeVideoFileName)
+	catalog, err := moviecatalog.Load(moviecatalog.DefaultPath)
+	if err != nil {
+		panic(err)
+	}
+	largeVideoFileName, err := catalog.File(sampleMovieID, moviecatalog.IVF, moviecatalog.MP4)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = os.Stat(largeVideoFileName)
 	haveLargeVideoFile := !os.IsNotExist(err)
 	if haveLargeVideoFile {
 		println("Have large video file")
@@ -106,31 +113,13 @@ func webrtcMain() {
 
 	iceConnectedCtx, iceConnectedCtxCancel := context.WithCancel(context.Background())
 
-	file, openErr := os.Open(largeVideoFileName)
-	if openErr != nil {
-		panic(openErr)
-	}
-
-	_, header, openErr := ivfreader.NewWith(file)
-	if openErr != nil {
-		panic(openErr)
-	}
-
-	var trackCodec string
-	// fourcc: https://en.wikipedia.org/wiki/FourCC
-	switch header.FourCC {
-	case "AV01":
-		trackCodec = webrtc.MimeTypeAV1
-	case "VP90":
-		trackCodec = webrtc.MimeTypeVP9
-	case "VP80":
-		trackCodec = webrtc.MimeTypeVP8
-	default:
-		panic(fmt.Sprintf("Unable to handle FourCC %s", header.FourCC))
+	clip, loadErr := playout.Load(largeVideoFileName, 0)
+	if loadErr != nil {
+		panic(loadErr)
 	}
 
 	videoTrack, videoTrackErr := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
-		MimeType: trackCodec,
+		MimeType: clip.MimeType,
 	}, "video", "pion")
 	if videoTrackErr != nil {
 		panic(videoTrackErr)
@@ -144,55 +133,21 @@ func webrtcMain() {
 	// Read incoming RTCP packets
 	// Before these packets are returned they are processed by interceptors. For things
 	// like NACK this needs to be called.
-	go func() {
-		rtcpBuf := make([]byte, 1500)
-		for {
-			if _, _, rtcpErr := rtpSender.Read(rtcpBuf); rtcpErr != nil {
-				return
-			}
-		}
-	}()
+	// PLI/FIR 이 오면 다음 화면을 keyframe 으로 보낸다.
+	sender := playout.NewSender(clip, videoTrack)
+	go playout.ReadRTCP(rtpSender, playout.RTCPHandler{KeyframeRequest: sender.RequestKeyframe})
 
 	go func() {
-		// Open a IVF file and start reading using our IVFReader
-		file, ivfErr := os.Open(largeVideoFileName)
-		if ivfErr != nil {
-			panic(ivfErr)
-		}
-
-		ivf, header, ivfErr := ivfreader.NewWith(file)
-		if ivfErr != nil {
-			panic(ivfErr)
-		}
-
 		fmt.Println("Wait for ICE Connection Connected")
 		<-iceConnectedCtx.Done()
 		fmt.Println("ICE Connection Connected, start sending video track")
 
-		ticker := time.NewTicker(
-			time.Millisecond *
-				time.Duration(
-					(float32(header.TimebaseNumerator) /
-						float32(header.TimebaseDenominator) *
-						1000)))
-		for ; true; <-ticker.C {
-			frame, _, ivfErr := ivf.ParseNextFrame()
-			if errors.Is(ivfErr, io.EOF) {
-				fmt.Printf("All video frames parsed and sent\n")
-				os.Exit(0)
-			}
-
-			if ivfErr != nil {
-				panic(ivfErr)
-			}
-
-			// Sample에 Timestamp 안 적어도 되나?
-			// Sample에 duration을 임의로 저렇게 넣어도 되나?
-			if ivfErr = videoTrack.WriteSample(
-				media.Sample{Data: frame, Duration: time.Second}); ivfErr != nil {
-				panic(ivfErr)
-			}
+		// 파일에 적힌 timestamp 대로 보낸다.
+		if playErr := playout.Play(context.Background(), clip, playout.NewPacer(0), playout.PlayOptions{}, sender.Write); playErr != nil {
+			panic(playErr)
 		}
+		fmt.Printf("All video frames parsed and sent\n")
+		os.Exit(0)
 	}()
 
 	peerConnection.OnICEConnectionStateChange(func(conectionState webrtc.ICEConnectionState) {
@@ -6,32 +6,71 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"net"
 	"os"
 	"os/signal"
 	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/pion/turn/v3"
+	"server.firehunter.juhyung.dev/internal/natbehavior"
+	"server.firehunter.juhyung.dev/internal/turnadmin"
+	"server.firehunter.juhyung.dev/internal/turnguard"
 )
 
 func main() {
 	port := flag.Int("port", 3478, "Listening port.")
+	ip := flag.String("ip", "0.0.0.0", "Listening IP. Must be a specific IP with -other-ip")
+	otherIP := flag.String("other-ip", "", "Second local IP for RFC 5780 NAT behavior discovery (OTHER-ADDRESS/CHANGE-REQUEST). Empty disables it")
+	otherPort := flag.Int("other-port", 0, "Second port for NAT behavior discovery. Defaults to -port + 1")
+	rateLimit := flag.Float64("rate-limit", 20, "STUN requests per second accepted from one source IP. 0 disables rate limiting")
+	banDuration := flag.Duration("ban-duration", 5*time.Minute, "How long a source IP that keeps exceeding -rate-limit is ignored")
+	admin := flag.String("admin", "", "Address of the admin HTTP server with /metrics, /allocations and /healthz (e.g. \"127.0.0.1:9479\"). Empty disables it")
+	flag.Parse()
 
 	// Create a UDP listener to pass into pion/turn
 	// pion/turn itself doesn't allocate any UDP sockets, but lets the user pass them in
 	// this allows us to add logging, storage or modify inbound/outbound traffic
-	udpListener, err := net.ListenPacket("udp4", "0.0.0.0:"+strconv.Itoa(*port))
+	udpListener, err := net.ListenPacket("udp4", net.JoinHostPort(*ip, strconv.Itoa(*port)))
 	if err != nil {
 		log.Panicf("Failed to create STUN server listener: %s", err)
 	}
+	// 공개된 UDP 서비스라서 출발지 IP 별로 요청 수를 제한한다.
+	limiter := turnguard.NewRateLimiter(turnguard.NewRateLimits(*rateLimit, *banDuration))
+	defer limiter.Close()
+	// allocation 제한 없이 통계만 센다.
+	quota := turnguard.NewQuota(turnguard.Limits{})
+	defer quota.Close()
+	var packetConn net.PacketConn = quota.Wrap(limiter.Wrap(udpListener))
+
+	// binding 요청은 responder 가 OTHER-ADDRESS 를 넣어서 직접 답한다.
+	if len(*otherIP) > 0 {
+		parsedOtherIP := net.ParseIP(*otherIP)
+		if parsedOtherIP == nil {
+			log.Fatalf("invalid other-ip: %s", *otherIP)
+		}
+		if *otherPort == 0 {
+			*otherPort = *port + 1
+		}
+		responder, err := natbehavior.NewResponder(packetConn, parsedOtherIP, *otherPort, func(conn net.PacketConn) net.PacketConn {
+			return limiter.Wrap(conn)
+		})
+		if err != nil {
+			log.Panicf("Failed to start NAT behavior discovery: %s", err)
+		}
+		defer responder.Close()
+		packetConn = responder.Primary()
+		fmt.Println("NAT behavior discovery on", responder.Addrs())
+	}
 
 	s, err := turn.NewServer(turn.ServerConfig{
 		// PacketConnConfigs is a list of UDP Listeners and the configuration around them
 		PacketConnConfigs: []turn.PacketConnConfig{
 			{
-				PacketConn: udpListener,
+				PacketConn: packetConn,
 			},
 		},
 	})
@@ -39,6 +78,9 @@ func main() {
 		log.Panic(err)
 	}
 
+	fmt.Println("Listening on", udpListener.LocalAddr())
+	turnadmin.ListenAndServe(*admin, quota, limiter)
+
 	// Block until user sends SIGINT or SIGTERM
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/turn/v3"
+)
+
+type Credentials struct {
+	Username string
+	Password string
+	Realm    string
+}
+
+type DiagnoseConfig struct {
+	Credentials
+	Host string
+	Port int
+	// 0 이면 그 단계는 건너뛴다.
+	TCPPort int
+	TLSPort int
+	// echo 테스트에서 보낼 패킷 수와 간격
+	Count    int
+	Interval time.Duration
+	// 각 단계와 마지막 echo 응답을 기다리는 시간
+	Timeout time.Duration
+	// 이보다 많이 잃어버리면 echo 는 실패
+	MaxLossPercent float64
+}
+
+type StepResult struct {
+	Name       string  `json:"name"`
+	Pass       bool    `json:"pass"`
+	Skipped    bool    `json:"skipped,omitempty"`
+	DurationMs float64 `json:"durationMs"`
+	Detail     string  `json:"detail,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+type EchoResult struct {
+	Sent        int     `json:"sent"`
+	Received    int     `json:"received"`
+	LossPercent float64 `json:"lossPercent"`
+	RTTStats
+}
+
+type DiagnoseReport struct {
+	Target    string       `json:"target"`
+	StartedAt time.Time    `json:"startedAt"`
+	Pass      bool         `json:"pass"`
+	Steps     []StepResult `json:"steps"`
+	Echo      *EchoResult  `json:"echo,omitempty"`
+}
+
+var errSkipped = errors.New("skipped")
+
+func (r *DiagnoseReport) addStep(name string, timeout time.Duration, step func() (string, error)) {
+	runStep(r, name, timeout, func() (struct{}, string, error) {
+		detail, err := step()
+		return struct{}{}, detail, err
+	}, nil)
+}
+
+// runStep은 step 결과를 report 에 남기고 step 이 만든 값을 돌려준다. 실패해도 만든 값은 돌려준다.
+// timeout 이 지나면 zero 값을 돌려주고, step 이 늦게 만든 값은 cleanup 으로 닫는다.
+func runStep[T any](r *DiagnoseReport, name string, timeout time.Duration, step func() (T, string, error), cleanup func(T)) T {
+	type output struct {
+		value  T
+		detail string
+	}
+	startedAt := time.Now()
+	var cleanupOutput func(output)
+	if cleanup != nil {
+		cleanupOutput = func(late output) { cleanup(late.value) }
+	}
+	out, err := withTimeout(timeout, func() (output, error) {
+		value, detail, err := step()
+		return output{value, detail}, err
+	}, cleanupOutput)
+
+	result := StepResult{
+		Name:       name,
+		Pass:       err == nil,
+		DurationMs: milliseconds(time.Since(startedAt)),
+		Detail:     out.detail,
+	}
+	if errors.Is(err, errSkipped) {
+		result.Skipped = true
+		result.DurationMs = 0
+	}
+	if err != nil {
+		result.Error = err.Error()
+		r.Pass = false
+	}
+	r.Steps = append(r.Steps, result)
+	return out.value
+}
+
+// withTimeout은 pion 의 재전송을 끝까지 기다리지 않도록 f 를 timeout 까지만 기다린다.
+// f 의 결과는 channel 로만 받는다. timeout 뒤에 f 가 끝나면 그 결과를 cleanup 에 넘긴다. cleanup 은 nil 이어도 된다.
+func withTimeout[T any](timeout time.Duration, f func() (T, error), cleanup func(T)) (T, error) {
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := f()
+		done <- result{value, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-time.After(timeout):
+		if cleanup != nil {
+			go func() {
+				cleanup((<-done).value)
+			}()
+		}
+		var zero T
+		return zero, fmt.Errorf("timed out after %v", timeout)
+	}
+}
+
+func newTURNClient(conn net.PacketConn, serverAddr string, credentials Credentials) (*turn.Client, error) {
+	client, err := turn.NewClient(&turn.ClientConfig{
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Conn:           conn,
+		Username:       credentials.Username,
+		Password:       credentials.Password,
+		Realm:          credentials.Realm,
+		LoggerFactory:  logging.NewDefaultLoggerFactory(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TURN client: %w", err)
+	}
+	if err := client.Listen(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	return client, nil
+}
+
+// allocateOverStream은 TCP/TLS 연결 위에서 UDP relay 를 할당해 보고 바로 닫는다.
+func allocateOverStream(dial func() (net.Conn, error), serverAddr string, credentials Credentials) (string, error) {
+	conn, err := dial()
+	if err != nil {
+		return "", fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	client, err := newTURNClient(turn.NewSTUNConn(conn), serverAddr, credentials)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	relayConn, err := client.Allocate()
+	if err != nil {
+		return "", fmt.Errorf("failed to allocate: %w", err)
+	}
+	defer relayConn.Close()
+
+	return "relayed-address=" + relayConn.LocalAddr().String(), nil
+}
+
+// runDiagnose는 행사 전에 현장 네트워크에서 STUN/TURN 이 되는지 단계별로 확인한다.
+func runDiagnose(config DiagnoseConfig) DiagnoseReport {
+	udpAddr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	report := DiagnoseReport{
+		Target:    config.Host,
+		StartedAt: time.Now(),
+		Pass:      true,
+	}
+
+	conn, err := net.ListenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		report.addStep("stun-binding", config.Timeout, func() (string, error) { return "", err })
+		return report
+	}
+	defer conn.Close()
+
+	type binding struct {
+		client     *turn.Client
+		mappedAddr net.Addr
+	}
+	bound := runStep(&report, "stun-binding", config.Timeout, func() (binding, string, error) {
+		client, err := newTURNClient(conn, udpAddr, config.Credentials)
+		if err != nil {
+			return binding{}, "", err
+		}
+		// binding 이 실패해도 allocate 는 해 본다.
+		mappedAddr, err := client.SendBindingRequest()
+		if err != nil {
+			return binding{client: client}, "", err
+		}
+		return binding{client, mappedAddr}, "mapped-address=" + mappedAddr.String(), nil
+	}, func(late binding) {
+		if late.client != nil {
+			late.client.Close()
+		}
+	})
+	client, mappedAddr := bound.client, bound.mappedAddr
+	if client != nil {
+		defer client.Close()
+	}
+
+	relayConn := runStep(&report, "turn-allocate-udp", config.Timeout, func() (net.PacketConn, string, error) {
+		if client == nil {
+			return nil, "", fmt.Errorf("%w: no TURN client", errSkipped)
+		}
+		relayConn, err := client.Allocate()
+		if err != nil {
+			return nil, "", err
+		}
+		return relayConn, "relayed-address=" + relayConn.LocalAddr().String(), nil
+	}, func(late net.PacketConn) {
+		if late != nil {
+			late.Close()
+		}
+	})
+	if relayConn != nil {
+		defer relayConn.Close()
+	}
+
+	if config.TCPPort != 0 {
+		tcpAddr := net.JoinHostPort(config.Host, strconv.Itoa(config.TCPPort))
+		report.addStep("turn-allocate-tcp", config.Timeout, func() (string, error) {
+			return allocateOverStream(func() (net.Conn, error) {
+				return net.DialTimeout("tcp", tcpAddr, config.Timeout)
+			}, tcpAddr, config.Credentials)
+		})
+	}
+	if config.TLSPort != 0 {
+		tlsAddr := net.JoinHostPort(config.Host, strconv.Itoa(config.TLSPort))
+		report.addStep("turn-allocate-tls", config.Timeout, func() (string, error) {
+			return allocateOverStream(func() (net.Conn, error) {
+				dialer := &net.Dialer{Timeout: config.Timeout}
+				return tls.DialWithDialer(dialer, "tcp", tlsAddr, &tls.Config{
+					ServerName: config.Host,
+					MinVersion: tls.VersionTLS12,
+				})
+			}, tlsAddr, config.Credentials)
+		})
+	}
+
+	// permission 은 IP 단위라서 같은 컴퓨터의 다른 소켓도 relay 로 보낼 수 있다.
+	report.addStep("turn-permission", config.Timeout, func() (string, error) {
+		if relayConn == nil || mappedAddr == nil {
+			return "", fmt.Errorf("%w: no allocation", errSkipped)
+		}
+		if err := client.CreatePermission(mappedAddr); err != nil {
+			return "", err
+		}
+		return "peer=" + mappedAddr.String(), nil
+	})
+
+	report.Echo = runStep(&report, "echo", 2*config.Timeout+time.Duration(config.Count)*config.Interval, func() (*EchoResult, string, error) {
+		if relayConn == nil {
+			return nil, "", fmt.Errorf("%w: no allocation", errSkipped)
+		}
+		echo, err := runEcho(relayConn, config)
+		if err != nil {
+			return nil, "", err
+		}
+		if echo.Received == 0 || echo.LossPercent > config.MaxLossPercent {
+			return &echo, "", fmt.Errorf("lost %.1f%% of %d packets", echo.LossPercent, echo.Sent)
+		}
+		return &echo, fmt.Sprintf("received %d/%d", echo.Received, echo.Sent), nil
+	}, nil)
+
+	return report
+}
+
+// runEcho는 peer 소켓에서 relay 주소로 번호를 붙인 패킷을 보내고
+// relay 가 되돌려 보낸 패킷으로 왕복 시간을 잰다.
+func runEcho(relayConn net.PacketConn, config DiagnoseConfig) (EchoResult, error) {
+	pingerConn, err := net.ListenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		return EchoResult{}, fmt.Errorf("failed to listen: %w", err)
+	}
+	defer pingerConn.Close()
+
+	// relay 쪽은 받은 그대로 돌려준다.
+	go func() {
+		buf := make([]byte, 1600)
+		for {
+			n, from, err := relayConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if _, err := relayConn.WriteTo(buf[:n], from); err != nil {
+				return
+			}
+		}
+	}()
+
+	sentAt := make([]time.Time, config.Count)
+	rtts := make([]time.Duration, config.Count)
+	var mu sync.Mutex
+
+	// 모두 받으면 바로 끝난다.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 1600)
+		received := 0
+		for received < config.Count {
+			n, _, err := pingerConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 4 {
+				continue
+			}
+			seq := int(binary.BigEndian.Uint32(buf))
+			mu.Lock()
+			if seq < len(sentAt) && !sentAt[seq].IsZero() && rtts[seq] == 0 {
+				rtts[seq] = time.Since(sentAt[seq])
+				received++
+			}
+			mu.Unlock()
+		}
+	}()
+
+	packet := make([]byte, 64)
+	for seq := 0; seq < config.Count; seq++ {
+		binary.BigEndian.PutUint32(packet, uint32(seq))
+		mu.Lock()
+		sentAt[seq] = time.Now()
+		mu.Unlock()
+		if _, err := pingerConn.WriteTo(packet, relayConn.LocalAddr()); err != nil {
+			return EchoResult{}, fmt.Errorf("failed to send: %w", err)
+		}
+		time.Sleep(config.Interval)
+	}
+
+	// 늦게 오는 응답을 기다린다.
+	_ = pingerConn.SetReadDeadline(time.Now().Add(config.Timeout))
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var receivedRTTs []time.Duration
+	for _, rtt := range rtts {
+		if rtt > 0 {
+			receivedRTTs = append(receivedRTTs, rtt)
+		}
+	}
+	return EchoResult{
+		Sent:        config.Count,
+		Received:    len(receivedRTTs),
+		LossPercent: lossPercent(config.Count, len(receivedRTTs)),
+		RTTStats:    summarizeRTTs(receivedRTTs),
+	}, nil
+}
+
+func printReport(report any) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, "Error encoding report: ", err)
+	}
+}
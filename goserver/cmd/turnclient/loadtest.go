@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"server.firehunter.juhyung.dev/internal/natbehavior"
+)
+
+type LoadTestConfig struct {
+	Host string
+	Port int
+	// i 번째 allocation 의 계정. -secret 이면 allocation 마다 다른 사용자로 만들어서 사용자별 제한에 걸리지 않게 한다.
+	Credentials func(i int) Credentials
+	Allocations int
+	// allocation 하나가 보내는 bit/s
+	Bitrate int
+	// RTP 패킷 크기 정도
+	PacketSize int
+	Duration   time.Duration
+	// allocation 을 이 시간 동안 나눠서 연다.
+	RampUp  time.Duration
+	Timeout time.Duration
+}
+
+type AllocationResult struct {
+	Index        int     `json:"index"`
+	RelayAddress string  `json:"relayAddress,omitempty"`
+	Sent         int     `json:"sent"`
+	Received     int     `json:"received"`
+	LossPercent  float64 `json:"lossPercent"`
+	SentKbps     float64 `json:"sentKbps"`
+	ReceivedKbps float64 `json:"receivedKbps"`
+	RTTStats
+	Error string `json:"error,omitempty"`
+
+	rtts []time.Duration
+}
+
+type LoadTestReport struct {
+	Target      string    `json:"target"`
+	StartedAt   time.Time `json:"startedAt"`
+	Allocations int       `json:"allocations"`
+	BitrateKbps float64   `json:"bitrateKbps"`
+	PacketSize  int       `json:"packetSize"`
+	DurationSec float64   `json:"durationSec"`
+	// 성공한 allocation 들의 합
+	Succeeded    int     `json:"succeeded"`
+	Failed       int     `json:"failed"`
+	SentKbps     float64 `json:"sentKbps"`
+	ReceivedKbps float64 `json:"receivedKbps"`
+	LossPercent  float64 `json:"lossPercent"`
+	RTTStats
+	// 서버가 거절하거나 응답하지 않은 이유별 횟수 (예: 486 Allocation Quota Reached)
+	Failures map[string]int     `json:"failures,omitempty"`
+	Results  []AllocationResult `json:"results"`
+}
+
+// 패킷 앞부분: 번호 4바이트 + 보낸 시각 8바이트
+const loadHeaderSize = 12
+
+// runLoadTest는 allocation N 개를 동시에 열고 각각 relay 를 거쳐 로컬 echo peer 와 패킷을 주고받는다.
+// client → TURN → peer → TURN → client 를 한 바퀴로 잰다.
+func runLoadTest(config LoadTestConfig) LoadTestReport {
+	serverAddr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	report := LoadTestReport{
+		Target:      serverAddr,
+		StartedAt:   time.Now(),
+		Allocations: config.Allocations,
+		BitrateKbps: float64(config.Bitrate) / 1000,
+		PacketSize:  config.PacketSize,
+		DurationSec: config.Duration.Seconds(),
+		Failures:    make(map[string]int),
+		Results:     make([]AllocationResult, config.Allocations),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < config.Allocations; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if config.Allocations > 1 {
+				time.Sleep(config.RampUp * time.Duration(i) / time.Duration(config.Allocations))
+			}
+			report.Results[i] = runLoadAllocation(i, serverAddr, config)
+		}(i)
+	}
+	wg.Wait()
+
+	var rtts []time.Duration
+	sent, received := 0, 0
+	for _, result := range report.Results {
+		if result.Error != "" {
+			report.Failed++
+			report.Failures[result.Error]++
+			continue
+		}
+		report.Succeeded++
+		report.SentKbps += result.SentKbps
+		report.ReceivedKbps += result.ReceivedKbps
+		sent += result.Sent
+		received += result.Received
+		rtts = append(rtts, result.rtts...)
+	}
+	report.LossPercent = lossPercent(sent, received)
+	// allocation 들을 이어 붙인 순서는 의미가 없으므로 jitter 는 allocation 별로만 본다.
+	report.RTTStats = summarizeRTTs(rtts)
+	report.JitterMs = 0
+
+	return report
+}
+
+func runLoadAllocation(index int, serverAddr string, config LoadTestConfig) AllocationResult {
+	result := AllocationResult{Index: index}
+
+	conn, err := net.ListenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer conn.Close()
+
+	client, err := newTURNClient(conn, serverAddr, config.Credentials(index))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer client.Close()
+
+	// 늦게 만들어진 relay 는 바로 닫는다.
+	relayConn, err := withTimeout(config.Timeout, client.Allocate, func(late net.PacketConn) {
+		if late != nil {
+			late.Close()
+		}
+	})
+	if err != nil {
+		result.Error = fmt.Sprintf("allocate: %v", err)
+		return result
+	}
+	defer relayConn.Close()
+	result.RelayAddress = relayConn.LocalAddr().String()
+
+	// echo peer 는 TURN 서버가 보는 주소로 보내야 하므로 binding 으로 외부 주소를 알아낸다.
+	peerConn, err := net.ListenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer peerConn.Close()
+	binding, err := sendBinding(peerConn, client.STUNServerAddr().(*net.UDPAddr), natbehavior.ChangeRequest{}, config.Timeout)
+	if err != nil {
+		result.Error = fmt.Sprintf("binding: %v", err)
+		return result
+	}
+	_ = peerConn.SetReadDeadline(time.Time{})
+	peerAddr := binding.mapped
+	if _, err := withTimeout(config.Timeout, func() (struct{}, error) {
+		return struct{}{}, client.CreatePermission(peerAddr)
+	}, nil); err != nil {
+		result.Error = fmt.Sprintf("permission: %v", err)
+		return result
+	}
+	// peer 쪽 NAT 가 relay 주소에서 오는 패킷을 받도록 먼저 한 번 보낸다.
+	if _, err := peerConn.WriteTo([]byte("punch"), relayConn.LocalAddr()); err != nil {
+		result.Error = fmt.Sprintf("punch: %v", err)
+		return result
+	}
+
+	go func() {
+		buf := make([]byte, 1600)
+		for {
+			n, from, err := peerConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if _, err := peerConn.WriteTo(buf[:n], from); err != nil {
+				return
+			}
+		}
+	}()
+
+	interval := time.Duration(float64(time.Second) * float64(config.PacketSize*8) / float64(config.Bitrate))
+	count := int(config.Duration / interval)
+	if count == 0 {
+		count = 1
+	}
+	startedAt := time.Now()
+	rtts := make([]time.Duration, count)
+	var mu sync.Mutex
+
+	done := make(chan struct{})
+	receivedBytes := 0
+	go func() {
+		defer close(done)
+		buf := make([]byte, 1600)
+		received := 0
+		for received < count {
+			n, _, err := relayConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < loadHeaderSize {
+				continue
+			}
+			seq := int(binary.BigEndian.Uint32(buf))
+			sentAt := time.Duration(binary.BigEndian.Uint64(buf[4:]))
+			mu.Lock()
+			if seq < count && rtts[seq] == 0 {
+				rtts[seq] = time.Since(startedAt) - sentAt
+				received++
+				receivedBytes += n
+			}
+			mu.Unlock()
+		}
+	}()
+
+	// 일정한 간격을 유지하도록 다음 보낼 시각을 누적해서 계산한다.
+	packet := make([]byte, max(config.PacketSize, loadHeaderSize))
+	sent := 0
+	for seq := 0; seq < count; seq++ {
+		if wait := time.Duration(seq)*interval - time.Since(startedAt); wait > 0 {
+			time.Sleep(wait)
+		}
+		binary.BigEndian.PutUint32(packet, uint32(seq))
+		binary.BigEndian.PutUint64(packet[4:], uint64(time.Since(startedAt)))
+		if _, err := relayConn.WriteTo(packet, peerAddr); err != nil {
+			result.Error = fmt.Sprintf("send: %v", err)
+			break
+		}
+		sent++
+	}
+	sendDuration := time.Since(startedAt)
+
+	_ = relayConn.SetReadDeadline(time.Now().Add(config.Timeout))
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, rtt := range rtts {
+		if rtt > 0 {
+			result.rtts = append(result.rtts, rtt)
+		}
+	}
+	result.Sent = sent
+	result.Received = len(result.rtts)
+	result.LossPercent = lossPercent(sent, result.Received)
+	result.SentKbps = kbps(sent*len(packet), sendDuration)
+	result.ReceivedKbps = kbps(receivedBytes, sendDuration)
+	result.RTTStats = summarizeRTTs(result.rtts)
+	return result
+}
+
+func kbps(bytes int, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return math.Round(float64(bytes*8)/d.Seconds()/100) / 10
+}
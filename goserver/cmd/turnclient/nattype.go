@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pion/stun/v2"
+	"server.firehunter.juhyung.dev/internal/natbehavior"
+)
+
+// RFC 5780 4.3, 4.4 의 분류
+const (
+	behaviorNoNAT                   = "no-nat"
+	behaviorEndpointIndependent     = "endpoint-independent"
+	behaviorAddressDependent        = "address-dependent"
+	behaviorAddressAndPortDependent = "address-and-port-dependent"
+	behaviorUnknown                 = "unknown"
+)
+
+var errNoResponse = errors.New("no response")
+
+type NATTypeReport struct {
+	Target        string `json:"target"`
+	LocalAddress  string `json:"localAddress,omitempty"`
+	MappedAddress string `json:"mappedAddress,omitempty"`
+	OtherAddress  string `json:"otherAddress,omitempty"`
+	Mapping       string `json:"mapping"`
+	Filtering     string `json:"filtering"`
+	// mapping 이 endpoint-independent 가 아니면 (symmetric NAT) 태블릿끼리 직접 연결할 수 없다.
+	TURNRequired bool   `json:"turnRequired"`
+	Error        string `json:"error,omitempty"`
+}
+
+type bindingResult struct {
+	mapped *net.UDPAddr
+	other  *net.UDPAddr
+	origin *net.UDPAddr
+}
+
+// sendBinding은 응답이 올 때까지 몇 번 다시 보낸다.
+// CHANGE-REQUEST 응답은 다른 주소에서 오므로 transaction ID 로만 맞춘다.
+func sendBinding(conn net.PacketConn, to *net.UDPAddr, change natbehavior.ChangeRequest, timeout time.Duration) (bindingResult, error) {
+	request, err := stun.Build(stun.TransactionID, stun.BindingRequest, change, stun.Fingerprint)
+	if err != nil {
+		return bindingResult{}, err
+	}
+
+	const retries = 3
+	buf := make([]byte, 1500)
+	for attempt := 0; attempt < retries; attempt++ {
+		if _, err := conn.WriteTo(request.Raw, to); err != nil {
+			return bindingResult{}, fmt.Errorf("failed to send binding request: %w", err)
+		}
+
+		deadline := time.Now().Add(timeout / retries)
+		_ = conn.SetReadDeadline(deadline)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return bindingResult{}, err
+			}
+
+			response := &stun.Message{Raw: append([]byte{}, buf[:n]...)}
+			if err := response.Decode(); err != nil || response.TransactionID != request.TransactionID {
+				continue
+			}
+			if response.Type != stun.BindingSuccess {
+				return bindingResult{}, fmt.Errorf("binding failed: %s", response.Type)
+			}
+			return parseBindingResponse(response)
+		}
+	}
+	return bindingResult{}, errNoResponse
+}
+
+func parseBindingResponse(m *stun.Message) (bindingResult, error) {
+	var result bindingResult
+
+	var mapped stun.XORMappedAddress
+	if err := mapped.GetFrom(m); err != nil {
+		return result, fmt.Errorf("no XOR-MAPPED-ADDRESS: %w", err)
+	}
+	result.mapped = &net.UDPAddr{IP: mapped.IP, Port: mapped.Port}
+
+	var other stun.MappedAddress
+	if err := other.GetFromAs(m, stun.AttrOtherAddress); err == nil {
+		result.other = &net.UDPAddr{IP: other.IP, Port: other.Port}
+	}
+	var origin stun.MappedAddress
+	if err := origin.GetFromAs(m, stun.AttrResponseOrigin); err == nil {
+		result.origin = &net.UDPAddr{IP: origin.IP, Port: origin.Port}
+	}
+	return result, nil
+}
+
+// runNATType은 RFC 5780 의 mapping/filtering 테스트를 한다.
+// 서버가 OTHER-ADDRESS 를 주지 않으면 (stunserver -other-ip 없이 실행) 분류할 수 없다.
+func runNATType(host string, port int, timeout time.Duration) NATTypeReport {
+	report := NATTypeReport{
+		Target:    net.JoinHostPort(host, fmt.Sprint(port)),
+		Mapping:   behaviorUnknown,
+		Filtering: behaviorUnknown,
+	}
+
+	serverAddr, err := net.ResolveUDPAddr("udp4", report.Target)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	if err := discoverMapping(&report, serverAddr, timeout); err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	// filtering 은 다른 주소로 보낸 적 없는 새 소켓으로 시험해야 NAT 에 남은 상태가 섞이지 않는다.
+	if err := discoverFiltering(&report, serverAddr, timeout); err != nil {
+		report.Error = err.Error()
+	}
+
+	report.TURNRequired = report.Mapping != behaviorNoNAT && report.Mapping != behaviorEndpointIndependent
+	return report
+}
+
+func discoverMapping(report *NATTypeReport, serverAddr *net.UDPAddr, timeout time.Duration) error {
+	conn, err := net.ListenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Test I
+	first, err := sendBinding(conn, serverAddr, natbehavior.ChangeRequest{}, timeout)
+	if err != nil {
+		return fmt.Errorf("mapping test I: %w", err)
+	}
+	report.MappedAddress = first.mapped.String()
+	report.LocalAddress = localAddress(conn, serverAddr)
+	if first.other == nil {
+		return errors.New("server does not support NAT behavior discovery (no OTHER-ADDRESS)")
+	}
+	report.OtherAddress = first.other.String()
+
+	if report.MappedAddress == report.LocalAddress {
+		report.Mapping = behaviorNoNAT
+		return nil
+	}
+
+	// Test II: 다른 IP, 같은 포트
+	second, err := sendBinding(conn, &net.UDPAddr{IP: first.other.IP, Port: serverAddr.Port}, natbehavior.ChangeRequest{}, timeout)
+	if err != nil {
+		return fmt.Errorf("mapping test II: %w", err)
+	}
+	if second.mapped.String() == first.mapped.String() {
+		report.Mapping = behaviorEndpointIndependent
+		return nil
+	}
+
+	// Test III: 다른 IP, 다른 포트
+	third, err := sendBinding(conn, first.other, natbehavior.ChangeRequest{}, timeout)
+	if err != nil {
+		return fmt.Errorf("mapping test III: %w", err)
+	}
+	if third.mapped.String() == second.mapped.String() {
+		report.Mapping = behaviorAddressDependent
+	} else {
+		report.Mapping = behaviorAddressAndPortDependent
+	}
+	return nil
+}
+
+func discoverFiltering(report *NATTypeReport, serverAddr *net.UDPAddr, timeout time.Duration) error {
+	conn, err := net.ListenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Test I
+	if _, err := sendBinding(conn, serverAddr, natbehavior.ChangeRequest{}, timeout); err != nil {
+		return fmt.Errorf("filtering test I: %w", err)
+	}
+
+	// Test II: 다른 IP, 다른 포트에서 답하게 한다.
+	_, err = sendBinding(conn, serverAddr, natbehavior.ChangeRequest{ChangeIP: true, ChangePort: true}, timeout)
+	if err == nil {
+		report.Filtering = behaviorEndpointIndependent
+		return nil
+	}
+	if !errors.Is(err, errNoResponse) {
+		return fmt.Errorf("filtering test II: %w", err)
+	}
+
+	// Test III: 같은 IP, 다른 포트에서 답하게 한다.
+	_, err = sendBinding(conn, serverAddr, natbehavior.ChangeRequest{ChangePort: true}, timeout)
+	if err == nil {
+		report.Filtering = behaviorAddressDependent
+		return nil
+	}
+	if !errors.Is(err, errNoResponse) {
+		return fmt.Errorf("filtering test III: %w", err)
+	}
+	report.Filtering = behaviorAddressAndPortDependent
+	return nil
+}
+
+// localAddress는 0.0.0.0 대신 서버로 나가는 인터페이스의 IP 를 돌려준다.
+func localAddress(conn net.PacketConn, serverAddr *net.UDPAddr) string {
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	probe, err := net.DialUDP("udp4", nil, serverAddr)
+	if err != nil {
+		return conn.LocalAddr().String()
+	}
+	defer probe.Close()
+	return (&net.UDPAddr{IP: probe.LocalAddr().(*net.UDPAddr).IP, Port: port}).String()
+}
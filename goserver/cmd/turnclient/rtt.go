@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+type RTTStats struct {
+	MinMs float64 `json:"rttMinMs"`
+	AvgMs float64 `json:"rttAvgMs"`
+	P50Ms float64 `json:"rttP50Ms"`
+	P95Ms float64 `json:"rttP95Ms"`
+	P99Ms float64 `json:"rttP99Ms"`
+	MaxMs float64 `json:"rttMaxMs"`
+	// 보낸 순서대로 이웃한 왕복 시간 차이의 평균
+	JitterMs float64 `json:"jitterMs"`
+}
+
+// summarizeRTTs는 보낸 순서대로 받은 왕복 시간을 요약한다.
+func summarizeRTTs(rtts []time.Duration) RTTStats {
+	if len(rtts) == 0 {
+		return RTTStats{}
+	}
+
+	var total, jitter time.Duration
+	for i, rtt := range rtts {
+		total += rtt
+		if i > 0 {
+			diff := rtt - rtts[i-1]
+			if diff < 0 {
+				diff = -diff
+			}
+			jitter += diff
+		}
+	}
+
+	sorted := slices.Clone(rtts)
+	slices.Sort(sorted)
+
+	stats := RTTStats{
+		MinMs: milliseconds(sorted[0]),
+		AvgMs: milliseconds(total / time.Duration(len(rtts))),
+		P50Ms: milliseconds(percentile(sorted, 50)),
+		P95Ms: milliseconds(percentile(sorted, 95)),
+		P99Ms: milliseconds(percentile(sorted, 99)),
+		MaxMs: milliseconds(sorted[len(sorted)-1]),
+	}
+	if len(rtts) > 1 {
+		stats.JitterMs = milliseconds(jitter / time.Duration(len(rtts)-1))
+	}
+	return stats
+}
+
+// percentile은 정렬된 값에서 nearest-rank 방식으로 고른다.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank-1, 0)]
+}
+
+func lossPercent(sent, received int) float64 {
+	if sent == 0 {
+		return 0
+	}
+	return float64(sent-received) * 100 / float64(sent)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"os"
 	"strings"
 	"time"
 
@@ -24,6 +25,19 @@ func main() {
 	user := flag.String("user", "juhyung=juhyung", "A pair of username and password (e.g. \"user=pass\")")
 	realm := flag.String("realm", "turn.i.juhyung.dev", "Realm (defaults to \"pion.ly\")")
 	ping := flag.Bool("ping", false, "Run ping test")
+	secret := flag.String("secret", os.Getenv("FIREHUNTER_TURN_SECRET"), "Shared secret of the TURN server. When set, ephemeral credentials are generated instead of -user")
+	mode := flag.String("mode", "allocate", "allocate: allocate a relay (and -ping). diagnose: run every check and print a JSON report. nat-type: classify the NAT with a stunserver started with -other-ip. load: relay synthetic traffic through -allocations allocations")
+	tcpPort := flag.Int("tcp-port", 3478, "TURN over TCP port checked by diagnose. 0 skips it")
+	tlsPort := flag.Int("tls-port", 5349, "TURN over TLS port checked by diagnose. 0 skips it")
+	count := flag.Int("count", 50, "Number of echo packets sent by diagnose")
+	interval := flag.Duration("interval", 20*time.Millisecond, "Interval between echo packets")
+	timeout := flag.Duration("timeout", 5*time.Second, "Timeout of each diagnose step")
+	maxLoss := flag.Float64("max-loss", 5, "Echo fails when more than this percent of packets are lost")
+	allocations := flag.Int("allocations", 10, "Number of concurrent allocations opened by load")
+	bitrate := flag.Int("bitrate", 4000, "kbit/s sent through each allocation by load")
+	packetSize := flag.Int("packet-size", 1200, "Size of packets sent by load")
+	duration := flag.Duration("duration", 30*time.Second, "How long load sends packets")
+	rampUp := flag.Duration("ramp-up", 2*time.Second, "Load opens allocations spread over this duration")
 	flag.Parse()
 
 	if len(*host) == 0 {
@@ -35,6 +49,81 @@ func main() {
 	}
 
 	cred := strings.SplitN(*user, "=", 2)
+	if len(*secret) > 0 {
+		// 시그널링 서버가 주는 것과 같은 임시 계정
+		username, password, err := turn.GenerateLongTermTURNRESTCredentials(*secret, "turnclient", time.Hour)
+		if err != nil {
+			log.Panicf("Failed to generate credentials: %s", err)
+		}
+		cred = []string{username, password}
+	}
+	if len(cred) != 2 {
+		log.Fatalf("'user' must be \"user=pass\"")
+	}
+
+	credentials := Credentials{Username: cred[0], Password: cred[1], Realm: *realm}
+
+	switch *mode {
+	case "allocate":
+	case "diagnose":
+		if *count <= 0 {
+			log.Fatalf("'count' must be positive")
+		}
+		report := runDiagnose(DiagnoseConfig{
+			Credentials:    credentials,
+			Host:           *host,
+			Port:           *port,
+			TCPPort:        *tcpPort,
+			TLSPort:        *tlsPort,
+			Count:          *count,
+			Interval:       *interval,
+			Timeout:        *timeout,
+			MaxLossPercent: *maxLoss,
+		})
+		printReport(report)
+		if !report.Pass {
+			os.Exit(1)
+		}
+		return
+	case "nat-type":
+		report := runNATType(*host, *port, *timeout)
+		printReport(report)
+		if report.Error != "" {
+			os.Exit(1)
+		}
+		return
+	case "load":
+		if *allocations <= 0 || *bitrate <= 0 || *packetSize <= 0 {
+			log.Fatalf("'allocations', 'bitrate' and 'packet-size' must be positive")
+		}
+		report := runLoadTest(LoadTestConfig{
+			Host: *host,
+			Port: *port,
+			Credentials: func(i int) Credentials {
+				if len(*secret) == 0 {
+					return credentials
+				}
+				username, password, err := turn.GenerateLongTermTURNRESTCredentials(*secret, fmt.Sprintf("turnclient-load-%d", i), time.Hour)
+				if err != nil {
+					log.Panicf("Failed to generate credentials: %s", err)
+				}
+				return Credentials{Username: username, Password: password, Realm: *realm}
+			},
+			Allocations: *allocations,
+			Bitrate:     *bitrate * 1000,
+			PacketSize:  *packetSize,
+			Duration:    *duration,
+			RampUp:      *rampUp,
+			Timeout:     *timeout,
+		})
+		printReport(report)
+		if report.Failed > 0 {
+			os.Exit(1)
+		}
+		return
+	default:
+		log.Fatalf("unknown mode: %s", *mode)
+	}
 
 	// TURN client won't create a local listening socket by itself.
 	conn, err := net.ListenPacket("udp4", "0.0.0.0:0")
@@ -50,7 +139,7 @@ func main() {
 	turnServerAddr := fmt.Sprintf("%s:%d", *host, *port)
 
 	cfg := &turn.ClientConfig{
-		STUNServerAddr: "",
+		STUNServerAddr: turnServerAddr,
 		TURNServerAddr: turnServerAddr,
 		Conn:           conn,
 		Username:       cred[0],
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pion/logging"
+	"github.com/pion/turn/v3"
+	"server.firehunter.juhyung.dev/internal/turnusers"
+)
+
+// newAuthHandler는 -users 파일의 고정 사용자를 먼저 보고,
+// "만료시각:사용자" 형식이면 시그널링 서버가 발급한 임시 계정으로 검사한다.
+// 만료된 임시 계정은 LongTermTURNRESTAuthHandler 가 거절함
+func newAuthHandler(users *turnusers.Store, secret string, logger logging.LeveledLogger) turn.AuthHandler {
+	var restAuthHandler turn.AuthHandler
+	if len(secret) > 0 {
+		restAuthHandler = turn.LongTermTURNRESTAuthHandler(secret, logger)
+	}
+
+	return func(username string, realm string, srcAddr net.Addr) ([]byte, bool) { // nolint: revive
+		if users != nil {
+			if key, ok := users.AuthKey(username, realm); ok {
+				return key, true
+			}
+		}
+
+		if restAuthHandler != nil && strings.Contains(username, ":") {
+			if key, ok := restAuthHandler(username, realm, srcAddr); ok {
+				return key, true
+			}
+			fmt.Printf("auth failed: invalid or expired ephemeral username: %s realm: %s from %v\n", username, realm, srcAddr)
+			return nil, false
+		}
+
+		fmt.Printf("auth failed: unknown or disabled username: %s realm: %s from %v\n", username, realm, srcAddr)
+		return nil, false
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/pion/turn/v3"
+)
+
+// UDP 를 막는 공유기 뒤의 태블릿을 위해 TCP 와 TLS(TURNS) 로도 받는다.
+// 어떤 listener 로 들어와도 relay 주소는 UDP 로 나간다.
+func createListenerConfigs(tcpPort int, tlsPort int, certFile string, keyFile string, newRelayAddressGenerator func() turn.RelayAddressGenerator) ([]turn.ListenerConfig, error) {
+	var configs []turn.ListenerConfig
+
+	if tcpPort > 0 {
+		tcpListener, err := net.Listen("tcp4", "0.0.0.0:"+strconv.Itoa(tcpPort))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create TCP listener: %w", err)
+		}
+		configs = append(configs, turn.ListenerConfig{
+			Listener:              tcpListener,
+			RelayAddressGenerator: newRelayAddressGenerator(),
+		})
+	}
+
+	if tlsPort > 0 {
+		// localhttps 와 같은 인증서를 쓴다.
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			closeListeners(configs)
+			return nil, fmt.Errorf("failed to load TLS certificate (use -tls-port 0 to disable TLS): %w", err)
+		}
+
+		tlsListener, err := tls.Listen("tcp4", "0.0.0.0:"+strconv.Itoa(tlsPort), &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+		})
+		if err != nil {
+			closeListeners(configs)
+			return nil, fmt.Errorf("failed to create TLS listener: %w", err)
+		}
+		configs = append(configs, turn.ListenerConfig{
+			Listener:              tlsListener,
+			RelayAddressGenerator: newRelayAddressGenerator(),
+		})
+	}
+
+	return configs, nil
+}
+
+func closeListeners(configs []turn.ListenerConfig) {
+	for _, config := range configs {
+		config.Listener.Close()
+	}
+}
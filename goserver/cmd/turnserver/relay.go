@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3"
+)
+
+// RelayInterface는 relay 소켓을 열 로컬 주소와 클라이언트에게 알려줄 공인 IP 의 쌍이다.
+type RelayInterface struct {
+	PublicIP     net.IP
+	LocalAddress string
+}
+
+// parseRelayInterfaces는 "공인IP/로컬IP,공인IP/로컬IP" 를 읽는다.
+// 로컬 IP 를 생략하면 모든 인터페이스(0.0.0.0), 공인 IP 를 생략하면 publicIP() 를 쓴다.
+func parseRelayInterfaces(relays string, publicIP func() (net.IP, error)) ([]RelayInterface, error) {
+	if strings.TrimSpace(relays) == "" {
+		relays = "/"
+	}
+
+	var interfaces []RelayInterface
+	for _, relay := range strings.Split(relays, ",") {
+		publicPart, localPart, _ := strings.Cut(strings.TrimSpace(relay), "/")
+
+		relayInterface := RelayInterface{LocalAddress: "0.0.0.0"}
+		if localPart != "" {
+			if net.ParseIP(localPart) == nil {
+				return nil, fmt.Errorf("invalid local relay address: %s", localPart)
+			}
+			relayInterface.LocalAddress = localPart
+		}
+
+		if publicPart != "" {
+			relayInterface.PublicIP = net.ParseIP(publicPart)
+			if relayInterface.PublicIP == nil {
+				return nil, fmt.Errorf("invalid public relay address: %s", publicPart)
+			}
+		} else {
+			ip, err := publicIP()
+			if err != nil {
+				return nil, err
+			}
+			relayInterface.PublicIP = ip
+		}
+
+		interfaces = append(interfaces, relayInterface)
+	}
+
+	return interfaces, nil
+}
+
+// newRelayAddressGenerator는 port 범위가 주어지면 그 범위 안에서만 relay 소켓을 연다.
+// 방화벽에서 정해진 포트만 열어둔 호스트에서 쓴다.
+func (r RelayInterface) newRelayAddressGenerator(minPort, maxPort uint16) turn.RelayAddressGenerator {
+	if minPort == 0 && maxPort == 0 {
+		return &turn.RelayAddressGeneratorStatic{
+			RelayAddress: r.PublicIP,     // Claim that we are listening on IP passed by user (This should be your Public IP)
+			Address:      r.LocalAddress, // But actually be listening on this interface
+		}
+	}
+
+	return &turn.RelayAddressGeneratorPortRange{
+		RelayAddress: r.PublicIP,
+		Address:      r.LocalAddress,
+		MinPort:      minPort,
+		MaxPort:      maxPort,
+	}
+}
+
+func (r RelayInterface) String() string {
+	return r.PublicIP.String() + "/" + r.LocalAddress
+}
+
+// resolvePublicIP는 -public-ip 가 없으면 STUN 서버에 물어봐서 공인 IP 를 알아낸다.
+func resolvePublicIP(publicIP string, stunServer string) (net.IP, error) {
+	if publicIP != "" {
+		ip := net.ParseIP(publicIP)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid public ip: %s", publicIP)
+		}
+		return ip, nil
+	}
+
+	if stunServer == "" {
+		return nil, fmt.Errorf("'public-ip' or 'discover-stun' is required")
+	}
+
+	ip, err := discoverPublicIP(stunServer, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover public ip from %s: %w", stunServer, err)
+	}
+	fmt.Printf("Discovered public ip %s from %s\n", ip, stunServer)
+	return ip, nil
+}
+
+func discoverPublicIP(stunServer string, timeout time.Duration) (net.IP, error) {
+	conn, err := net.DialTimeout("udp4", stunServer, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial: %w", err)
+	}
+
+	client, err := stun.NewClient(conn, stun.WithRTO(timeout/5))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create STUN client: %w", err)
+	}
+	defer client.Close()
+
+	var (
+		mappedIP net.IP
+		doErr    error
+	)
+	message := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if err := client.Do(message, func(event stun.Event) {
+		if event.Error != nil {
+			doErr = event.Error
+			return
+		}
+
+		var xorAddr stun.XORMappedAddress
+		if err := xorAddr.GetFrom(event.Message); err != nil {
+			doErr = err
+			return
+		}
+		mappedIP = xorAddr.IP
+	}); err != nil {
+		return nil, fmt.Errorf("failed to send binding request: %w", err)
+	}
+	if doErr != nil {
+		return nil, doErr
+	}
+
+	return mappedIP, nil
+}
@@ -13,70 +13,155 @@ import (
 	"os/signal"
 	"strconv"
 	"syscall"
+	"time"
 
+	"github.com/pion/logging"
 	"github.com/pion/turn/v3"
+	"server.firehunter.juhyung.dev/internal/peeracl"
+	"server.firehunter.juhyung.dev/internal/turnadmin"
+	"server.firehunter.juhyung.dev/internal/turnguard"
+	"server.firehunter.juhyung.dev/internal/turnusers"
 )
 
 func main() {
-	// publicIP := flag.String("public-ip", "", "IP Address that TURN can be contacted by.")
-	publicIP := "3.34.13.104"
+	publicIP := flag.String("public-ip", os.Getenv("FIREHUNTER_PUBLIC_IP"), "IP Address that TURN can be contacted by (env FIREHUNTER_PUBLIC_IP). Discovered with -discover-stun when empty")
+	discoverSTUN := flag.String("discover-stun", "stun.l.google.com:19302", "STUN server asked for the public IP when -public-ip is empty")
+	relays := flag.String("relay", "", "Comma separated public/local relay interface pairs (e.g. \"3.34.13.104/172.31.0.5,10.0.0.1/10.0.0.1\"). Defaults to -public-ip on every interface")
+	minPort := flag.Int("min-port", 0, "Lowest port of relayed sockets. 0 with -max-port 0 allows any port")
+	maxPort := flag.Int("max-port", 0, "Highest port of relayed sockets")
 	port := flag.Int("port", 3478, "Listening port.")
-	// users := flag.String("users", "", "List of username and password (e.g. \"user=pass,user=pass\")")
+	tcpPort := flag.Int("tcp-port", 3478, "Listening port for TURN over TCP. 0 disables TCP")
+	tlsPort := flag.Int("tls-port", 0, "Listening port for TURN over TLS (TURNS, usually 5349). Needs -cert and -key. 0 disables TLS")
+	certFile := flag.String("cert", "./resource/i.juhyung.dev/fullchain.pem", "TLS certificate chain")
+	keyFile := flag.String("key", "./resource/i.juhyung.dev/privkey.pem", "TLS private key")
+	users := flag.String("users", "", "Users file managed by cmd/turnusers. Reloaded on SIGHUP")
 	realm := flag.String("realm", "turn.i.juhyung.dev", "Realm (defaults to \"pion.ly\")")
+	secret := flag.String("secret", os.Getenv("FIREHUNTER_TURN_SECRET"), "Shared secret with the signalling server for ephemeral credentials (env FIREHUNTER_TURN_SECRET)")
+	maxAllocationsPerUser := flag.Int("max-allocations-per-user", 5, "Maximum live allocations of a user. 0 is unlimited")
+	maxAllocationsPerIP := flag.Int("max-allocations-per-ip", 10, "Maximum live allocations from a client IP. 0 is unlimited")
+	maxPermissionsPerUser := flag.Int("max-permissions-per-user", 20, "Maximum peer permissions of a user across allocations. 0 is unlimited")
+	bandwidthPerUser := flag.Int("bandwidth-per-user", 0, "Relayed kbit/s per user. 0 is unlimited")
+	maxAllocationLifetime := flag.Duration("max-allocation-lifetime", 6*time.Hour, "Allocations are not refreshed after this. 0 is unlimited")
+	allowPeers := flag.String("allow-peers", "", "Comma separated peer CIDRs allowed even when they are in -deny-peers (e.g. \"10.1.0.0/16\")")
+	denyPeers := flag.String("deny-peers", peeracl.DefaultDeny, "Comma separated peer CIDRs that allocations can not relay to")
+	admin := flag.String("admin", "", "Address of the admin HTTP server with /metrics, /allocations and /healthz (e.g. \"127.0.0.1:9478\"). Empty disables it")
 	flag.Parse()
 
-	// if len(*users) == 0 {
-	// 	log.Fatalf("'users' is required")
-	// }
+	if len(*secret) == 0 && len(*users) == 0 {
+		log.Fatalf("'secret' or 'users' is required")
+	}
+
+	var usersStore *turnusers.Store
+	if len(*users) > 0 {
+		var err error
+		usersStore, err = turnusers.NewStore(*users)
+		if err != nil {
+			log.Panicf("Failed to load users: %s", err)
+		}
+		fmt.Printf("Loaded %d users from %s\n", usersStore.Len(), *users)
+	}
+
+	if *minPort < 0 || *maxPort > 65535 || *minPort > *maxPort || (*minPort == 0) != (*maxPort == 0) {
+		log.Fatalf("invalid relay port range: %d-%d", *minPort, *maxPort)
+	}
+
+	relayInterfaces, err := parseRelayInterfaces(*relays, func() (net.IP, error) {
+		return resolvePublicIP(*publicIP, *discoverSTUN)
+	})
+	if err != nil {
+		log.Panicf("Failed to configure relay interfaces: %s", err)
+	}
+
+	peerACL, err := peeracl.New(*allowPeers, *denyPeers)
+	if err != nil {
+		log.Fatalf("invalid peer ACL: %s", err)
+	}
+	// relay 주소로 다시 relay 하면 서버 안에서 빙빙 돌거나 내부 서비스에 닿는다.
+	for _, relayInterface := range relayInterfaces {
+		peerACL.DenySelf(relayInterface.PublicIP, net.ParseIP(relayInterface.LocalAddress))
+	}
+	fmt.Println("Peers", peerACL)
+
+	quota := turnguard.NewQuota(turnguard.Limits{
+		MaxAllocationsPerUser: *maxAllocationsPerUser,
+		MaxAllocationsPerIP:   *maxAllocationsPerIP,
+		MaxPermissionsPerUser: *maxPermissionsPerUser,
+		BandwidthPerUser:      *bandwidthPerUser * 1000 / 8,
+		MaxAllocationLifetime: *maxAllocationLifetime,
+	})
+	defer quota.Close()
 
 	// Create a UDP listener to pass into pion/turn
 	// pion/turn itself doesn't allocate any UDP sockets, but lets the user pass them in
 	// this allows us to add logging, storage or modify inbound/outbound traffic
-	udpListener, err := net.ListenPacket("udp4", "0.0.0.0:"+strconv.Itoa(*port))
-	if err != nil {
-		log.Panicf("Failed to create TURN server listener: %s", err)
+	// relay 인터페이스마다 listener 를 하나씩 열어서 들어온 인터페이스로 relay 한다.
+	// listener 는 quota 로 감싸서 사용자별 allocation 수와 대역폭을 제한한다.
+	var packetConnConfigs []turn.PacketConnConfig
+	for _, relayInterface := range relayInterfaces {
+		udpListener, err := net.ListenPacket("udp4", relayInterface.LocalAddress+":"+strconv.Itoa(*port))
+		if err != nil {
+			log.Panicf("Failed to create TURN server listener: %s", err)
+		}
+		packetConnConfigs = append(packetConnConfigs, turn.PacketConnConfig{
+			PacketConn:            quota.Wrap(udpListener),
+			RelayAddressGenerator: relayInterface.newRelayAddressGenerator(uint16(*minPort), uint16(*maxPort)),
+			PermissionHandler:     peerACL.PermissionHandler(),
+		})
+		fmt.Printf("Relay %s ports %d-%d\n", relayInterface, *minPort, *maxPort)
 	}
 
-	// Cache -users flag for easy lookup later
-	// If passwords are stored they should be saved to your DB hashed using turn.GenerateAuthKey
-	// usersMap := map[string][]byte{}
-	// for _, kv := range regexp.MustCompile(`(\w+)=(\w+)`).FindAllStringSubmatch(*users, -1) {
-	// 	usersMap[kv[1]] = turn.GenerateAuthKey(kv[1], *realm, kv[2])
-	// }
+	// TCP/TLS 는 첫 번째 relay 인터페이스로 relay 한다. UDP 와 같은 quota 로 감싼다.
+	listenerConfigs, err := createListenerConfigs(*tcpPort, *tlsPort, *certFile, *keyFile, func() turn.RelayAddressGenerator {
+		return relayInterfaces[0].newRelayAddressGenerator(uint16(*minPort), uint16(*maxPort))
+	})
+	if err != nil {
+		log.Fatalf("Failed to create TURN server listeners: %s", err)
+	}
+	for i := range listenerConfigs {
+		listenerConfigs[i].Listener = quota.WrapListener(listenerConfigs[i].Listener)
+		listenerConfigs[i].PermissionHandler = peerACL.PermissionHandler()
+	}
 
 	s, err := turn.NewServer(turn.ServerConfig{
 		Realm: *realm,
 		// Set AuthHandler callback
 		// This is called every time a user tries to authenticate with the TURN server
 		// Return the key for that user, or false when no user is found
-		AuthHandler: func(username string, realm string, srcAddr net.Addr) ([]byte, bool) { // nolint: revive
-			// if key, ok := usersMap[username]; ok {
-			// 	return key, true
-			// }
-			fmt.Println("username: ", username)
-			return []byte(username), true
-			// return nil, false
-		},
+		AuthHandler: newAuthHandler(usersStore, *secret, logging.NewDefaultLoggerFactory().NewLogger("turn")),
 		// PacketConnConfigs is a list of UDP Listeners and the configuration around them
-		PacketConnConfigs: []turn.PacketConnConfig{
-			{
-				PacketConn: udpListener,
-				RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{
-					RelayAddress: net.ParseIP(publicIP), // Claim that we are listening on IP passed by user (This should be your Public IP)
-					Address:      "0.0.0.0",             // But actually be listening on every interface
-				},
-			},
-		},
+		PacketConnConfigs: packetConnConfigs,
+		// ListenerConfigs is a list of TCP/TLS Listeners
+		ListenerConfigs: listenerConfigs,
 	})
 	if err != nil {
 		log.Panic(err)
 	}
 
-	fmt.Println("Listening on", udpListener.LocalAddr())
+	for _, config := range packetConnConfigs {
+		fmt.Println("Listening on", config.PacketConn.LocalAddr())
+	}
+	for _, config := range listenerConfigs {
+		fmt.Println("Listening on", config.Listener.Addr())
+	}
+	turnadmin.ListenAndServe(*admin, quota, nil)
 	// Block until user sends SIGINT or SIGTERM
+	// SIGHUP 은 사용자 파일만 다시 읽고 allocation 은 그대로 둔다.
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	<-sigs
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigs {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if usersStore == nil {
+			fmt.Println("SIGHUP ignored: no users file")
+			continue
+		}
+		if err := usersStore.Reload(); err != nil {
+			fmt.Printf("Failed to reload users, keeping previous users: %v\n", err)
+			continue
+		}
+		fmt.Printf("Reloaded %d users from %s\n", usersStore.Len(), *users)
+	}
 
 	if err = s.Close(); err != nil {
 		log.Panic(err)
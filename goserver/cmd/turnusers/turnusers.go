@@ -0,0 +1,111 @@
+// Package main은 turnserver 의 -users 파일에 사용자를 추가/삭제/비활성화한다.
+// 파일을 바꾼 뒤 turnserver 에 SIGHUP 을 보내면 다시 읽는다.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"server.firehunter.juhyung.dev/internal/turnusers"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: turnusers [flags] <command> [args]
+
+Commands:
+  add <username> [password]   add a user or change its password (reads password from stdin if omitted)
+  remove <username>           remove a user
+  disable <username>          keep the user but reject authentication
+  enable <username>           enable a disabled user
+  list                        list users
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	path := flag.String("users", "turnusers.json", "Path of the users file shared with turnserver")
+	realm := flag.String("realm", "turn.i.juhyung.dev", "Realm the keys are generated for (must match turnserver -realm)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	file, err := turnusers.Load(*path)
+	// 첫 add 는 새 파일을 만든다. 다른 명령은 잘못 적은 경로를 빈 목록으로 보지 않게 실패한다.
+	if errors.Is(err, os.ErrNotExist) && flag.Arg(0) == "add" {
+		file, err = &turnusers.File{}, nil
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	if command == "list" {
+		for _, user := range file.Users {
+			state := "enabled"
+			if user.Disabled {
+				state = "disabled"
+			}
+			fmt.Printf("%s\t%s\t%s\n", user.Username, user.Realm, state)
+		}
+		return
+	}
+
+	if len(args) == 0 {
+		log.Fatalf("'%s' needs a username", command)
+	}
+	username := args[0]
+
+	switch command {
+	case "add":
+		password := ""
+		if len(args) > 1 {
+			password = args[1]
+		} else {
+			password, err = readPassword()
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if len(password) == 0 {
+			log.Fatalf("password is empty")
+		}
+		file.Set(username, *realm, password)
+	case "remove":
+		err = file.Remove(username, *realm)
+	case "disable":
+		err = file.SetDisabled(username, *realm, true)
+	case "enable":
+		err = file.SetDisabled(username, *realm, false)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := file.Save(*path); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s %s@%s, send SIGHUP to turnserver to apply\n", command, username, *realm)
+}
+
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && len(line) == 0 {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"server.firehunter.juhyung.dev/internal/fec"
+	"server.firehunter.juhyung.dev/internal/moviecatalog"
+	"server.firehunter.juhyung.dev/internal/playout"
+)
+
+// Broadcast는 영화 하나를 모든 peer 에게 같은 화면 순서로 보낸다. 파일은 한 번만 읽고 재생 goroutine 도 하나만 돈다.
+// track 은 peer 마다 따로 두어 keyframe 과 simulcast layer 를 peer 마다 고른다.
+type Broadcast struct {
+	MovieID string
+	// 재생 시각은 첫 layer 의 clip 으로 정한다. 영상이 없으면 nil
+	clip   *playout.Clip
+	layers []Layer
+	audio  *playout.Clip
+
+	mu      sync.Mutex
+	viewers map[*Viewer]struct{}
+	cancel  context.CancelFunc
+	// 마지막으로 시작한 재생 goroutine 이 끝나면 닫힘
+	done chan struct{}
+}
+
+type Broadcasts struct {
+	broadcasts map[string]*Broadcast
+	mu         sync.Mutex
+}
+
+var (
+	broadcasts = Broadcasts{broadcasts: make(map[string]*Broadcast)}
+)
+
+// getBroadcast는 처음 부를 때 영상을 읽어서 Broadcast 를 만든다.
+func getBroadcast(movieID string) (*Broadcast, error) {
+	broadcasts.mu.Lock()
+	defer broadcasts.mu.Unlock()
+
+	if broadcast, ok := broadcasts.broadcasts[movieID]; ok {
+		return broadcast, nil
+	}
+
+	layers, videoErr := loadLayers(movieID)
+	if videoErr != nil {
+		fmt.Printf("movie %s: no video: %v\n", movieID, videoErr)
+	}
+	var clip *playout.Clip
+	if len(layers) > 0 {
+		clip = layers[0].clip
+	}
+	audio, audioErr := loadAudio(movieID)
+	if audioErr != nil {
+		fmt.Printf("movie %s: no audio: %v\n", movieID, audioErr)
+	}
+	if clip == nil && audio == nil {
+		return nil, videoErr
+	}
+
+	broadcast := &Broadcast{MovieID: movieID, clip: clip, layers: layers, audio: audio, viewers: make(map[*Viewer]struct{})}
+	broadcasts.broadcasts[movieID] = broadcast
+	if clip != nil {
+		fmt.Printf("movie %s: %d frames, %v loop from %v, %d layers\n", movieID, len(clip.Frames), clip.Duration(), playoutEpoch.Format(time.RFC3339), len(layers))
+	}
+	if audio != nil {
+		fmt.Printf("movie %s: %d audio packets, %v\n", movieID, len(audio.Frames), audio.Duration())
+	}
+	return broadcast, nil
+}
+
+// loadVideo는 H.264 를, 없으면 MP4 를 바로 읽는다.
+func loadVideo(movieID string) (*playout.Clip, error) {
+	fileName, err := catalog.File(movieID, moviecatalog.H264, moviecatalog.MP4)
+	if err != nil {
+		return nil, err
+	}
+	clip, err := playout.Load(fileName, h264FrameDuration)
+	if err != nil {
+		return nil, err
+	}
+	if !playout.Sendable(clip.MimeType) {
+		return nil, fmt.Errorf("can not send %s", clip.MimeType)
+	}
+	return clip, nil
+}
+
+// loadAudio는 Ogg Opus 를, 없으면 MP4 의 Opus track 을 읽는다.
+func loadAudio(movieID string) (*playout.Clip, error) {
+	fileName, err := catalog.File(movieID, moviecatalog.Audio, moviecatalog.MP4)
+	if err != nil {
+		return nil, err
+	}
+	return playout.LoadAudio(fileName)
+}
+
+// Viewer는 peer 하나가 broadcast 를 보는 동안을 나타낸다. join, leave 는 여러 번 불러도 된다.
+// 영상이 없는 영화면 track 과 sender 가, 소리가 없으면 audioTrack 이 nil 이다.
+type Viewer struct {
+	broadcast  *Broadcast
+	track      *webrtc.TrackLocalStaticSample
+	sender     *playout.Sender
+	audioTrack *webrtc.TrackLocalStaticSample
+	joined     bool
+	left       bool
+	// 보내는 broadcast.layers 의 번호. autoLayer 면 대역폭 추정치나 receiver report 로 바꾼다.
+	layer        int
+	autoLayer    bool
+	cleanReports int
+	// 자동 layer 를 마지막으로 바꾼 시각. 이만큼 그대로면 한 단계 위를 시험한다.
+	layerChangedAt time.Time
+	probeInterval  time.Duration
+	// 한 단계 위 layer 를 시험하는 중이다. probeBudget 은 시험 전의 budget 이다.
+	probing        bool
+	probeBudget    int
+	probeStartedAt time.Time
+	report         func(Bandwidth)
+	reportedAt     time.Time
+	// -fec off 면 nil 이다.
+	fec *fec.Interceptor
+	mu  sync.Mutex
+}
+
+// newViewer는 peer 에 붙일 track 을 만든다. 영상 track 을 peer 마다 만드는 이유는 Broadcast 에 적었다.
+// 영상과 소리는 같은 stream ID 를 써서 브라우저가 한 MediaStream 으로 묶고 RTCP SR 로 입 모양을 맞춘다.
+func (b *Broadcast) newViewer() (*Viewer, error) {
+	// 가장 좋은 화질에서 시작해서 손실이 있으면 낮춘다.
+	viewer := &Viewer{broadcast: b, autoLayer: true, probeInterval: layerProbeInterval}
+	streamID := "movie-" + b.MovieID
+	if b.clip != nil {
+		track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: b.clip.MimeType}, "video", streamID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create video track: %w", err)
+		}
+		viewer.track = track
+		viewer.sender = playout.NewSender(b.clip, track)
+	}
+	if b.audio != nil {
+		track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: b.audio.MimeType}, "audio", streamID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create audio track: %w", err)
+		}
+		viewer.audioTrack = track
+	}
+	return viewer, nil
+}
+
+// join은 ICE 가 연결되면 부른다.
+func (v *Viewer) join() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.joined || v.left {
+		return
+	}
+	v.joined = true
+	v.broadcast.addViewer(v)
+}
+
+// leave는 peer 가 닫히거나 실패하면 부른다.
+func (v *Viewer) leave() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.left {
+		return
+	}
+	v.left = true
+	if v.joined {
+		v.broadcast.removeViewer(v)
+	}
+}
+
+func (b *Broadcast) addViewer(v *Viewer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.viewers[v] = struct{}{}
+	fmt.Printf("movie %s: %d viewers\n", b.MovieID, len(b.viewers))
+	if len(b.viewers) == 1 {
+		// 금방 나갔다 들어오면 멈추는 중인 재생 goroutine 이 끝난 뒤에 시작한다.
+		// 그러지 않으면 둘이 같은 track 에 쓴다.
+		ctx, cancel := context.WithCancel(context.Background())
+		previous, done := b.done, make(chan struct{})
+		b.cancel, b.done = cancel, done
+		go func() {
+			defer close(done)
+			if previous != nil {
+				<-previous
+			}
+			b.run(ctx)
+		}()
+	}
+}
+
+func (b *Broadcast) removeViewer(v *Viewer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.viewers, v)
+	fmt.Printf("movie %s: %d viewers\n", b.MovieID, len(b.viewers))
+	if len(b.viewers) == 0 {
+		b.cancel()
+		b.cancel = nil
+	}
+}
+
+// 이보다 늦어지면 밀린 화면을 보내지 않고 현재 위치로 건너뛴다.
+const maxPlayoutLag = time.Second
+
+// run은 시각에 맞는 화면을 보는 peer 모두의 track 에 쓴다.
+// 재생 위치는 공통 기준 시각(epoch) 에서 지난 시간이라서 모든 태블릿과 서버가 같은 장면을 본다.
+// 웹 페이지(SeventhMovie) 가 "현재 분의 초" 로 맞추는 것과 같은 방식이라서
+// epoch 가 정각이고 영상 길이가 60초의 약수면 웹 페이지와도 맞는다.
+// 중간에 들어온 peer 는 첫 화면을 keyframe 으로 받는다.
+// 소리는 같은 pacer 와 영상 길이로 반복해서 영상과 같은 시계를 따른다.
+func (b *Broadcast) run(ctx context.Context) {
+	fmt.Printf("movie %s: streaming start\n", b.MovieID)
+	defer fmt.Printf("movie %s: streaming stop\n", b.MovieID)
+
+	pacer := playout.NewPacer(time.Since(playoutEpoch))
+	options := playout.PlayOptions{Loop: true, MaxLag: maxPlayoutLag}
+	if b.clip != nil {
+		options.Period = b.clip.Duration()
+	}
+
+	var wg sync.WaitGroup
+	if b.clip != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			videoOptions := options
+			// 건너뛴 뒤의 화면은 건너뛴 화면을 참조하므로 모든 peer 에게 keyframe 부터 다시 보낸다.
+			videoOptions.OnSkip = func() {
+				for _, viewer := range b.currentViewers() {
+					viewer.sender.Resync()
+				}
+			}
+			_ = playout.Play(ctx, b.clip, pacer, videoOptions, func(i int) error {
+				for _, viewer := range b.currentViewers() {
+					if err := viewer.sender.Write(i); err != nil {
+						fmt.Printf("Failed to write sample: %v\n", err)
+					}
+				}
+				return nil
+			})
+		}()
+	}
+	if b.audio != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = playout.Play(ctx, b.audio, pacer, options, func(i int) error {
+				sample := b.audio.Sample(i)
+				for _, viewer := range b.currentViewers() {
+					if err := viewer.audioTrack.WriteSample(sample); err != nil {
+						fmt.Printf("Failed to write audio sample: %v\n", err)
+					}
+				}
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+}
+
+func (b *Broadcast) currentViewers() []*Viewer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	viewers := make([]*Viewer, 0, len(b.viewers))
+	for viewer := range b.viewers {
+		viewers = append(viewers, viewer)
+	}
+	return viewers
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+	"server.firehunter.juhyung.dev/internal/playout"
+)
+
+// overlapRecorder는 두 goroutine 이 동시에 쓰면 센다.
+type overlapRecorder struct {
+	writing  atomic.Int32
+	overlaps atomic.Int32
+	writes   atomic.Int32
+}
+
+func (r *overlapRecorder) WriteSample(media.Sample) error {
+	if r.writing.Add(1) > 1 {
+		r.overlaps.Add(1)
+	}
+	time.Sleep(2 * time.Millisecond)
+	r.writing.Add(-1)
+	r.writes.Add(1)
+	return nil
+}
+
+func TestBroadcastRejoinWaitsForRun(t *testing.T) {
+	// 1ms 짜리 IDR 화면 100개
+	var annexB []byte
+	for i := 0; i < 100; i++ {
+		annexB = append(annexB, 0, 0, 0, 1, 0x65, 0x88, byte(i))
+	}
+	fileName := filepath.Join(t.TempDir(), "test.h264")
+	if err := os.WriteFile(fileName, annexB, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	clip, err := playout.LoadH264(fileName, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broadcast := &Broadcast{MovieID: "1", clip: clip, layers: []Layer{{Name: "default", clip: clip}}, viewers: make(map[*Viewer]struct{})}
+	recorder := &overlapRecorder{}
+	viewer := &Viewer{broadcast: broadcast, sender: playout.NewSender(clip, recorder)}
+
+	// 나갔다 바로 들어와도 재생 goroutine 은 하나만 쓴다.
+	for i := 0; i < 20; i++ {
+		broadcast.addViewer(viewer)
+		time.Sleep(3 * time.Millisecond)
+		broadcast.removeViewer(viewer)
+	}
+	broadcast.mu.Lock()
+	done := broadcast.done
+	broadcast.mu.Unlock()
+	<-done
+
+	if recorder.writes.Load() == 0 {
+		t.Fatal("no frames were written")
+	}
+	if got := recorder.overlaps.Load(); got != 0 {
+		t.Fatalf("%d writes overlapped", got)
+	}
+}
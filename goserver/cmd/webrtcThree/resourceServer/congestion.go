@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/webrtc/v4"
+	"server.firehunter.juhyung.dev/internal/fec"
+)
+
+const (
+	// GCC 가 처음 가정하는 대역폭. 한 AP 에 태블릿 다섯 대가 붙어도 버틸 만큼에서 시작해서 올린다.
+	initialBitrate = 5_000_000
+	minBitrate     = 300_000
+	maxBitrate     = 60_000_000
+	// 추정치 중 영상 layer 에 쓰는 비율. 나머지는 소리, RTP/SRTP header, NACK 재전송 몫이다.
+	// FEC 를 보내면 실제로 더 보낸 비율만큼 더 뺀다.
+	bitrateHeadroom = 0.8
+	// GCC 는 추정치를 받은 양의 1.5배 넘게 올리지 않으므로 보내는 양이 적으면 추정치도 오르지 못한다.
+	// 그래서 추정치가 모자라도 가장 낮은 layer 는 계속 보내고, 한 layer 에 이만큼 머물면
+	// 한 단계 위 layer 를 시험 삼아 보낸다. 시험이 실패할 때마다 두 배로 늘린다.
+	layerProbeInterval    = 10 * time.Second
+	maxLayerProbeInterval = 2 * time.Minute
+	// 시험하는 동안은 추정치가 시험 전보다 떨어지지 않으면 내리지 않는다.
+	// layer 는 다음 keyframe 에서 바뀌므로 GOP 보다 넉넉히 둔다.
+	layerProbeDuration = 10 * time.Second
+	// 추정치는 자주 바뀌므로 layer 가 그대로면 이 간격으로만 클라이언트에게 알린다.
+	bandwidthReportInterval = 2 * time.Second
+)
+
+// peerInterceptors는 peer 마다 만들어서 밖에서 다루는 interceptor 들이다. fec 는 -fec off 면 nil 이다.
+type peerInterceptors struct {
+	estimator cc.BandwidthEstimator
+	fec       *fec.Interceptor
+}
+
+// createPeerConnection은 GCC(TWCC), NACK, RTCP report, FEC interceptor 를 붙인 PeerConnection 을 만든다.
+// cc interceptor 는 추정기를 callback 으로만 넘겨주므로 peer 마다 API 를 따로 만들어서 어느 peer 의 것인지 알 수 있게 한다.
+// pacer 는 쓰지 않는다. 화면은 Pacer 가 시각에 맞춰 보내므로 추정치는 layer 를 고르는 데만 쓴다.
+//
+// interceptor 는 나중에 붙인 것이 먼저 packet 을 받는다. 보내는 순서는
+// FEC → NACK responder → TWCC header extension → RTCP report → cc 다.
+func createPeerConnection() (*webrtc.PeerConnection, peerInterceptors, error) {
+	var interceptors peerInterceptors
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		return nil, interceptors, fmt.Errorf("failed to register codecs: %w", err)
+	}
+
+	registry := &interceptor.Registry{}
+	congestionController, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+		return gcc.NewSendSideBWE(
+			gcc.SendSideBWEInitialBitrate(initialBitrate),
+			gcc.SendSideBWEMinBitrate(minBitrate),
+			gcc.SendSideBWEMaxBitrate(maxBitrate),
+			gcc.SendSideBWEPacer(gcc.NewNoOpPacer()),
+		)
+	})
+	if err != nil {
+		return nil, interceptors, fmt.Errorf("failed to create congestion controller: %w", err)
+	}
+	estimators := make(chan cc.BandwidthEstimator, 1)
+	congestionController.OnNewPeerConnection(func(id string, estimator cc.BandwidthEstimator) {
+		estimators <- estimator
+	})
+	registry.Add(congestionController)
+
+	if err := webrtc.ConfigureRTCPReports(registry); err != nil {
+		return nil, interceptors, fmt.Errorf("failed to configure RTCP reports: %w", err)
+	}
+	// 브라우저가 TWCC feedback 을 보내도록 보내는 RTP 에 transport-wide sequence number 를 붙인다.
+	// NACK 으로 다시 보내는 packet 도 새 번호를 받는다.
+	if err := webrtc.ConfigureTWCCHeaderExtensionSender(mediaEngine, registry); err != nil {
+		return nil, interceptors, fmt.Errorf("failed to configure TWCC: %w", err)
+	}
+	if err := webrtc.ConfigureNack(mediaEngine, registry); err != nil {
+		return nil, interceptors, fmt.Errorf("failed to configure NACK: %w", err)
+	}
+	fecInterceptors, err := registerFEC(mediaEngine, registry)
+	if err != nil {
+		return nil, interceptors, fmt.Errorf("failed to configure FEC: %w", err)
+	}
+	if err := webrtc.ConfigureSimulcastExtensionHeaders(mediaEngine); err != nil {
+		return nil, interceptors, fmt.Errorf("failed to configure simulcast extension headers: %w", err)
+	}
+	if err := webrtc.ConfigureTWCCSender(mediaEngine, registry); err != nil {
+		return nil, interceptors, fmt.Errorf("failed to configure TWCC sender: %w", err)
+	}
+
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithInterceptorRegistry(registry))
+	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{
+		ICEServers: getICEServers(),
+	})
+	if err != nil {
+		return nil, interceptors, err
+	}
+
+	// interceptor 는 NewPeerConnection 안에서 만들어진다.
+	select {
+	case interceptors.estimator = <-estimators:
+	default:
+		peerConnection.Close()
+		return nil, interceptors, errors.New("no bandwidth estimator for the peer connection")
+	}
+	if fecInterceptors != nil {
+		select {
+		case interceptors.fec = <-fecInterceptors:
+		default:
+			peerConnection.Close()
+			return nil, interceptors, errors.New("no FEC interceptor for the peer connection")
+		}
+	}
+	return peerConnection, interceptors, nil
+}
+
+// Bandwidth는 peer 하나의 대역폭 추정과 그에 따라 고른 layer 다. 클라이언트에게 bandwidth push 로 보낸다.
+type Bandwidth struct {
+	// GCC 추정치 (bps)
+	Bitrate int    `json:"bitrate"`
+	Layer   string `json:"layer"`
+	// FEC 로 더 보낸 비율 (0.2 면 20%). -fec off 면 0 이다.
+	FECOverhead float64 `json:"fecOverhead"`
+}
+
+// watchBandwidth는 추정치가 바뀔 때마다 자동 layer 를 고르고 report 로 알린다.
+func (v *Viewer) watchBandwidth(estimator cc.BandwidthEstimator, report func(Bandwidth)) {
+	v.mu.Lock()
+	v.report = report
+	v.mu.Unlock()
+
+	estimator.OnTargetBitrateChange(v.onBandwidthEstimate)
+}
+
+func (v *Viewer) onBandwidthEstimate(bitrate int) {
+	v.mu.Lock()
+	layer := v.layer
+	var fecStats fec.Stats
+	if v.fec != nil {
+		fecStats = v.fec.Stats()
+	}
+	if v.autoLayer && v.sender != nil && v.broadcast.hasBitrates() {
+		v.adaptToBandwidth(int(float64(bitrate) * bitrateHeadroom / (1 + fecStats.Overhead())))
+	}
+
+	changed := layer != v.layer
+	report := v.report
+	if report == nil || (!changed && time.Since(v.reportedAt) < bandwidthReportInterval) {
+		v.mu.Unlock()
+		return
+	}
+	v.reportedAt = time.Now()
+	bandwidth := Bandwidth{Bitrate: bitrate, FECOverhead: fecStats.Overhead()}
+	if v.sender != nil {
+		bandwidth.Layer = v.broadcast.layers[v.layer].Name
+	}
+	v.mu.Unlock()
+
+	// websocket 에 쓰는 동안 lock 을 잡고 있지 않는다.
+	report(bandwidth)
+}
+
+// adaptToBandwidth는 v.mu 를 잡고 부른다. budget 안에 들어오는 가장 좋은 layer 로 바꾸고,
+// 모자라면 가장 낮은 layer 를 보낸다. 오래 그대로면 한 단계 위를 시험한다.
+func (v *Viewer) adaptToBandwidth(budget int) {
+	layers := v.broadcast.layers
+	now := time.Now()
+	target := len(layers) - 1
+	for i, layer := range layers {
+		if layer.Bitrate <= budget {
+			target = i
+			break
+		}
+	}
+
+	if v.probing {
+		switch {
+		case target <= v.layer:
+			v.probing = false
+			v.probeInterval = layerProbeInterval
+		case budget < v.probeBudget || now.Sub(v.probeStartedAt) >= layerProbeDuration:
+			v.probing = false
+			v.probeInterval = min(2*v.probeInterval, maxLayerProbeInterval)
+			fmt.Printf("movie %s: viewer failed to probe layer %s\n", v.broadcast.MovieID, layers[v.layer].Name)
+		default:
+			return
+		}
+	}
+
+	if target != v.layer {
+		v.switchLayer(target)
+		v.layerChangedAt = now
+		return
+	}
+	if v.layer > 0 && budget >= layers[v.layer].Bitrate && now.Sub(v.layerChangedAt) >= v.probeInterval {
+		v.probing = true
+		v.probeBudget = budget
+		v.probeStartedAt = now
+		v.switchLayer(v.layer - 1)
+		v.layerChangedAt = now
+	}
+}
+
+// hasBitrates는 모든 layer 에 bitrate 가 적혀 있어서 추정치로 고를 수 있는지 본다.
+func (b *Broadcast) hasBitrates() bool {
+	for _, layer := range b.layers {
+		if layer.Bitrate <= 0 {
+			return false
+		}
+	}
+	return len(b.layers) > 0
+}
+
+func createBandwidthMessage(bandwidth Bandwidth, clientID int32) ([]byte, error) {
+	data, err := json.Marshal(bandwidth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal bandwidth: %w", err)
+	}
+
+	message, err := json.Marshal(WebSocketMessage{
+		Kind:     messageKindPush,
+		Type:     "bandwidth",
+		Data:     data,
+		ClientID: clientID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	return message, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"server.firehunter.juhyung.dev/internal/playout"
+)
+
+func newTestViewer(t *testing.T) (*Viewer, *[]Bandwidth) {
+	t.Helper()
+	layers := []Layer{
+		{Name: "high", Bitrate: 4_000_000, clip: &playout.Clip{MimeType: webrtc.MimeTypeH264}},
+		{Name: "mid", Bitrate: 2_000_000, clip: &playout.Clip{MimeType: webrtc.MimeTypeH264}},
+		{Name: "low", Bitrate: 1_000_000, clip: &playout.Clip{MimeType: webrtc.MimeTypeH264}},
+	}
+	broadcast := &Broadcast{MovieID: "1", clip: layers[0].clip, layers: layers, viewers: make(map[*Viewer]struct{})}
+	viewer, err := broadcast.newViewer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reports []Bandwidth
+	viewer.report = func(bandwidth Bandwidth) {
+		reports = append(reports, bandwidth)
+	}
+	return viewer, &reports
+}
+
+// estimate는 GCC 추정치 bitrate 를 넘기고 그 뒤의 layer 이름을 돌려준다. budget 은 추정치의 80% 다.
+func estimate(v *Viewer, bitrate int) string {
+	v.onBandwidthEstimate(bitrate)
+	return v.broadcast.layers[v.layer].Name
+}
+
+// elapse는 마지막 layer 변경과 시험 시작을 d 만큼 앞으로 당긴다.
+func elapse(v *Viewer, d time.Duration) {
+	v.layerChangedAt = v.layerChangedAt.Add(-d)
+	v.probeStartedAt = v.probeStartedAt.Add(-d)
+}
+
+func TestAdaptToBandwidth(t *testing.T) {
+	v, reports := newTestViewer(t)
+
+	if got := estimate(v, 6_000_000); got != "high" {
+		t.Fatalf("layer = %s, want high", got)
+	}
+	// 가장 낮은 layer 에도 모자라도 영상을 멈추지 않는다.
+	if got := estimate(v, 500_000); got != "low" {
+		t.Fatalf("layer = %s, want low", got)
+	}
+	if got := (*reports)[len(*reports)-1]; got.Layer != "low" || got.Bitrate != 500_000 {
+		t.Fatalf("last report = %+v, want low at 500000", got)
+	}
+
+	// GCC 는 받은 양의 1.5배 근처에서 멈춘다. mid 에 모자란 추정치로는 시험 전까지 올라가지 않는다.
+	if got := estimate(v, 1_500_000); got != "low" {
+		t.Fatalf("layer = %s, want low before the probe interval", got)
+	}
+	elapse(v, layerProbeInterval)
+	if got := estimate(v, 1_500_000); got != "mid" || !v.probing {
+		t.Fatalf("layer = %s, probing %v, want a probe of mid", got, v.probing)
+	}
+	// 시험하는 동안 추정치가 아직 mid 에 모자라도 떨어지지 않으면 기다린다.
+	if got := estimate(v, 1_600_000); got != "mid" {
+		t.Fatalf("layer = %s, want mid during the probe", got)
+	}
+	// 더 보내니 추정치가 따라 오르면 성공이다.
+	if got := estimate(v, 2_600_000); got != "mid" || v.probing || v.probeInterval != layerProbeInterval {
+		t.Fatalf("layer = %s, probing %v, interval %v, want a successful probe", got, v.probing, v.probeInterval)
+	}
+}
+
+func TestAdaptToBandwidthFailedProbes(t *testing.T) {
+	v, _ := newTestViewer(t)
+	estimate(v, 2_600_000)
+
+	// 추정치가 시험 전보다 떨어지면 바로 내린다.
+	elapse(v, layerProbeInterval)
+	if got := estimate(v, 2_600_000); got != "high" {
+		t.Fatalf("layer = %s, want a probe of high", got)
+	}
+	if got := estimate(v, 2_500_000); got != "mid" || v.probing {
+		t.Fatalf("layer = %s, probing %v, want mid after a failed probe", got, v.probing)
+	}
+	if v.probeInterval != 2*layerProbeInterval {
+		t.Fatalf("probe interval = %v, want %v", v.probeInterval, 2*layerProbeInterval)
+	}
+
+	// 실패한 뒤에는 더 오래 기다린다.
+	elapse(v, layerProbeInterval)
+	if got := estimate(v, 2_600_000); got != "mid" {
+		t.Fatalf("layer = %s, want mid before the longer interval", got)
+	}
+	elapse(v, layerProbeInterval)
+	if got := estimate(v, 2_600_000); got != "high" {
+		t.Fatalf("layer = %s, want a second probe of high", got)
+	}
+	// 추정치가 그대로여도 시험 시간이 지나면 내린다.
+	elapse(v, layerProbeDuration)
+	if got := estimate(v, 2_600_000); got != "mid" || v.probeInterval != 4*layerProbeInterval {
+		t.Fatalf("layer = %s, interval %v, want mid after a timed out probe", got, v.probeInterval)
+	}
+
+	for i := 0; i < 5; i++ {
+		elapse(v, v.probeInterval)
+		estimate(v, 2_600_000)
+		elapse(v, layerProbeDuration)
+		estimate(v, 2_600_000)
+	}
+	if v.probeInterval != maxLayerProbeInterval {
+		t.Fatalf("probe interval = %v, want at most %v", v.probeInterval, maxLayerProbeInterval)
+	}
+}
+
+func TestAdaptToBandwidthManualLayer(t *testing.T) {
+	v, _ := newTestViewer(t)
+	if err := v.selectLayer("low"); err != nil {
+		t.Fatal(err)
+	}
+	// 클라이언트가 고른 layer 는 추정치로 바꾸지 않는다.
+	elapse(v, layerProbeInterval)
+	if got := estimate(v, 6_000_000); got != "low" {
+		t.Fatalf("layer = %s, want the selected low", got)
+	}
+	if err := v.selectLayer(""); err != nil {
+		t.Fatal(err)
+	}
+	if got := estimate(v, 6_000_000); got != "high" {
+		t.Fatalf("layer = %s, want high after going back to auto", got)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v4"
+	"server.firehunter.juhyung.dev/internal/fec"
+)
+
+const (
+	fecOff = "off"
+	// 소리만 RED 로 한 번 더 보낸다.
+	fecRED = "red"
+	// 소리는 RED, 영상은 RED 에 담은 ULPFEC 으로 보호한다.
+	fecULPFEC = "ulpfec"
+)
+
+var (
+	// -fec, -fec-overhead 로 정한다. 행사장 Wi-Fi 가 나쁠수록 overhead 를 올린다.
+	fecMode     = fecOff
+	fecOverhead = 20
+)
+
+// fecMode 별로 등록하는 코덱. 브라우저 offer 에 답할 때는 브라우저가 정한 payload type 을 따르므로
+// 여기 적은 payload type 은 기본 코덱과 겹치지만 않으면 된다.
+var fecCodecs = []struct {
+	parameters webrtc.RTPCodecParameters
+	kind       webrtc.RTPCodecType
+	modes      []string
+}{
+	{
+		parameters: webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "audio/red", ClockRate: 48000, Channels: 2, SDPFmtpLine: "111/111"},
+			PayloadType:        63,
+		},
+		kind:  webrtc.RTPCodecTypeAudio,
+		modes: []string{fecRED, fecULPFEC},
+	},
+	{
+		parameters: webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "video/red", ClockRate: 90000},
+			PayloadType:        114,
+		},
+		kind:  webrtc.RTPCodecTypeVideo,
+		modes: []string{fecULPFEC},
+	},
+	{
+		parameters: webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "video/ulpfec", ClockRate: 90000},
+			PayloadType:        115,
+		},
+		kind:  webrtc.RTPCodecTypeVideo,
+		modes: []string{fecULPFEC},
+	},
+}
+
+func validateFEC(mode string, overhead int) error {
+	switch mode {
+	case fecOff, fecRED, fecULPFEC:
+	case "flexfec":
+		return fmt.Errorf("flexfec is not supported: browsers do not negotiate it by default, use %q", fecULPFEC)
+	default:
+		return fmt.Errorf("unknown fec mode %q (%s, %s, %s)", mode, fecOff, fecRED, fecULPFEC)
+	}
+	if overhead < 1 || overhead > 100 {
+		return fmt.Errorf("fec overhead must be between 1 and 100 percent: %d", overhead)
+	}
+	return nil
+}
+
+// registerFEC는 fecMode 에 맞는 RED/ULPFEC 코덱과 interceptor 를 붙인다.
+// NewPeerConnection 이 만든 interceptor 는 돌려준 channel 로 받는다. off 면 channel 이 nil 이다.
+// NACK 과 TWCC header extension interceptor 보다 나중에 불러야 FEC packet 도 다시 보내고 번호를 받는다.
+func registerFEC(mediaEngine *webrtc.MediaEngine, registry *interceptor.Registry) (<-chan *fec.Interceptor, error) {
+	if fecMode == fecOff {
+		return nil, nil
+	}
+
+	for _, codec := range fecCodecs {
+		if !slices.Contains(codec.modes, fecMode) {
+			continue
+		}
+		if err := mediaEngine.RegisterCodec(codec.parameters, codec.kind); err != nil {
+			return nil, fmt.Errorf("failed to register %s: %w", codec.parameters.MimeType, err)
+		}
+	}
+
+	factory, err := fec.NewInterceptor(fecOverhead)
+	if err != nil {
+		return nil, err
+	}
+	interceptors := make(chan *fec.Interceptor, 1)
+	factory.OnNewPeerConnection(func(id string, i *fec.Interceptor) {
+		interceptors <- i
+	})
+	registry.Add(factory)
+
+	return interceptors, nil
+}
+
+// negotiatedFEC는 answer 에 남은 RED/ULPFEC payload type 을 찾는다. 브라우저가 offer 에 넣지 않았으면 0 이다.
+func negotiatedFEC(answer webrtc.SessionDescription) (fec.PayloadTypes, error) {
+	var payloadTypes fec.PayloadTypes
+	parsed, err := answer.Unmarshal()
+	if err != nil {
+		return payloadTypes, fmt.Errorf("failed to parse answer: %w", err)
+	}
+
+	for _, media := range parsed.MediaDescriptions {
+		for _, attribute := range media.Attributes {
+			if attribute.Key != "rtpmap" {
+				continue
+			}
+			// "63 red/48000/2"
+			payloadType, encoding, ok := strings.Cut(attribute.Value, " ")
+			if !ok {
+				continue
+			}
+			pt, err := strconv.ParseUint(payloadType, 10, 7)
+			if err != nil {
+				continue
+			}
+			name, _, _ := strings.Cut(encoding, "/")
+			switch {
+			case media.MediaName.Media == "audio" && strings.EqualFold(name, "red"):
+				payloadTypes.AudioRED = uint8(pt)
+			case media.MediaName.Media == "video" && strings.EqualFold(name, "red"):
+				payloadTypes.VideoRED = uint8(pt)
+			case media.MediaName.Media == "video" && strings.EqualFold(name, "ulpfec"):
+				payloadTypes.VideoULPFEC = uint8(pt)
+			}
+		}
+	}
+	return payloadTypes, nil
+}
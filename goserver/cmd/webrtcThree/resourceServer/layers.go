@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/rtcp"
+	"server.firehunter.juhyung.dev/internal/playout"
+)
+
+// Layer는 simulcast 의 한 화질이다. 화면 수와 코덱이 같아서 같은 화면 번호로 바꿔 보낼 수 있다.
+type Layer struct {
+	Name    string
+	Bitrate int
+	clip    *playout.Clip
+}
+
+// loadLayers는 catalog 의 simulcast layer 들을 읽는다. 없으면 H.264/MP4 하나를 layer 로 쓴다.
+// 첫 layer 와 코덱이나 화면 수가 다른 layer 는 바꿔 보낼 수 없으므로 뺀다.
+func loadLayers(movieID string) ([]Layer, error) {
+	var layers []Layer
+	for _, layer := range catalog.Layers(movieID) {
+		clip, err := playout.Load(layer.File, h264FrameDuration)
+		if err == nil && !playout.Sendable(clip.MimeType) {
+			err = fmt.Errorf("can not send %s", clip.MimeType)
+		}
+		if err == nil && len(layers) > 0 {
+			err = layerMismatch(layers[0].clip, clip)
+		}
+		if err != nil {
+			fmt.Printf("movie %s: skip layer %s: %v\n", movieID, layer.Name, err)
+			continue
+		}
+		layers = append(layers, Layer{Name: layer.Name, Bitrate: layer.Bitrate, clip: clip})
+	}
+	if len(layers) > 0 {
+		return layers, nil
+	}
+
+	clip, err := loadVideo(movieID)
+	if err != nil {
+		return nil, err
+	}
+	return []Layer{{Name: "default", clip: clip}}, nil
+}
+
+func layerMismatch(reference *playout.Clip, clip *playout.Clip) error {
+	if !strings.EqualFold(reference.MimeType, clip.MimeType) {
+		return fmt.Errorf("codec %s differs from %s", clip.MimeType, reference.MimeType)
+	}
+	if len(reference.Frames) != len(clip.Frames) {
+		return fmt.Errorf("%d frames differ from %d", len(clip.Frames), len(reference.Frames))
+	}
+	return nil
+}
+
+const (
+	// receiver report 의 손실률이 이보다 크면 한 단계 낮은 화질로 바꾼다.
+	layerDownLoss = 0.10
+	// 손실률이 이보다 작은 report 가 layerUpReports 번 이어지면 한 단계 높은 화질로 바꾼다.
+	// 브라우저는 report 를 1초쯤마다 보낸다.
+	layerUpLoss    = 0.02
+	layerUpReports = 10
+)
+
+// selectLayer는 클라이언트가 고른 layer 로 바꾼다. 빈 이름이면 receiver report 로 자동으로 고른다.
+// 다른 peer 는 자기 Sender 를 쓰므로 영향이 없다.
+func (v *Viewer) selectLayer(name string) error {
+	if v.sender == nil {
+		return fmt.Errorf("movie %s has no video", v.broadcast.MovieID)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.probing = false
+	if name == "" {
+		v.autoLayer = true
+		v.cleanReports = 0
+		return nil
+	}
+	for i, layer := range v.broadcast.layers {
+		if layer.Name == name {
+			v.autoLayer = false
+			v.switchLayer(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("movie %s has no layer %q", v.broadcast.MovieID, name)
+}
+
+// onReceptionReport는 영상 track 의 receiver report 로 자동 layer 를 고른다.
+// layer 마다 bitrate 가 적혀 있으면 대역폭 추정치로 고르므로 쓰지 않는다.
+func (v *Viewer) onReceptionReport(report rtcp.ReceptionReport) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !v.autoLayer || v.broadcast.hasBitrates() {
+		return
+	}
+	loss := float64(report.FractionLost) / 256
+	switch {
+	case loss > layerDownLoss:
+		v.cleanReports = 0
+		if v.layer+1 < len(v.broadcast.layers) {
+			v.switchLayer(v.layer + 1)
+		}
+	case loss < layerUpLoss:
+		v.cleanReports++
+		if v.cleanReports >= layerUpReports && v.layer > 0 {
+			v.cleanReports = 0
+			v.switchLayer(v.layer - 1)
+		}
+	default:
+		v.cleanReports = 0
+	}
+}
+
+// switchLayer는 v.mu 를 잡고 부른다. 실제로 바뀌는 것은 그 layer 의 다음 keyframe 이다.
+func (v *Viewer) switchLayer(i int) {
+	if i == v.layer {
+		return
+	}
+	v.layer = i
+	v.sender.Switch(v.broadcast.layers[i].clip)
+	fmt.Printf("movie %s: viewer switches to layer %s\n", v.broadcast.MovieID, v.broadcast.layers[i].Name)
+}
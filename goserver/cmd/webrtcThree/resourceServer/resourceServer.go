@@ -4,29 +4,77 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pion/webrtc/v4"
-	"github.com/pion/webrtc/v4/pkg/media"
-	"github.com/pion/webrtc/v4/pkg/media/h264reader"
+	"server.firehunter.juhyung.dev/internal/fec"
+	"server.firehunter.juhyung.dev/internal/moviecatalog"
+	"server.firehunter.juhyung.dev/internal/playout"
 )
 
 var (
+	// SPS 에 timing 정보가 없는 영상의 화면 길이
 	h264FrameDuration = time.Millisecond * 33
 	// signallingServer  = "localhost:8124"
 	// signalScheme = "ws"
 	signalScheme     = "wss"
 	signallingServer = "signal-firehunter.i.juhyung.dev"
+
+	movieIDs        []string
+	clientCapacity  int
+	signallingToken string
+	// 모든 리소스 서버가 같은 값을 써야 같은 장면을 보낸다.
+	playoutEpoch time.Time
+	catalog      *moviecatalog.Catalog
 )
 
 func main() {
+	movies := flag.String("movies", "1", "Comma separated movie IDs served by this resource server (e.g. \"1,2\")")
+	capacity := flag.Int("capacity", 5, "Maximum number of clients this resource server accepts")
+	token := flag.String("token", os.Getenv("FIREHUNTER_TOKEN"), "Resource server token issued by the signalling server operator (env FIREHUNTER_TOKEN)")
+	catalogPath := flag.String("catalog", moviecatalog.DefaultPath, "Movie catalog file. Every movie in -movies needs a playable h264, mp4 or audio rendition")
+	epoch := flag.String("epoch", "1970-01-01T00:00:00Z", "Shared playout epoch (RFC3339). Every resource server must use the same value to stay in sync")
+	flag.StringVar(&fecMode, "fec", fecOff, "Forward error correction: off, red (audio only) or ulpfec (audio RED and video ULPFEC)")
+	flag.IntVar(&fecOverhead, "fec-overhead", fecOverhead, "ULPFEC packets per 100 video packets (1-100)")
+	flag.Parse()
+
+	if *token == "" {
+		fmt.Fprintln(os.Stderr, "'token' is required")
+		os.Exit(1)
+	}
+	signallingToken = *token
+
+	if err := validateFEC(fecMode, fecOverhead); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	parsedEpoch, err := time.Parse(time.RFC3339, *epoch)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid epoch: %v\n", err)
+		os.Exit(1)
+	}
+	playoutEpoch = parsedEpoch
+
+	catalog, err = moviecatalog.Load(*catalogPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	movieIDs = strings.Split(*movies, ",")
+	clientCapacity = *capacity
+
 	fmt.Println("resourceServer start")
 	ctx := context.Background()
 	if err := webrtcMain(ctx); err != nil {
@@ -35,14 +83,43 @@ func main() {
 	}
 }
 
+const (
+	messageKindRequest  = "request"
+	messageKindResponse = "response"
+	messageKindPush     = "push"
+)
+
 type WebSocketMessage struct {
+	// request 에 대한 response 는 request 의 ID 를 그대로 돌려줘야 함
+	ID       int64           `json:"id,omitempty"`
+	Kind     string          `json:"kind"`
 	Type     string          `json:"type"`
 	Data     json.RawMessage `json:"data"`
 	ClientID int32           `json:"clientId"`
+	// 시그널링 서버가 클라이언트 메시지에 붙여 보낸다.
+	MovieID string `json:"movieId,omitempty"`
+}
+
+type ErrorData struct {
+	Message     string `json:"message"`
+	RequestType string `json:"requestType,omitempty"`
 }
 
 type WebSocketData interface{}
 
+type Registration struct {
+	MovieIDs []string `json:"movieIds"`
+	Capacity int      `json:"capacity"`
+}
+
+// 시그널링 서버가 클라이언트 세션이 만료되었을 때 보냄
+type Close struct{}
+
+// 클라이언트가 simulcast layer 를 고를 때 보냄. Layer 가 비어 있으면 자동
+type LayerRequest struct {
+	Layer string `json:"layer"`
+}
+
 type Offer = webrtc.SessionDescription
 type Answer = webrtc.SessionDescription
 type Candidate = webrtc.ICECandidateInit
@@ -69,16 +146,47 @@ func parseWebSocketMessage(message []byte) (*WebSocketMessage, WebSocketData, er
 		return &wsMessage, &candidate, nil
 	}
 
+	if wsMessage.Type == "close" {
+		return &wsMessage, &Close{}, nil
+	}
+
+	if wsMessage.Type == "layer" {
+		var layer LayerRequest
+		if err := json.Unmarshal(wsMessage.Data, &layer); err != nil {
+			return nil, nil, fmt.Errorf("failed to unmarshal layer: %w", err)
+		}
+		return &wsMessage, &layer, nil
+	}
+
 	return nil, nil, fmt.Errorf("unknown message type: %s", wsMessage.Type)
 }
 
-func createAnswerMessage(answer Answer, clientID int32) ([]byte, error) {
+func createRegisterMessage(movieIDs []string, capacity int) ([]byte, error) {
+	data, err := json.Marshal(Registration{MovieIDs: movieIDs, Capacity: capacity})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal registration: %w", err)
+	}
+
+	message, err := json.Marshal(WebSocketMessage{
+		Type: "register",
+		Data: data,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	return message, nil
+}
+
+func createAnswerMessage(answer Answer, requestID int64, clientID int32) ([]byte, error) {
 	data, err := json.Marshal(answer)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal answer: %w", err)
 	}
 
 	message, err := json.Marshal(WebSocketMessage{
+		ID:       requestID,
+		Kind:     messageKindResponse,
 		Type:     "answer",
 		Data:     data,
 		ClientID: clientID,
@@ -97,6 +205,7 @@ func createCandidateMessage(candidate *webrtc.ICECandidate, clientID int32) ([]b
 	}
 
 	message, err := json.Marshal(WebSocketMessage{
+		Kind:     messageKindPush,
 		Type:     "candidate",
 		Data:     data,
 		ClientID: clientID,
@@ -108,10 +217,43 @@ func createCandidateMessage(candidate *webrtc.ICECandidate, clientID int32) ([]b
 	return message, nil
 }
 
+func createErrorMessage(request *WebSocketMessage, cause error) ([]byte, error) {
+	data, err := json.Marshal(ErrorData{Message: cause.Error(), RequestType: request.Type})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal error: %w", err)
+	}
+
+	message, err := json.Marshal(WebSocketMessage{
+		ID:       request.ID,
+		Kind:     messageKindResponse,
+		Type:     "error",
+		Data:     data,
+		ClientID: request.ClientID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	return message, nil
+}
+
+var (
+	// gorilla/websocket 은 동시에 여러 goroutine 에서 쓰면 안 됨
+	writeMu sync.Mutex
+)
+
+func writeMessage(c *websocket.Conn, message []byte) error {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	return c.WriteMessage(websocket.TextMessage, message)
+}
+
 type Peer struct {
-	ID int32
-	pc *webrtc.PeerConnection
-	c  *websocket.Conn
+	ID     int32
+	pc     *webrtc.PeerConnection
+	viewer *Viewer
+	c      *websocket.Conn
 }
 
 type Peers struct {
@@ -123,6 +265,18 @@ var (
 	peers = Peers{peers: make(map[int32]*Peer)}
 )
 
+func getViewer(clientID int32) (*Viewer, error) {
+	peers.mu.RLock()
+	defer peers.mu.RUnlock()
+
+	peer, ok := peers.peers[clientID]
+	if !ok {
+		return nil, fmt.Errorf("peer not found")
+	}
+
+	return peer.viewer, nil
+}
+
 func getPeerConnection(clientID int32) (*webrtc.PeerConnection, error) {
 	peers.mu.RLock()
 	defer peers.mu.RUnlock()
@@ -135,11 +289,47 @@ func getPeerConnection(clientID int32) (*webrtc.PeerConnection, error) {
 	return peer.pc, nil
 }
 
-func addPeer(clientID int32, pc *webrtc.PeerConnection, c *websocket.Conn) {
+func addPeer(clientID int32, pc *webrtc.PeerConnection, viewer *Viewer, c *websocket.Conn) {
+	peers.mu.Lock()
+	defer peers.mu.Unlock()
+
+	peers.peers[clientID] = &Peer{ID: clientID, pc: pc, viewer: viewer, c: c}
+}
+
+func removePeer(clientID int32) (*Peer, error) {
+	peers.mu.Lock()
+	defer peers.mu.Unlock()
+
+	peer, ok := peers.peers[clientID]
+	if !ok {
+		return nil, fmt.Errorf("peer not found")
+	}
+	delete(peers.peers, clientID)
+
+	return peer, nil
+}
+
+// forgetPeer는 clientID 의 peer 가 아직 peerConnection 이면 목록에서 지운다.
+func forgetPeer(clientID int32, peerConnection *webrtc.PeerConnection) {
 	peers.mu.Lock()
 	defer peers.mu.Unlock()
 
-	peers.peers[clientID] = &Peer{ID: clientID, pc: pc, c: c}
+	if peer, ok := peers.peers[clientID]; ok && peer.pc == peerConnection {
+		delete(peers.peers, clientID)
+	}
+}
+
+func closePeer(clientID int32) error {
+	peer, err := removePeer(clientID)
+	if err != nil {
+		return err
+	}
+
+	peer.viewer.leave()
+	if err := peer.pc.Close(); err != nil {
+		return fmt.Errorf("failed to close peer connection: %w", err)
+	}
+	return nil
 }
 
 func webrtcMain(ctx context.Context) error {
@@ -148,8 +338,11 @@ func webrtcMain(ctx context.Context) error {
 
 	fmt.Println("webrtcMain start")
 
-	if err := checkVideoFile(); err != nil {
-		return fmt.Errorf("failed to check video file: %w", err)
+	// 잘못된 영화를 첫 offer 에서야 알지 않도록 등록하기 전에 읽어 둔다. 못 읽으면 main 이 끝낸다.
+	for _, movieID := range movieIDs {
+		if _, err := getBroadcast(movieID); err != nil {
+			return fmt.Errorf("failed to load movie %s: %w", movieID, err)
+		}
 	}
 
 	c, err := connectToWebsocket()
@@ -176,55 +369,35 @@ func webrtcMain(ctx context.Context) error {
 				}
 				pc.AddICECandidate(*data)
 
-			case *Offer:
-				// TODO: need to cldanup peerConenction
-				peerConnection, err := registerWebRTCEvents(ctx)
+			case *Close:
+				if err := closePeer(webSocketMessage.ClientID); err != nil {
+					fmt.Printf("failed to close peer: %v\n", err)
+					continue
+				}
+				fmt.Printf("peer %d closed\n", webSocketMessage.ClientID)
+
+			case *LayerRequest:
+				viewer, err := getViewer(webSocketMessage.ClientID)
 				if err != nil {
-					fmt.Printf("failed to register WebRTC events: %v\n", err)
+					fmt.Printf("failed to get viewer: %v\n", err)
 					continue
 				}
-				addPeer(webSocketMessage.ClientID, peerConnection, c)
-				peerConnection.OnICECandidate(func(candidate *webrtc.ICECandidate) {
-					if candidate == nil {
-						return
-					}
+				if err := viewer.selectLayer(data.Layer); err != nil {
+					fmt.Printf("failed to select layer: %v\n", err)
+				}
 
-					candidateMessage, err := createCandidateMessage(candidate, webSocketMessage.ClientID)
+			case *Offer:
+				if err := handleOffer(ctx, c, webSocketMessage, *data); err != nil {
+					fmt.Printf("failed to handle offer: %v\n", err)
+					errorMessage, err := createErrorMessage(webSocketMessage, err)
 					if err != nil {
-						fmt.Printf("failed to create candidate message: %v\n", err)
-						return
+						fmt.Printf("failed to create error message: %v\n", err)
+						continue
 					}
-					fmt.Println("send candidate message")
-					if err := c.WriteMessage(websocket.TextMessage, candidateMessage); err != nil {
+					if err := writeMessage(c, errorMessage); err != nil {
 						fmt.Printf("failed to write message: %v\n", err)
-						return
 					}
-				})
-
-				if err := acceptOffer(peerConnection, *data); err != nil {
-					// return nil, answer, fmt.Errorf("failed to accept offer: %w", err)
-					fmt.Printf("failed to accept offer: %v\n", err)
-					continue
 				}
-
-				answer, err := createAnswer(peerConnection)
-				if err != nil {
-					fmt.Printf("failed to create answer: %v\n", err)
-					continue
-					// return nil, answer, fmt.Errorf("failed to create answer: %w", err)
-				}
-
-				answerMessage, err := createAnswerMessage(answer, webSocketMessage.ClientID)
-				if err != nil {
-					fmt.Printf("failed to create answer message: %v\n", err)
-					continue
-				}
-				fmt.Println("send answer message")
-				if err := c.WriteMessage(websocket.TextMessage, answerMessage); err != nil {
-					fmt.Printf("failed to write message: %v\n", err)
-					continue
-				}
-
 			}
 		}
 	}()
@@ -234,130 +407,261 @@ func webrtcMain(ctx context.Context) error {
 	return nil
 }
 
-var videoFileName = "resource/0518sample_annexb.h264"
+func handleOffer(ctx context.Context, c *websocket.Conn, webSocketMessage *WebSocketMessage, offer Offer) error {
+	// 세션을 이어서 연결한 클라이언트는 ICE restart offer 를 보낸다.
+	if peerConnection, err := getPeerConnection(webSocketMessage.ClientID); err == nil {
+		fmt.Printf("renegotiate peer %d\n", webSocketMessage.ClientID)
+		viewer, err := getViewer(webSocketMessage.ClientID)
+		if err != nil {
+			return err
+		}
+		return answerOffer(c, peerConnection, viewer, webSocketMessage, offer)
+	}
 
-func checkVideoFile() error {
-	if _, err := os.Stat(videoFileName); err != nil {
-		return fmt.Errorf("failed to check video file: %w", err)
+	broadcast, err := getBroadcast(webSocketMessage.MovieID)
+	if err != nil {
+		return fmt.Errorf("failed to get movie %s: %w", webSocketMessage.MovieID, err)
+	}
+	clientID := webSocketMessage.ClientID
+	peerConnection, viewer, err := registerWebRTCEvents(clientID, broadcast, func(bandwidth Bandwidth) {
+		fmt.Printf("peer %d: %d bps, layer %s, fec overhead %.0f%%\n", clientID, bandwidth.Bitrate, bandwidth.Layer, bandwidth.FECOverhead*100)
+		message, err := createBandwidthMessage(bandwidth, clientID)
+		if err != nil {
+			fmt.Printf("failed to create bandwidth message: %v\n", err)
+			return
+		}
+		if err := writeMessage(c, message); err != nil {
+			fmt.Printf("failed to write message: %v\n", err)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register WebRTC events: %w", err)
 	}
+	addPeer(webSocketMessage.ClientID, peerConnection, viewer, c)
+	peerConnection.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
 
-	return nil
+		candidateMessage, err := createCandidateMessage(candidate, webSocketMessage.ClientID)
+		if err != nil {
+			fmt.Printf("failed to create candidate message: %v\n", err)
+			return
+		}
+		fmt.Println("send candidate message")
+		if err := writeMessage(c, candidateMessage); err != nil {
+			fmt.Printf("failed to write message: %v\n", err)
+			return
+		}
+	})
+
+	return answerOffer(c, peerConnection, viewer, webSocketMessage, offer)
 }
 
-func registerWebRTCEvents(ctx context.Context) (peerConnection *webrtc.PeerConnection, err error) {
-	fmt.Println("registerWebRTCEvents")
-	peerConnection, err = createPeerConnection()
+func answerOffer(c *websocket.Conn, peerConnection *webrtc.PeerConnection, viewer *Viewer, webSocketMessage *WebSocketMessage, offer Offer) error {
+	if err := acceptOffer(peerConnection, offer); err != nil {
+		return fmt.Errorf("failed to accept offer: %w", err)
+	}
+
+	answer, err := createAnswer(peerConnection, viewer.fec)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create PeerConnection: %w", err)
+		return fmt.Errorf("failed to create answer: %w", err)
 	}
 
-	iceConnectedCtx, iceConnectedCtxCancel := context.WithCancel(ctx)
+	answerMessage, err := createAnswerMessage(answer, webSocketMessage.ID, webSocketMessage.ClientID)
+	if err != nil {
+		return fmt.Errorf("failed to create answer message: %w", err)
+	}
+	fmt.Println("send answer message")
+	if err := writeMessage(c, answerMessage); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
 
-	videoTrack, videoTrackErr := createVideoTrack(peerConnection)
-	if videoTrackErr != nil {
-		return nil, fmt.Errorf("failed to create video track: %w", videoTrackErr)
+	return nil
+}
+
+// registerWebRTCEvents는 peer 를 만들고 대역폭 추정치가 바뀌면 reportBandwidth 로 알린다.
+func registerWebRTCEvents(clientID int32, broadcast *Broadcast, reportBandwidth func(Bandwidth)) (peerConnection *webrtc.PeerConnection, viewer *Viewer, err error) {
+	fmt.Println("registerWebRTCEvents")
+	peerConnection, interceptors, err := createPeerConnection()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create PeerConnection: %w", err)
 	}
 
-	go streamingVideo(iceConnectedCtx, videoTrack)
+	viewer, err = broadcast.newViewer()
+	if err != nil {
+		peerConnection.Close()
+		return nil, nil, err
+	}
+	viewer.fec = interceptors.fec
+	viewer.watchBandwidth(interceptors.estimator, reportBandwidth)
+	if err := addTracks(peerConnection, viewer); err != nil {
+		peerConnection.Close()
+		return nil, nil, err
+	}
 
-	registerConnectionStartedEvent(iceConnectedCtxCancel, peerConnection)
-	registerConnectionFailedEvent(peerConnection)
+	registerConnectionStartedEvent(viewer, peerConnection)
+	registerConnectionFailedEvent(clientID, viewer, peerConnection)
 
-	return peerConnection, nil
+	return peerConnection, viewer, nil
 }
 
-func createPeerConnection() (*webrtc.PeerConnection, error) {
-	return webrtc.NewPeerConnection(webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			webrtc.ICEServer{
-				URLs: []string{"stun:stun.i.juhyung.dev:3478"},
-			},
+var (
+	defaultICEServers = []webrtc.ICEServer{
+		{
+			URLs: []string{"stun:stun.i.juhyung.dev:3478"},
 		},
-	})
+	}
+	// TURN 계정은 시간이 지나면 만료되므로 주기적으로 다시 받는다.
+	iceServersRefresh = time.Hour
+
+	iceServersCache struct {
+		servers   []webrtc.ICEServer
+		fetchedAt time.Time
+		mu        sync.Mutex
+	}
+)
+
+type ICEServersResponse struct {
+	ICEServers []webrtc.ICEServer `json:"iceServers"`
 }
 
-func createVideoTrack(peerConnection *webrtc.PeerConnection) (*webrtc.TrackLocalStaticSample, error) {
-	videoTrack, videoTrackErr := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", "pion")
-	if videoTrackErr != nil {
-		return nil, fmt.Errorf("failed to create video track: %w", videoTrackErr)
+func fetchICEServers() ([]webrtc.ICEServer, error) {
+	scheme := "https"
+	if signalScheme == "ws" {
+		scheme = "http"
 	}
+	u := url.URL{Scheme: scheme, Host: signallingServer, Path: "/api/ice-servers"}
 
-	rtpSender, videoTrackErr := peerConnection.AddTrack(videoTrack)
-	if videoTrackErr != nil {
-		return nil, fmt.Errorf("failed to add video track: %w", videoTrackErr)
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	req.Header.Set("Authorization", "Bearer "+signallingToken)
 
-	// 이유는 모르겠지만 rtcp 를 받고 버려야함.
-	go func() {
-		rtcpBuf := make([]byte, 1500)
-		for {
-			if _, _, rtcpErr := rtpSender.Read(rtcpBuf); rtcpErr != nil {
-				// fmt.Printf("Failed to read RTCP packet: %v\n", rtcpErr)
-				return
-			}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ICE servers: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to fetch ICE servers: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var iceServers ICEServersResponse
+	if err := json.NewDecoder(resp.Body).Decode(&iceServers); err != nil {
+		return nil, fmt.Errorf("failed to decode ICE servers: %w", err)
+	}
+
+	return iceServers.ICEServers, nil
+}
+
+// getICEServers는 시그널링 서버에서 받은 ICE 서버 목록을 돌려준다.
+// 받아오지 못하면 이전 목록이나 기본 STUN 서버를 쓴다.
+func getICEServers() []webrtc.ICEServer {
+	iceServersCache.mu.Lock()
+	defer iceServersCache.mu.Unlock()
+
+	if iceServersCache.servers != nil && time.Since(iceServersCache.fetchedAt) < iceServersRefresh {
+		return iceServersCache.servers
+	}
+
+	servers, err := fetchICEServers()
+	if err != nil {
+		fmt.Printf("failed to get ICE servers: %v\n", err)
+		if iceServersCache.servers != nil {
+			return iceServersCache.servers
 		}
-	}()
+		return defaultICEServers
+	}
 
-	return videoTrack, nil
+	iceServersCache.servers = servers
+	iceServersCache.fetchedAt = time.Now()
+	return servers
 }
 
-func streamingVideo(iceConnectedCtx context.Context, videoTrack *webrtc.TrackLocalStaticSample) {
-	file, h264Err := os.Open(videoFileName)
-	if h264Err != nil {
-		fmt.Printf("Failed to open video file: %v", h264Err)
-		return
+// addTracks는 viewer 의 영상과 소리 track 을 peer 에 붙인다.
+// 하나만 붙여도 그것만 보낸다. 영상 track 을 붙이지 못해도 소리는 들린다.
+func addTracks(peerConnection *webrtc.PeerConnection, viewer *Viewer) error {
+	videoErr := errors.New("no video track")
+	if viewer.track != nil {
+		videoErr = addVideoTrack(peerConnection, viewer)
 	}
+	audioErr := errors.New("no audio track")
+	if viewer.audioTrack != nil {
+		audioErr = addAudioTrack(peerConnection, viewer)
+	}
+
+	if videoErr != nil && audioErr != nil {
+		return errors.Join(videoErr, audioErr)
+	}
+	if videoErr != nil && viewer.track != nil {
+		fmt.Printf("audio only: %v\n", videoErr)
+	}
+	if audioErr != nil && viewer.audioTrack != nil {
+		fmt.Printf("video only: %v\n", audioErr)
+	}
+	return nil
+}
 
-	h264, h264Err := h264reader.NewReader(file)
-	if h264Err != nil {
-		fmt.Printf("Failed to create H264 reader: %v", h264Err)
-		return
+// addVideoTrack은 viewer 의 영상 track 을 peer 에 붙인다.
+func addVideoTrack(peerConnection *webrtc.PeerConnection, viewer *Viewer) error {
+	rtpSender, videoTrackErr := peerConnection.AddTrack(viewer.track)
+	if videoTrackErr != nil {
+		return fmt.Errorf("failed to add video track: %w", videoTrackErr)
 	}
 
-	fmt.Println("streamingVideo wait for connection")
-	// connection이 되길 기다림
-	<-iceConnectedCtx.Done()
-	fmt.Println("streamingVideo start")
+	// 화면이 깨진 태블릿은 PLI/FIR 을 보낸다.
+	// receiver report 의 손실률로 simulcast layer 를 고른다.
+	go playout.ReadRTCP(rtpSender, playout.RTCPHandler{
+		KeyframeRequest: viewer.sender.RequestKeyframe,
+		ReceptionReport: viewer.onReceptionReport,
+	})
 
-	ticker := time.NewTicker(h264FrameDuration)
-	for ; true; <-ticker.C {
-		nal, h264Err := h264.NextNAL()
-		if errors.Is(h264Err, io.EOF) {
-			fmt.Println("End of video file")
-			return
-		}
-		if h264Err != nil {
-			fmt.Printf("Failed to read NAL: %v", h264Err)
-			return
-		}
+	return nil
+}
 
-		if h264Err = videoTrack.WriteSample(media.Sample{
-			Data:     nal.Data,
-			Duration: h264FrameDuration,
-		}); h264Err != nil {
-			fmt.Printf("Failed to write sample: %v", h264Err)
-			return
-		}
+func addAudioTrack(peerConnection *webrtc.PeerConnection, viewer *Viewer) error {
+	rtpSender, err := peerConnection.AddTrack(viewer.audioTrack)
+	if err != nil {
+		return fmt.Errorf("failed to add audio track: %w", err)
 	}
+
+	// 소리에는 keyframe 이 없지만 interceptor 가 동작하려면 RTCP 를 읽어야 한다.
+	go playout.ReadRTCP(rtpSender, playout.RTCPHandler{})
+
+	return nil
 }
 
-func registerConnectionStartedEvent(iceConnectedCtxCancel context.CancelFunc, peerConnection *webrtc.PeerConnection) {
+func registerConnectionStartedEvent(viewer *Viewer, peerConnection *webrtc.PeerConnection) {
 	peerConnection.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
 		fmt.Printf("ICE Connection State has changed: %s\n", connectionState.String())
 		if connectionState == webrtc.ICEConnectionStateConnected {
-			iceConnectedCtxCancel()
+			viewer.join()
 		}
 	})
 }
 
-func registerConnectionFailedEvent(peerConnection *webrtc.PeerConnection) {
+// registerConnectionFailedEvent는 peer 가 실패하거나 닫히면 재생에서 빼고 peer 목록에서 지운다.
+// 실패한 peer 는 닫아서 RTCP 를 읽는 goroutine 도 끝낸다. 다시 연결하는 태블릿은 새 offer 로 새 peer 를 만든다.
+func registerConnectionFailedEvent(clientID int32, viewer *Viewer, peerConnection *webrtc.PeerConnection) {
 	peerConnection.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
 		fmt.Printf("Peer Connection State has changed: %s\n", s.String())
 
-		if s == webrtc.PeerConnectionStateFailed {
-			// TODO peer connection cleanup
-			fmt.Println("Peer Connection State has failed exiting")
-			return
+		if s == webrtc.PeerConnectionStateFailed || s == webrtc.PeerConnectionStateClosed {
+			// 다른 peer 의 재생에는 영향이 없다.
+			viewer.leave()
+			forgetPeer(clientID, peerConnection)
+			if s == webrtc.PeerConnectionStateFailed {
+				// callback 안에서 Close 를 기다리지 않는다.
+				go func() {
+					if err := peerConnection.Close(); err != nil {
+						fmt.Printf("failed to close peer %d: %v\n", clientID, err)
+					}
+				}()
+			}
 		}
 	})
 }
@@ -370,11 +674,21 @@ func acceptOffer(peerConnection *webrtc.PeerConnection, offer webrtc.SessionDesc
 	return nil
 }
 
-func createAnswer(peerConnection *webrtc.PeerConnection) (answer webrtc.SessionDescription, err error) {
+// createAnswer는 answer 에 남은 RED/ULPFEC 를 fecInterceptor 에 알린 뒤 SetLocalDescription 한다.
+// SetLocalDescription 에서 track 을 보내기 시작하므로 그 전에 알려야 한다. fecInterceptor 는 nil 이어도 된다.
+func createAnswer(peerConnection *webrtc.PeerConnection, fecInterceptor *fec.Interceptor) (answer webrtc.SessionDescription, err error) {
 	answer, err = peerConnection.CreateAnswer(nil)
 	if err != nil {
 		return answer, fmt.Errorf("failed to create answer: %w", err)
 	}
+	if fecInterceptor != nil {
+		payloadTypes, err := negotiatedFEC(answer)
+		if err != nil {
+			return answer, err
+		}
+		fmt.Printf("negotiated fec: %+v\n", payloadTypes)
+		fecInterceptor.SetPayloadTypes(payloadTypes)
+	}
 	if err := peerConnection.SetLocalDescription(answer); err != nil {
 		return answer, fmt.Errorf("failed to set local description: %w", err)
 	}
@@ -385,10 +699,31 @@ func connectToWebsocket() (*websocket.Conn, error) {
 	u := url.URL{Scheme: signalScheme, Host: signallingServer, Path: "/ws"}
 	fmt.Printf("connecting to %s\n", u.String())
 
-	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+signallingToken)
+	c, resp, err := websocket.DefaultDialer.Dial(u.String(), header)
 	if err != nil {
+		if resp != nil {
+			// 시그널링 서버가 거절한 이유가 body 에 있음
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to connect to websocket: %w: %s %s", err, resp.Status, strings.TrimSpace(string(body)))
+		}
 		return nil, fmt.Errorf("failed to connect to websocket: %w", err)
 	}
+
+	// 시그널링 서버가 영화별로 클라이언트를 보낼 수 있도록 처음에 등록한다.
+	registerMessage, err := createRegisterMessage(movieIDs, clientCapacity)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to create register message: %w", err)
+	}
+	if err := writeMessage(c, registerMessage); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to register to signalling server: %w", err)
+	}
+	fmt.Printf("registered movies: %v capacity: %d\n", movieIDs, clientCapacity)
+
 	return c, nil
 }
 
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"server.firehunter.juhyung.dev/internal/authtoken"
+)
+
+type AuthConfig struct {
+	Secret []byte
+	// 브라우저에서 연결할 수 있는 Origin. Origin 헤더가 없는 연결(리소스 서버)은 토큰만 검사한다.
+	AllowedOrigins []string
+}
+
+var (
+	authConfig AuthConfig
+)
+
+func parseAllowedOrigins(origins string) []string {
+	var allowed []string
+	for _, origin := range strings.Split(origins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowed = append(allowed, origin)
+		}
+	}
+	return allowed
+}
+
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	return slices.Contains(authConfig.AllowedOrigins, origin)
+}
+
+// authorizeRequest는 websocket upgrade 나 API 요청 전에 origin 과 토큰을 검사한다.
+// 실패하면 이유를 응답에 쓰고 false 를 돌려준다.
+func authorizeRequest(w http.ResponseWriter, r *http.Request, roles ...authtoken.Role) (authtoken.Claims, bool) {
+	if !checkOrigin(r) {
+		fmt.Printf("rejected %s: origin not allowed: %s\n", r.URL.Path, r.Header.Get("Origin"))
+		http.Error(w, fmt.Sprintf("origin not allowed: %s", r.Header.Get("Origin")), http.StatusForbidden)
+		return authtoken.Claims{}, false
+	}
+
+	claims, err := authtoken.Authorize(authConfig.Secret, r, roles...)
+	if err != nil {
+		status := http.StatusUnauthorized
+		if errors.Is(err, authtoken.ErrForbiddenRole) {
+			status = http.StatusForbidden
+		}
+		fmt.Printf("rejected %s from %s: %v\n", r.URL.Path, r.RemoteAddr, err)
+		http.Error(w, fmt.Sprintf("unauthorized: %v", err), status)
+		return authtoken.Claims{}, false
+	}
+
+	return claims, true
+}
+
+type TokenRequest struct {
+	Subject    string `json:"subject"`
+	Role       string `json:"role"`
+	TTLSeconds int64  `json:"ttlSeconds"`
+}
+
+type TokenResponse struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// operator 는 태블릿과 리소스 서버용 토큰을 발급할 수 있다.
+func registerTokenHandler(serverMux *http.ServeMux) {
+	serverMux.HandleFunc("POST /api/tokens", func(w http.ResponseWriter, r *http.Request) {
+		operator, ok := authorizeRequest(w, r, authtoken.RoleOperator)
+		if !ok {
+			return
+		}
+
+		request, err := decode[TokenRequest](r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		role, err := authtoken.ParseRole(request.Role)
+		if err != nil || role == authtoken.RoleOperator {
+			http.Error(w, fmt.Sprintf("cannot issue token for role: %s", request.Role), http.StatusBadRequest)
+			return
+		}
+		if request.Subject == "" || request.TTLSeconds <= 0 {
+			http.Error(w, "subject and positive ttlSeconds are required", http.StatusBadRequest)
+			return
+		}
+
+		claims := authtoken.NewClaims(request.Subject, role, time.Duration(request.TTLSeconds)*time.Second)
+		token, err := authtoken.Sign(authConfig.Secret, claims)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Printf("operator %s issued %s token for %s\n", operator.Subject, role, request.Subject)
+		if err := encode(w, r, http.StatusOK, TokenResponse{Token: token, ExpiresAt: claims.ExpiresAt}); err != nil {
+			fmt.Println("Error encoding token response: ", err)
+		}
+	})
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"net/http"
+
+	"server.firehunter.juhyung.dev/internal/moviecatalog"
+)
+
+// 클라이언트가 고를 수 있고 리소스 서버가 등록할 수 있는 영화 목록
+var movieCatalog *moviecatalog.Catalog
+
+// 프론트엔드가 영화 목록을 하드코딩하지 않고 받아 가도록 인증 없이 연다.
+func registerMoviesHandler(serverMux *http.ServeMux) {
+	movieCatalog.RegisterHandler(serverMux)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// 리소스 서버가 /ws 에 연결하자마자 보내는 첫 메시지의 data
+type ResourceServerRegistration struct {
+	MovieIDs []string `json:"movieIds"`
+	Capacity int      `json:"capacity"`
+}
+
+type ResourceServer struct {
+	ID       int32
+	MovieIDs []string
+	Capacity int
+
+	requests chan ResourceServerRequest
+	// 리소스 서버의 연결이 끊기면 닫힘
+	done    chan struct{}
+	clients map[int32]struct{}
+}
+
+func (s *ResourceServer) serves(movieID string) bool {
+	return slices.Contains(s.MovieIDs, movieID)
+}
+
+// send는 리소스 서버가 살아있는 동안만 요청을 넘긴다.
+func (s *ResourceServer) send(request ResourceServerRequest) error {
+	select {
+	case s.requests <- request:
+		return nil
+	case <-s.done:
+		return fmt.Errorf("resource server %d disconnected", s.ID)
+	}
+}
+
+var errNoResourceServer = errors.New("no resource server available")
+
+type ResourceServers struct {
+	servers map[int32]*ResourceServer
+	nextID  int32
+	mu      sync.Mutex
+}
+
+var (
+	resourceServers = ResourceServers{servers: make(map[int32]*ResourceServer)}
+)
+
+func parseResourceServerRegistration(message ResourceServerWebSocketMessage) (ResourceServerRegistration, error) {
+	if message.Type != "register" {
+		return ResourceServerRegistration{}, fmt.Errorf("expected register message but got: %s", message.Type)
+	}
+
+	var registration ResourceServerRegistration
+	if err := json.Unmarshal(message.Data, &registration); err != nil {
+		return ResourceServerRegistration{}, fmt.Errorf("failed to unmarshal registration: %w", err)
+	}
+
+	if len(registration.MovieIDs) == 0 {
+		return ResourceServerRegistration{}, fmt.Errorf("registration has no movie ids")
+	}
+	for _, movieID := range registration.MovieIDs {
+		if _, ok := movieCatalog.Movie(movieID); !ok {
+			return ResourceServerRegistration{}, fmt.Errorf("movie %s is not in the catalog", movieID)
+		}
+	}
+	if registration.Capacity <= 0 {
+		return ResourceServerRegistration{}, fmt.Errorf("invalid capacity: %d", registration.Capacity)
+	}
+
+	return registration, nil
+}
+
+func registerResourceServer(registration ResourceServerRegistration) *ResourceServer {
+	resourceServers.mu.Lock()
+	defer resourceServers.mu.Unlock()
+
+	resourceServers.nextID++
+	server := &ResourceServer{
+		ID:       resourceServers.nextID,
+		MovieIDs: registration.MovieIDs,
+		Capacity: registration.Capacity,
+		requests: make(chan ResourceServerRequest),
+		done:     make(chan struct{}),
+		clients:  make(map[int32]struct{}),
+	}
+	resourceServers.servers[server.ID] = server
+
+	fmt.Printf("resource server %d registered movies: %v capacity: %d\n", server.ID, server.MovieIDs, server.Capacity)
+	return server
+}
+
+func unregisterResourceServer(server *ResourceServer) {
+	resourceServers.mu.Lock()
+	defer resourceServers.mu.Unlock()
+
+	delete(resourceServers.servers, server.ID)
+	close(server.done)
+
+	fmt.Printf("resource server %d unregistered\n", server.ID)
+}
+
+// assignResourceServer는 movieID 를 서비스하는 서버 중 가장 여유 있는 서버에 클라이언트를 고정한다.
+func assignResourceServer(movieID string, clientID int32) (*ResourceServer, error) {
+	resourceServers.mu.Lock()
+	defer resourceServers.mu.Unlock()
+
+	var selected *ResourceServer
+	for _, server := range resourceServers.servers {
+		if !server.serves(movieID) || len(server.clients) >= server.Capacity {
+			continue
+		}
+		if selected == nil || server.Capacity-len(server.clients) > selected.Capacity-len(selected.clients) {
+			selected = server
+		}
+	}
+
+	if selected == nil {
+		return nil, fmt.Errorf("%w for movie: %s", errNoResourceServer, movieID)
+	}
+
+	selected.clients[clientID] = struct{}{}
+	fmt.Printf("client %d assigned to resource server %d for movie %s\n", clientID, selected.ID, movieID)
+	return selected, nil
+}
+
+func releaseResourceServer(server *ResourceServer, clientID int32) {
+	resourceServers.mu.Lock()
+	defer resourceServers.mu.Unlock()
+
+	delete(server.clients, clientID)
+}
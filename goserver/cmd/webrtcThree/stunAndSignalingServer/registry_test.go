@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"server.firehunter.juhyung.dev/internal/moviecatalog"
+)
+
+// resetSignaling은 전역 catalog 와 리소스 서버 목록을 영화 1, 2, 3 만 있는 빈 상태로 되돌린다.
+func resetSignaling(t *testing.T) {
+	t.Helper()
+	movieCatalog = &moviecatalog.Catalog{Movies: []moviecatalog.Movie{{ID: "1"}, {ID: "2"}, {ID: "3"}}}
+	resourceServers = ResourceServers{servers: make(map[int32]*ResourceServer)}
+}
+
+// startResourceServer는 리소스 서버를 등록하고 받은 요청을 requests 로 넘긴다. 테스트가 끝나면 연결을 끊는다.
+func startResourceServer(t *testing.T, capacity int, movieIDs ...string) (*ResourceServer, <-chan ResourceServerRequest) {
+	t.Helper()
+	server := registerResourceServer(ResourceServerRegistration{MovieIDs: movieIDs, Capacity: capacity})
+	requests := make(chan ResourceServerRequest, 16)
+	go func() {
+		for {
+			select {
+			case request := <-server.requests:
+				requests <- request
+			case <-server.done:
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		select {
+		case <-server.done:
+		default:
+			unregisterResourceServer(server)
+		}
+	})
+	return server, requests
+}
+
+func TestParseResourceServerRegistration(t *testing.T) {
+	resetSignaling(t)
+	tests := []struct {
+		name        string
+		messageType string
+		data        string
+		wantErr     bool
+	}{
+		{name: "valid", messageType: "register", data: `{"movieIds":["1","2"],"capacity":3}`},
+		{name: "not register", messageType: "offer", data: `{"movieIds":["1"],"capacity":3}`, wantErr: true},
+		{name: "bad json", messageType: "register", data: `{"movieIds":`, wantErr: true},
+		{name: "no movies", messageType: "register", data: `{"movieIds":[],"capacity":3}`, wantErr: true},
+		{name: "movie not in catalog", messageType: "register", data: `{"movieIds":["1","9"],"capacity":3}`, wantErr: true},
+		{name: "zero capacity", messageType: "register", data: `{"movieIds":["1"]}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registration, err := parseResourceServerRegistration(ResourceServerWebSocketMessage{Type: tt.messageType, Data: json.RawMessage(tt.data)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseResourceServerRegistration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (len(registration.MovieIDs) != 2 || registration.Capacity != 3) {
+				t.Fatalf("registration = %+v", registration)
+			}
+		})
+	}
+}
+
+func TestAssignResourceServer(t *testing.T) {
+	resetSignaling(t)
+	both, _ := startResourceServer(t, 2, "1", "2")
+	first, _ := startResourceServer(t, 3, "1")
+
+	// 영화 2 는 both 만 서비스한다.
+	if server, err := assignResourceServer("2", 1); err != nil || server != both {
+		t.Fatalf("movie 2 assigned to %v, %v, want server %d", server, err, both.ID)
+	}
+	// 영화 1 은 남은 자리가 더 많은 쪽으로 간다. first 3 자리, both 1 자리
+	for clientID := int32(2); clientID <= 3; clientID++ {
+		if server, err := assignResourceServer("1", clientID); err != nil || server != first {
+			t.Fatalf("client %d assigned to %v, %v, want server %d", clientID, server, err, first.ID)
+		}
+	}
+	// 둘 다 1 자리 남았다.
+	if _, err := assignResourceServer("1", 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := assignResourceServer("1", 5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := assignResourceServer("1", 6); err == nil {
+		t.Fatal("client assigned beyond the capacity of every server")
+	}
+	if _, err := assignResourceServer("3", 7); err == nil {
+		t.Fatal("client assigned to a movie nobody serves")
+	}
+
+	releaseResourceServer(both, 1)
+	if server, err := assignResourceServer("2", 8); err != nil || server != both {
+		t.Fatalf("client after a release assigned to %v, %v, want server %d", server, err, both.ID)
+	}
+}
+
+func TestUnregisteredResourceServer(t *testing.T) {
+	resetSignaling(t)
+	server, _ := startResourceServer(t, 10, "1")
+	unregisterResourceServer(server)
+
+	if _, err := assignResourceServer("1", 1); err == nil {
+		t.Fatal("client assigned to an unregistered server")
+	}
+	if err := server.send(ResourceServerRequest{}); err == nil {
+		t.Fatal("send to an unregistered server succeeded")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// 응답을 기다리는 요청. 같은 id 로 response 가 와야 한다.
+	messageKindRequest = "request"
+	// 요청에 대한 응답 (answer 또는 error)
+	messageKindResponse = "response"
+	// 요청 없이 보내는 메시지 (trickle candidate 등)
+	messageKindPush = "push"
+)
+
+var (
+	requestTimeout = 10 * time.Second
+
+	// 클라이언트가 보내는 메시지 중 응답을 기다려야 하는 타입
+	requestTypes = map[string]bool{
+		"offer": true,
+	}
+)
+
+type ErrorData struct {
+	Message     string `json:"message"`
+	RequestType string `json:"requestType,omitempty"`
+}
+
+func createErrorMessage(requestID int64, clientID int32, requestType string, cause error) ResourceServerWebSocketMessage {
+	data, err := json.Marshal(ErrorData{Message: cause.Error(), RequestType: requestType})
+	if err != nil {
+		// string 두 개라서 실패할 일이 없음
+		data = []byte(`{"message":"internal error"}`)
+	}
+
+	return ResourceServerWebSocketMessage{
+		ID:       requestID,
+		Kind:     messageKindResponse,
+		ClientID: clientID,
+		Type:     "error",
+		Data:     data,
+	}
+}
+
+type PendingRequest struct {
+	ID          int64
+	RequestType string
+	ServerID    int32
+	Session     *ClientSession
+	timer       *time.Timer
+}
+
+type PendingRequests struct {
+	requests map[int64]*PendingRequest
+	nextID   int64
+	mu       sync.Mutex
+}
+
+var (
+	pendingRequests = PendingRequests{requests: make(map[int64]*PendingRequest)}
+)
+
+// addPendingRequest는 요청을 보내기 전에 호출해야 한다.
+// timeout 안에 응답이 오지 않으면 클라이언트에게 error 를 보낸다.
+func addPendingRequest(session *ClientSession, serverID int32, requestType string, timeout time.Duration) int64 {
+	pendingRequests.mu.Lock()
+	defer pendingRequests.mu.Unlock()
+
+	pendingRequests.nextID++
+	request := &PendingRequest{
+		ID:          pendingRequests.nextID,
+		RequestType: requestType,
+		ServerID:    serverID,
+		Session:     session,
+	}
+	request.timer = time.AfterFunc(timeout, func() {
+		failPendingRequest(request.ID, fmt.Errorf("request timed out after %v", timeout))
+	})
+	pendingRequests.requests[request.ID] = request
+
+	return request.ID
+}
+
+// resolvePendingRequest는 대기 중인 요청을 꺼내고 타이머를 멈춘다.
+func resolvePendingRequest(requestID int64) (*PendingRequest, error) {
+	pendingRequests.mu.Lock()
+	defer pendingRequests.mu.Unlock()
+
+	request, ok := pendingRequests.requests[requestID]
+	if !ok {
+		return nil, fmt.Errorf("no pending request found for id: %d", requestID)
+	}
+	delete(pendingRequests.requests, requestID)
+	request.timer.Stop()
+
+	return request, nil
+}
+
+func failPendingRequest(requestID int64, cause error) {
+	request, err := resolvePendingRequest(requestID)
+	if err != nil {
+		// 이미 응답이 왔거나 다른 이유로 처리됨
+		return
+	}
+
+	fmt.Printf("request %d (%s) of client %d failed: %v\n", request.ID, request.RequestType, request.Session.ID, cause)
+	request.Session.deliver(ResourceServerResponse{
+		Response: createErrorMessage(request.ID, request.Session.ID, request.RequestType, cause),
+		Error:    cause,
+	})
+}
+
+// failServerPendingRequests는 리소스 서버 연결이 끊겼을 때 그 서버로 간 요청을 모두 실패 처리한다.
+func failServerPendingRequests(serverID int32, cause error) {
+	pendingRequests.mu.Lock()
+	var requestIDs []int64
+	for id, request := range pendingRequests.requests {
+		if request.ServerID == serverID {
+			requestIDs = append(requestIDs, id)
+		}
+	}
+	pendingRequests.mu.Unlock()
+
+	for _, id := range requestIDs {
+		failPendingRequest(id, cause)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestSession은 연결이 붙어 있는 세션을 만든다. 응답은 connection.responses 로 받는다.
+func newTestSession(t *testing.T, clientID int32) (*ClientSession, *ClientConnection) {
+	t.Helper()
+	session := &ClientSession{ID: clientID}
+	connection := session.attach()
+	t.Cleanup(func() {
+		session.detach(connection, time.Hour)
+		session.mu.Lock()
+		session.expiry.Stop()
+		session.mu.Unlock()
+	})
+	return session, connection
+}
+
+func resetPendingRequests(t *testing.T) {
+	t.Helper()
+	pendingRequests = PendingRequests{requests: make(map[int64]*PendingRequest)}
+}
+
+func receiveError(t *testing.T, connection *ClientConnection) (ResourceServerWebSocketMessage, ErrorData) {
+	t.Helper()
+	select {
+	case response := <-connection.responses:
+		var data ErrorData
+		if err := json.Unmarshal(response.Response.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		if response.Response.Kind != messageKindResponse || response.Response.Type != "error" || response.Error == nil {
+			t.Fatalf("response = %+v, want an error response", response)
+		}
+		return response.Response, data
+	case <-time.After(time.Second):
+		t.Fatal("no error response")
+		return ResourceServerWebSocketMessage{}, ErrorData{}
+	}
+}
+
+func expectNoResponse(t *testing.T, connection *ClientConnection) {
+	t.Helper()
+	select {
+	case response := <-connection.responses:
+		t.Fatalf("unexpected response %+v", response.Response)
+	default:
+	}
+}
+
+func TestPendingRequestTimeout(t *testing.T) {
+	resetPendingRequests(t)
+	session, connection := newTestSession(t, 7)
+
+	id := addPendingRequest(session, 1, "offer", 10*time.Millisecond)
+	message, data := receiveError(t, connection)
+	if message.ID != id || message.ClientID != 7 {
+		t.Fatalf("error response id %d client %d, want id %d client 7", message.ID, message.ClientID, id)
+	}
+	if data.RequestType != "offer" || !strings.Contains(data.Message, "timed out") {
+		t.Fatalf("error data = %+v, want a timed out offer", data)
+	}
+	// 늦게 온 응답은 찾지 못한다.
+	if _, err := resolvePendingRequest(id); err == nil {
+		t.Fatal("resolved a timed out request")
+	}
+}
+
+func TestResolvePendingRequest(t *testing.T) {
+	resetPendingRequests(t)
+	session, connection := newTestSession(t, 7)
+
+	id := addPendingRequest(session, 3, "offer", 20*time.Millisecond)
+	if other := addPendingRequest(session, 3, "offer", time.Hour); other == id {
+		t.Fatalf("two requests got the same id %d", id)
+	}
+	request, err := resolvePendingRequest(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Session != session || request.ServerID != 3 || request.RequestType != "offer" {
+		t.Fatalf("request = %+v", request)
+	}
+	if _, err := resolvePendingRequest(id); err == nil {
+		t.Fatal("resolved the same request twice")
+	}
+
+	// 응답이 온 요청은 타이머가 멈췄고 실패시켜도 아무것도 보내지 않는다.
+	time.Sleep(40 * time.Millisecond)
+	failPendingRequest(id, errors.New("late"))
+	expectNoResponse(t, connection)
+}
+
+func TestFailServerPendingRequests(t *testing.T) {
+	resetPendingRequests(t)
+	session, connection := newTestSession(t, 7)
+
+	dropped := []int64{
+		addPendingRequest(session, 1, "offer", time.Hour),
+		addPendingRequest(session, 1, "offer", time.Hour),
+	}
+	other := addPendingRequest(session, 2, "offer", time.Hour)
+
+	failServerPendingRequests(1, errors.New("resource server 1 disconnected"))
+	got := make(map[int64]bool)
+	for range dropped {
+		message, data := receiveError(t, connection)
+		if !strings.Contains(data.Message, "disconnected") {
+			t.Fatalf("error data = %+v, want the disconnect cause", data)
+		}
+		got[message.ID] = true
+	}
+	for _, id := range dropped {
+		if !got[id] {
+			t.Fatalf("request %d was not failed, got %v", id, got)
+		}
+	}
+	expectNoResponse(t, connection)
+
+	// 다른 서버로 간 요청은 그대로 기다린다.
+	if _, err := resolvePendingRequest(other); err != nil {
+		t.Fatalf("request to another server: %v", err)
+	}
+}
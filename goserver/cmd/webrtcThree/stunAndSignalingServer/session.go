@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	mathrand "math/rand"
+	"sync"
+	"time"
+
+	"server.firehunter.juhyung.dev/internal/turncred"
+)
+
+var (
+	// 클라이언트 연결이 끊긴 뒤 이 시간 안에 토큰으로 다시 연결하면 같은 세션을 이어서 쓴다.
+	sessionGraceWindow = 30 * time.Second
+	// 클라이언트에게 아직 쓰지 못한 메시지를 이만큼까지 쌓아 둔다.
+	// 넘치면 그 클라이언트 연결을 끊는다. 다시 연결하면 세션을 이어서 쓴다.
+	clientResponseBuffer = 64
+	// 멈춘 클라이언트에게 쓰다가 막히면 이 시간 뒤에 연결을 끊는다.
+	clientWriteTimeout = 10 * time.Second
+)
+
+// ClientSession은 클라이언트 하나의 세션을 나타낸다.
+// websocket 이 다시 연결되어도 ID 와 리소스 서버는 그대로 유지된다.
+type ClientSession struct {
+	ID      int32
+	Token   string
+	MovieID string
+	Server  *ResourceServer
+
+	mu sync.Mutex
+	// 연결이 끊겨 있는 동안은 nil
+	connection *ClientConnection
+	expiry     *time.Timer
+}
+
+// ClientConnection은 /client/ws 연결 하나를 나타낸다.
+// 응답과 리소스 서버의 push 메시지는 모두 responses 로 전달된다.
+type ClientConnection struct {
+	responses chan ResourceServerResponse
+	// 클라이언트 연결이 끊기면 닫힘
+	closed chan struct{}
+	// responses 가 넘치면 닫힘. 연결을 끊으라는 뜻이다.
+	overflowed   chan struct{}
+	overflowOnce sync.Once
+}
+
+type SessionData struct {
+	Token      string               `json:"token"`
+	ClientID   int32                `json:"clientId"`
+	Resumed    bool                 `json:"resumed"`
+	ICEServers []turncred.ICEServer `json:"iceServers"`
+}
+
+// deliver는 클라이언트가 연결되어 있는 동안만 메시지를 넘긴다.
+// 리소스 서버의 read loop 에서 부르므로 막히지 않는다. 느린 클라이언트 하나가 다른 클라이언트의 응답을 붙잡지 않도록
+// 버퍼가 차면 메시지를 버리고 그 연결을 끊는다.
+func (s *ClientSession) deliver(response ResourceServerResponse) bool {
+	s.mu.Lock()
+	connection := s.connection
+	s.mu.Unlock()
+
+	if connection == nil {
+		fmt.Printf("client %d is detached, dropping %s\n", s.ID, response.Response.Type)
+		return false
+	}
+
+	select {
+	case <-connection.closed:
+		return false
+	default:
+	}
+
+	select {
+	case connection.responses <- response:
+		return true
+	default:
+		fmt.Printf("client %d is too slow, dropping %s and closing its connection\n", s.ID, response.Response.Type)
+		connection.overflowOnce.Do(func() {
+			close(connection.overflowed)
+		})
+		return false
+	}
+}
+
+// attach는 새 연결을 세션에 붙인다. 이전 연결이 남아 있으면 더 이상 메시지를 받지 못한다.
+func (s *ClientSession) attach() *ClientConnection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+
+	connection := &ClientConnection{
+		responses:  make(chan ResourceServerResponse, clientResponseBuffer),
+		closed:     make(chan struct{}),
+		overflowed: make(chan struct{}),
+	}
+	s.connection = connection
+	return connection
+}
+
+// detach는 연결을 떼어내고 grace 가 지나면 세션을 만료시킨다.
+func (s *ClientSession) detach(connection *ClientConnection, grace time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	close(connection.closed)
+	if s.connection != connection {
+		// 이미 새 연결이 붙음
+		return
+	}
+
+	s.connection = nil
+	s.expiry = time.AfterFunc(grace, func() {
+		expireClientSession(s)
+	})
+	fmt.Printf("client %d detached, waiting %v for resume\n", s.ID, grace)
+}
+
+func (s *ClientSession) createSessionMessage(resumed bool, iceServers []turncred.ICEServer) (ResourceServerWebSocketMessage, error) {
+	data, err := json.Marshal(SessionData{Token: s.Token, ClientID: s.ID, Resumed: resumed, ICEServers: iceServers})
+	if err != nil {
+		return ResourceServerWebSocketMessage{}, fmt.Errorf("failed to marshal session: %w", err)
+	}
+
+	return ResourceServerWebSocketMessage{
+		Kind:     messageKindPush,
+		ClientID: s.ID,
+		Type:     "session",
+		Data:     data,
+	}, nil
+}
+
+type ClientSessions struct {
+	sessions map[int32]*ClientSession
+	tokens   map[string]*ClientSession
+	mu       sync.RWMutex
+}
+
+var (
+	clientSessions = ClientSessions{
+		sessions: make(map[int32]*ClientSession),
+		tokens:   make(map[string]*ClientSession),
+	}
+)
+
+func generateSessionToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate session token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// addClientSession은 사용 중이지 않은 ClientID 를 골라 movieID 를 서비스하는 리소스 서버에 고정한 세션을 만든다.
+// 같은 ID 를 두 번 고르지 않도록 ID 를 고르고 넣을 때까지 lock 을 잡는다.
+func addClientSession(movieID string) (*ClientSession, error) {
+	token, err := generateSessionToken()
+	if err != nil {
+		return nil, err
+	}
+
+	clientSessions.mu.Lock()
+	defer clientSessions.mu.Unlock()
+
+	var clientID int32
+	for {
+		clientID = mathrand.Int31()
+		if _, ok := clientSessions.sessions[clientID]; !ok {
+			break
+		}
+	}
+	// 세션이 끝날 때까지 같은 리소스 서버를 사용한다.
+	server, err := assignResourceServer(movieID, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &ClientSession{
+		ID:      clientID,
+		Token:   token,
+		MovieID: movieID,
+		Server:  server,
+	}
+	clientSessions.sessions[clientID] = session
+	clientSessions.tokens[token] = session
+	return session, nil
+}
+
+// resumeClientSession은 만료되지 않은 세션을 토큰으로 찾아 새 연결을 붙인다.
+// 다른 영화의 세션이면 이어 붙이지 않는다. 그러면 openClientSession 이 새 세션을 만든다.
+// 만료와 겹치지 않도록 clientSessions 의 lock 을 잡은 채로 attach 한다.
+func resumeClientSession(token string, movieID string) (*ClientSession, *ClientConnection, error) {
+	clientSessions.mu.Lock()
+	defer clientSessions.mu.Unlock()
+
+	session, ok := clientSessions.tokens[token]
+	if !ok {
+		return nil, nil, fmt.Errorf("session expired or unknown")
+	}
+	if session.MovieID != movieID {
+		return nil, nil, fmt.Errorf("session %d is for movie %s, not %q", session.ID, session.MovieID, movieID)
+	}
+
+	select {
+	case <-session.Server.done:
+		return nil, nil, fmt.Errorf("resource server of session %d disconnected", session.ID)
+	default:
+	}
+
+	return session, session.attach(), nil
+}
+
+// expireClientSession은 세션을 지우고 리소스 서버에게 peer 를 정리하라고 알린다.
+func expireClientSession(session *ClientSession) {
+	clientSessions.mu.Lock()
+	session.mu.Lock()
+	if session.connection != nil {
+		// 타이머가 멈추기 직전에 다시 연결됨
+		session.mu.Unlock()
+		clientSessions.mu.Unlock()
+		return
+	}
+	delete(clientSessions.sessions, session.ID)
+	delete(clientSessions.tokens, session.Token)
+	session.mu.Unlock()
+	clientSessions.mu.Unlock()
+
+	releaseResourceServer(session.Server, session.ID)
+	if err := session.Server.send(ResourceServerRequest{
+		Request: ResourceServerWebSocketMessage{
+			Kind:     messageKindPush,
+			ClientID: session.ID,
+			Type:     "close",
+		},
+		Session: session,
+	}); err != nil {
+		fmt.Println("Error sending close to resource server: ", err)
+	}
+
+	fmt.Printf("client %d session expired\n", session.ID)
+}
+
+func getClientSession(clientID int32) (*ClientSession, error) {
+	clientSessions.mu.RLock()
+	defer clientSessions.mu.RUnlock()
+
+	session, ok := clientSessions.sessions[clientID]
+	if !ok {
+		return nil, fmt.Errorf("client session not found: %d", clientID)
+	}
+	return session, nil
+}
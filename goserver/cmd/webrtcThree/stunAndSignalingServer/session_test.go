@@ -0,0 +1,225 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func resetClientSessions(t *testing.T) {
+	t.Helper()
+	resetSignaling(t)
+	clientSessions = ClientSessions{
+		sessions: make(map[int32]*ClientSession),
+		tokens:   make(map[string]*ClientSession),
+	}
+}
+
+func open(t *testing.T, movieID string, token string) (*ClientSession, *ClientConnection, bool, *SessionError) {
+	t.Helper()
+	query := url.Values{"movieId": {movieID}}
+	if token != "" {
+		query.Set("sessionToken", token)
+	}
+	return openClientSession(httptest.NewRequest(http.MethodGet, "/client/ws?"+query.Encode(), nil))
+}
+
+func mustOpen(t *testing.T, movieID string, token string, wantResumed bool) (*ClientSession, *ClientConnection) {
+	t.Helper()
+	session, connection, resumed, err := open(t, movieID, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed != wantResumed {
+		t.Fatalf("resumed = %v, want %v", resumed, wantResumed)
+	}
+	return session, connection
+}
+
+func TestResumeClientSession(t *testing.T) {
+	resetClientSessions(t)
+	server, _ := startResourceServer(t, 10, "1", "2")
+
+	session, connection := mustOpen(t, "1", "", false)
+	session.detach(connection, time.Hour)
+
+	resumed, connection := mustOpen(t, "1", session.Token, true)
+	if resumed != session {
+		t.Fatalf("resumed session %d, want %d", resumed.ID, session.ID)
+	}
+	// 새 연결만 메시지를 받는다.
+	if !session.deliver(ResourceServerResponse{}) {
+		t.Fatal("message to the resumed connection was dropped")
+	}
+	<-connection.responses
+	if len(server.clients) != 1 {
+		t.Fatalf("resource server has %d clients, want 1", len(server.clients))
+	}
+	session.detach(connection, time.Hour)
+}
+
+func TestResumeFallsBackToNewSession(t *testing.T) {
+	resetClientSessions(t)
+	startResourceServer(t, 10, "1", "2")
+
+	session, connection := mustOpen(t, "1", "", false)
+	session.detach(connection, time.Hour)
+
+	tests := []struct {
+		name    string
+		movieID string
+		token   string
+	}{
+		{name: "unknown token", movieID: "1", token: "unknown"},
+		// 다른 영화의 세션은 이어 붙이지 않는다.
+		{name: "other movie", movieID: "2", token: session.Token},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other, connection := mustOpen(t, tt.movieID, tt.token, false)
+			defer other.detach(connection, time.Hour)
+			if other == session || other.Token == session.Token {
+				t.Fatal("got the old session")
+			}
+			if other.MovieID != tt.movieID {
+				t.Fatalf("movie = %s, want %s", other.MovieID, tt.movieID)
+			}
+		})
+	}
+
+	// 이어 붙이지 못한 세션은 그대로 남아서 원래 영화로는 이어 붙일 수 있다.
+	_, connection = mustOpen(t, "1", session.Token, true)
+	session.detach(connection, time.Hour)
+}
+
+func TestClientSessionExpiry(t *testing.T) {
+	resetClientSessions(t)
+	server, requests := startResourceServer(t, 10, "1")
+
+	session, connection := mustOpen(t, "1", "", false)
+	session.detach(connection, 10*time.Millisecond)
+
+	select {
+	case request := <-requests:
+		if request.Request.Type != "close" || request.Request.ClientID != session.ID {
+			t.Fatalf("request = %+v, want close of client %d", request.Request, session.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("resource server was not told to close the peer")
+	}
+	if _, err := getClientSession(session.ID); err == nil {
+		t.Fatal("expired session is still registered")
+	}
+	resourceServers.mu.Lock()
+	clients := len(server.clients)
+	resourceServers.mu.Unlock()
+	if clients != 0 {
+		t.Fatalf("resource server has %d clients, want the expired one released", clients)
+	}
+
+	// 만료된 토큰으로 연결하면 새 세션이다.
+	other, connection := mustOpen(t, "1", session.Token, false)
+	if other.ID == session.ID {
+		t.Fatal("got the expired session")
+	}
+	other.detach(connection, time.Hour)
+}
+
+func TestClientSessionResumeStopsExpiry(t *testing.T) {
+	resetClientSessions(t)
+	_, requests := startResourceServer(t, 10, "1")
+
+	session, connection := mustOpen(t, "1", "", false)
+	session.detach(connection, 20*time.Millisecond)
+	_, connection = mustOpen(t, "1", session.Token, true)
+
+	time.Sleep(50 * time.Millisecond)
+	if _, err := getClientSession(session.ID); err != nil {
+		t.Fatalf("resumed session expired: %v", err)
+	}
+	select {
+	case request := <-requests:
+		t.Fatalf("unexpected request %+v", request.Request)
+	default:
+	}
+	session.detach(connection, time.Hour)
+}
+
+func TestResumeAfterResourceServerDisconnects(t *testing.T) {
+	resetClientSessions(t)
+	server, _ := startResourceServer(t, 10, "1")
+
+	session, connection := mustOpen(t, "1", "", false)
+	session.detach(connection, time.Hour)
+	unregisterResourceServer(server)
+
+	// 이어 붙일 수 없고 새로 고를 서버도 없다.
+	if _, _, _, err := open(t, "1", session.Token); err == nil || err.status != http.StatusServiceUnavailable {
+		t.Fatalf("error = %v, want %d", err, http.StatusServiceUnavailable)
+	}
+
+	replacement, _ := startResourceServer(t, 10, "1")
+	other, connection := mustOpen(t, "1", session.Token, false)
+	if other.Server != replacement {
+		t.Fatalf("new session on server %d, want %d", other.Server.ID, replacement.ID)
+	}
+	other.detach(connection, time.Hour)
+}
+
+func TestOpenClientSessionErrors(t *testing.T) {
+	resetClientSessions(t)
+	startResourceServer(t, 1, "1")
+
+	tests := []struct {
+		name    string
+		movieID string
+		want    int
+	}{
+		{name: "no movie", want: http.StatusBadRequest},
+		{name: "unknown movie", movieID: "9", want: http.StatusNotFound},
+		{name: "movie nobody serves", movieID: "3", want: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, _, err := open(t, tt.movieID, ""); err == nil || err.status != tt.want {
+				t.Fatalf("error = %v, want %d", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddClientSessionUniqueIDs(t *testing.T) {
+	resetClientSessions(t)
+	startResourceServer(t, 1000, "1")
+
+	var wg sync.WaitGroup
+	sessions := make(chan *ClientSession, 100)
+	for i := 0; i < cap(sessions); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session, err := addClientSession("1")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			sessions <- session
+		}()
+	}
+	wg.Wait()
+	close(sessions)
+
+	seen := make(map[int32]bool)
+	for session := range sessions {
+		if seen[session.ID] {
+			t.Fatalf("client id %d was given twice", session.ID)
+		}
+		seen[session.ID] = true
+	}
+	if len(clientSessions.sessions) != len(seen) {
+		t.Fatalf("%d sessions registered, want %d", len(clientSessions.sessions), len(seen))
+	}
+}
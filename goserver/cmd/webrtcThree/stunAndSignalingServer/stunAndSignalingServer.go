@@ -7,27 +7,29 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"math/rand"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
-	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pion/turn/v3"
 	"github.com/rs/cors"
-)
-
-var (
-	toResourceServer chan ResourceServerRequest = make(chan ResourceServerRequest)
+	"server.firehunter.juhyung.dev/internal/authtoken"
+	"server.firehunter.juhyung.dev/internal/moviecatalog"
+	"server.firehunter.juhyung.dev/internal/peeracl"
+	"server.firehunter.juhyung.dev/internal/turncred"
+	"server.firehunter.juhyung.dev/internal/turnguard"
 )
 
 type ResourceServerRequest struct {
-	Request      ResourceServerWebSocketMessage
-	ResponseChan chan ResourceServerResponse
+	Request ResourceServerWebSocketMessage
+	Session *ClientSession
 }
 
 type ResourceServerResponse struct {
@@ -41,9 +43,14 @@ type ClientWebSocketMessage struct {
 }
 
 type ResourceServerWebSocketMessage struct {
-	ClientID int32           `json:"clientId"`
-	Type     string          `json:"type"`
-	Data     json.RawMessage `json:"data"`
+	// request 와 그에 대한 response 는 같은 ID 를 가진다. push 는 0.
+	ID       int64  `json:"id,omitempty"`
+	Kind     string `json:"kind"`
+	ClientID int32  `json:"clientId"`
+	// 리소스 서버가 클라이언트에게 보낼 영화를 고를 수 있게 붙여 보낸다.
+	MovieID string          `json:"movieId,omitempty"`
+	Type    string          `json:"type"`
+	Data    json.RawMessage `json:"data"`
 }
 
 type WebSocketData interface{}
@@ -67,6 +74,52 @@ func parseResourceServerWebSocketMessage(message []byte) (ResourceServerWebSocke
 }
 
 func main() {
+	authSecret := flag.String("auth-secret", os.Getenv("FIREHUNTER_AUTH_SECRET"), "HMAC secret used to verify websocket tokens (env FIREHUNTER_AUTH_SECRET)")
+	allowedOrigins := flag.String("allowed-origins", envOrDefault("FIREHUNTER_ALLOWED_ORIGINS", "http://localhost:5173"), "Comma separated browser origins allowed to connect (env FIREHUNTER_ALLOWED_ORIGINS)")
+	turnSecret := flag.String("turn-secret", os.Getenv("FIREHUNTER_TURN_SECRET"), "Shared secret for ephemeral TURN credentials (env FIREHUNTER_TURN_SECRET). Empty disables TURN")
+	turnURLs := flag.String("turn-urls", "turn:turn.i.juhyung.dev:3478", "Comma separated TURN URLs handed to clients")
+	stunURLs := flag.String("stun-urls", "stun:stun.i.juhyung.dev:3478", "Comma separated STUN URLs handed to clients")
+	turnTTL := flag.Duration("turn-ttl", 12*time.Hour, "How long issued TURN credentials are valid")
+	realm := flag.String("realm", "turn.i.juhyung.dev", "Realm of the embedded TURN server")
+	turnAllowPeers := flag.String("turn-allow-peers", "", "Comma separated peer CIDRs the embedded TURN server relays to even when they are in -turn-deny-peers")
+	turnDenyPeers := flag.String("turn-deny-peers", peeracl.DefaultDeny, "Comma separated peer CIDRs the embedded TURN server does not relay to")
+	stunRateLimit := flag.Float64("stun-rate-limit", 20, "STUN/TURN requests per second accepted from one source IP. 0 disables rate limiting")
+	stunBanDuration := flag.Duration("stun-ban-duration", 5*time.Minute, "How long a source IP that keeps exceeding -stun-rate-limit is ignored")
+	catalogPath := flag.String("catalog", moviecatalog.DefaultPath, "Movie catalog file served at /api/movies")
+	flag.Parse()
+
+	if *authSecret == "" {
+		fmt.Fprintln(os.Stderr, "'auth-secret' is required")
+		os.Exit(1)
+	}
+	authConfig = AuthConfig{
+		Secret:         []byte(*authSecret),
+		AllowedOrigins: parseAllowedOrigins(*allowedOrigins),
+	}
+	fmt.Println("allowed origins:", authConfig.AllowedOrigins)
+	turnConfig = turncred.Config{
+		Secret:   *turnSecret,
+		TTL:      *turnTTL,
+		STUNURLs: turncred.SplitURLs(*stunURLs),
+		TURNURLs: turncred.SplitURLs(*turnURLs),
+	}
+	turnRealm = *realm
+	stunRateLimits = turnguard.NewRateLimits(*stunRateLimit, *stunBanDuration)
+	var err error
+	turnPeerACL, err = peeracl.New(*turnAllowPeers, *turnDenyPeers)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid TURN peer ACL: %v\n", err)
+		os.Exit(1)
+	}
+	if turnConfig.Secret == "" {
+		fmt.Println("turn-secret is empty, clients get STUN servers only")
+	}
+	movieCatalog, err = moviecatalog.Load(*catalogPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	ctx := context.Background()
 	if err := run(ctx); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
@@ -84,6 +137,9 @@ func run(ctx context.Context) error {
 	registerReesourceServerWebsocketHandler(http.DefaultServeMux)
 	fmt.Println("register http server")
 	registerClientWebsocketHandler(http.DefaultServeMux)
+	registerTokenHandler(http.DefaultServeMux)
+	registerICEServersHandler(http.DefaultServeMux)
+	registerMoviesHandler(http.DefaultServeMux)
 	fmt.Println("add cors")
 	handler := cors.AllowAll().Handler(http.DefaultServeMux)
 
@@ -118,40 +174,24 @@ func runHTTPServer(ctx context.Context, handler http.Handler) error {
 	return nil
 }
 
-type WaitingResponse struct {
-	waitings map[int32]chan ResourceServerResponse
-	mu       sync.Mutex
-}
-
-var waitngResponse = WaitingResponse{
-	waitings: make(map[int32]chan ResourceServerResponse),
-}
-
-var addWaiter = func(requestId int32, ch chan ResourceServerResponse) {
-	waitngResponse.mu.Lock()
-	defer waitngResponse.mu.Unlock()
-	waitngResponse.waitings[requestId] = ch
-}
-
-var consumeWaiter = func(requestId int32) (chan ResourceServerResponse, error) {
-	waitngResponse.mu.Lock()
-	defer waitngResponse.mu.Unlock()
-	ch, ok := waitngResponse.waitings[requestId]
-	if !ok {
-		return nil, fmt.Errorf("no waiter found for requestId: %d", requestId)
+func envOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
 	}
-	return ch, nil
+	return defaultValue
 }
 
 var websocketUpgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+	CheckOrigin: checkOrigin,
 }
 
 func registerReesourceServerWebsocketHandler(serverMux *http.ServeMux) {
 	serverMux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("websocket connected")
+		claims, ok := authorizeRequest(w, r, authtoken.RoleResourceServer, authtoken.RoleOperator)
+		if !ok {
+			return
+		}
+		fmt.Printf("resource server websocket connected: %s\n", claims.Subject)
 		c, err := websocketUpgrader.Upgrade(w, r, nil)
 		if err != nil {
 			fmt.Println("Error upgrading websocket: ", err)
@@ -159,22 +199,34 @@ func registerReesourceServerWebsocketHandler(serverMux *http.ServeMux) {
 		}
 		defer c.Close()
 
+		server, err := readResourceServerRegistration(c)
+		if err != nil {
+			fmt.Println("Error registering resource server: ", err)
+			return
+		}
+		defer failServerPendingRequests(server.ID, fmt.Errorf("resource server %d disconnected", server.ID))
+		defer unregisterResourceServer(server)
+
 		go func() {
 			for {
-				fromClient := <-toResourceServer
-				fmt.Println("send message to resource server")
+				var fromClient ResourceServerRequest
+				select {
+				case fromClient = <-server.requests:
+				case <-server.done:
+					return
+				}
 
 				jsonMsg, err := json.Marshal(fromClient.Request)
 				if err != nil {
 					fmt.Println("Error marshaling clientSessionDescription: ", err)
+					failPendingRequest(fromClient.Request.ID, err)
 					continue
 				}
-				fmt.Printf("Sending message to resource server: %v\n", fromClient.Request.Type)
+				fmt.Printf("Sending message to resource server %d: %v\n", server.ID, fromClient.Request.Type)
 				if err := c.WriteMessage(websocket.TextMessage, jsonMsg); err != nil {
 					fmt.Println("Error emitting clientSessionDescription: ", err)
+					failPendingRequest(fromClient.Request.ID, fmt.Errorf("failed to send to resource server: %w", err))
 				}
-
-				addWaiter(fromClient.Request.ClientID, fromClient.ResponseChan)
 			}
 		}()
 
@@ -191,23 +243,85 @@ func registerReesourceServerWebsocketHandler(serverMux *http.ServeMux) {
 				continue
 			}
 
-			fmt.Printf("Received message from resource server: %v\n", wsMessage.Type)
-			responseSocket, err := consumeWaiter(wsMessage.ClientID)
-			if err != nil {
-				fmt.Println("Error consuming waiter: ", err)
-				return
-			}
-
-			responseSocket <- ResourceServerResponse{
-				Response: wsMessage,
-				Error:    nil,
+			fmt.Printf("Received %s message from resource server: %v\n", wsMessage.Kind, wsMessage.Type)
+			if err := routeResourceServerMessage(wsMessage); err != nil {
+				fmt.Println("Error routing message: ", err)
 			}
 		}
 	})
 }
 
+func routeResourceServerMessage(wsMessage ResourceServerWebSocketMessage) error {
+	switch wsMessage.Kind {
+	case messageKindResponse:
+		request, err := resolvePendingRequest(wsMessage.ID)
+		if err != nil {
+			// 이미 타임아웃 된 요청의 늦은 응답
+			return fmt.Errorf("failed to resolve response: %w", err)
+		}
+
+		var responseErr error
+		if wsMessage.Type == "error" {
+			responseErr = fmt.Errorf("resource server returned error for request %d", wsMessage.ID)
+		}
+		request.Session.deliver(ResourceServerResponse{
+			Response: wsMessage,
+			Error:    responseErr,
+		})
+		return nil
+
+	case messageKindPush:
+		session, err := getClientSession(wsMessage.ClientID)
+		if err != nil {
+			return fmt.Errorf("failed to route push: %w", err)
+		}
+		session.deliver(ResourceServerResponse{Response: wsMessage})
+		return nil
+	}
+
+	return fmt.Errorf("unknown message kind: %s", wsMessage.Kind)
+}
+
+func readResourceServerRegistration(c *websocket.Conn) (*ResourceServer, error) {
+	_, message, err := c.ReadMessage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registration: %w", err)
+	}
+
+	wsMessage, err := parseResourceServerWebSocketMessage(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse registration: %w", err)
+	}
+
+	registration, err := parseResourceServerRegistration(wsMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	return registerResourceServer(registration), nil
+}
+
 func registerClientWebsocketHandler(serverMux *http.ServeMux) {
 	serverMux.HandleFunc("/client/ws", func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := authorizeRequest(w, r, authtoken.RoleTablet, authtoken.RoleOperator)
+		if !ok {
+			return
+		}
+		iceServers, err := turnConfig.ICEServers(claims.Subject)
+		if err != nil {
+			fmt.Println("Error creating ICE servers: ", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		session, connection, resumed, sessionErr := openClientSession(r)
+		if sessionErr != nil {
+			fmt.Println("Error opening client session: ", sessionErr)
+			http.Error(w, sessionErr.Error(), sessionErr.status)
+			return
+		}
+		defer session.detach(connection, sessionGraceWindow)
+
 		c, err := websocketUpgrader.Upgrade(w, r, nil)
 		if err != nil {
 			fmt.Println("Error upgrading websocket: ", err)
@@ -215,10 +329,26 @@ func registerClientWebsocketHandler(serverMux *http.ServeMux) {
 		}
 		defer c.Close()
 
-		clinetId := rand.Int31()
-		response := make(chan ResourceServerResponse)
+		// 다시 연결할 때 쓸 토큰과 ICE 서버 목록을 먼저 알려준다.
+		sessionMessage, err := session.createSessionMessage(resumed, iceServers)
+		if err != nil {
+			fmt.Println("Error creating session message: ", err)
+			return
+		}
+		sessionStr, err := json.Marshal(sessionMessage)
+		if err != nil {
+			fmt.Println("Error marshaling session message: ", err)
+			return
+		}
+		if err := c.WriteMessage(websocket.TextMessage, sessionStr); err != nil {
+			fmt.Println("Error emitting session message: ", err)
+			return
+		}
+
+		clientClosed := make(chan struct{})
 
 		go func() {
+			defer close(clientClosed)
 			for {
 				_, message, err := c.ReadMessage()
 				if err != nil {
@@ -233,23 +363,24 @@ func registerClientWebsocketHandler(serverMux *http.ServeMux) {
 				}
 
 				fmt.Printf("Received message from client: %v\n", wsMessage.Type)
-
-				toResourceServer <- ResourceServerRequest{
-					Request: ResourceServerWebSocketMessage{
-						ClientID: clinetId,
-						Type:     wsMessage.Type,
-						Data:     wsMessage.Data,
-					},
-					ResponseChan: response,
-				}
+				sendClientMessage(session, wsMessage)
 			}
 		}()
 
 		for {
-			responseData := <-response
+			var responseData ResourceServerResponse
+			select {
+			case responseData = <-connection.responses:
+			case <-clientClosed:
+				fmt.Printf("client %d connection closed\n", session.ID)
+				return
+			case <-connection.overflowed:
+				// 메시지를 잃었으므로 끊고 세션을 이어서 다시 받게 한다.
+				fmt.Printf("client %d fell behind, closing connection\n", session.ID)
+				return
+			}
 			if responseData.Error != nil {
-				fmt.Println("Error processing request: ", err)
-				continue
+				fmt.Println("Error processing request: ", responseData.Error)
 			}
 
 			responseStr, err := json.Marshal(responseData.Response)
@@ -258,13 +389,86 @@ func registerClientWebsocketHandler(serverMux *http.ServeMux) {
 				continue
 			}
 			fmt.Printf("Sending response to client: %v\n", responseData.Response.Type)
+			c.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
 			if err := c.WriteMessage(websocket.TextMessage, responseStr); err != nil {
 				fmt.Println("Error emitting response: ", err)
+				return
 			}
 		}
 	})
 }
 
+type SessionError struct {
+	err    error
+	status int
+}
+
+func (e *SessionError) Error() string {
+	return e.err.Error()
+}
+
+// openClientSession은 sessionToken 이 있으면 같은 movieId 의 기존 세션을 이어 붙이고,
+// 없거나 이어 붙일 수 없으면 movieId 에 맞는 리소스 서버를 골라 새 세션을 만든다.
+// 클라이언트는 session 메시지의 resumed 로 어느 쪽인지 안다.
+func openClientSession(r *http.Request) (*ClientSession, *ClientConnection, bool, *SessionError) {
+	movieID := r.URL.Query().Get("movieId")
+	if token := r.URL.Query().Get("sessionToken"); token != "" {
+		session, connection, err := resumeClientSession(token, movieID)
+		if err == nil {
+			fmt.Printf("client %d resumed session on resource server %d\n", session.ID, session.Server.ID)
+			return session, connection, true, nil
+		}
+		fmt.Printf("failed to resume session, starting a new one: %v\n", err)
+	}
+
+	if movieID == "" {
+		return nil, nil, false, &SessionError{err: fmt.Errorf("movieId query parameter is required"), status: http.StatusBadRequest}
+	}
+	if _, ok := movieCatalog.Movie(movieID); !ok {
+		return nil, nil, false, &SessionError{err: fmt.Errorf("unknown movie: %s", movieID), status: http.StatusNotFound}
+	}
+
+	session, err := addClientSession(movieID)
+	if errors.Is(err, errNoResourceServer) {
+		return nil, nil, false, &SessionError{err: err, status: http.StatusServiceUnavailable}
+	}
+	if err != nil {
+		return nil, nil, false, &SessionError{err: err, status: http.StatusInternalServerError}
+	}
+
+	return session, session.attach(), false, nil
+}
+
+// sendClientMessage는 응답이 필요한 메시지는 request 로, 나머지는 push 로 리소스 서버에 보낸다.
+func sendClientMessage(session *ClientSession, wsMessage ClientWebSocketMessage) {
+	request := ResourceServerWebSocketMessage{
+		Kind:     messageKindPush,
+		ClientID: session.ID,
+		MovieID:  session.MovieID,
+		Type:     wsMessage.Type,
+		Data:     wsMessage.Data,
+	}
+	if requestTypes[wsMessage.Type] {
+		request.Kind = messageKindRequest
+		request.ID = addPendingRequest(session, session.Server.ID, wsMessage.Type, requestTimeout)
+	}
+
+	if err := session.Server.send(ResourceServerRequest{
+		Request: request,
+		Session: session,
+	}); err != nil {
+		fmt.Println("Error sending to resource server: ", err)
+		if request.Kind == messageKindRequest {
+			failPendingRequest(request.ID, err)
+			return
+		}
+		session.deliver(ResourceServerResponse{
+			Response: createErrorMessage(0, session.ID, wsMessage.Type, err),
+			Error:    err,
+		})
+	}
+}
+
 func runStunServer(ctx context.Context) {
 	port := 3478
 
@@ -274,10 +478,16 @@ func runStunServer(ctx context.Context) {
 		return
 	}
 
+	limiter := turnguard.NewRateLimiter(stunRateLimits)
+	defer limiter.Close()
+
 	s, err := turn.NewServer(turn.ServerConfig{
+		Realm:       turnRealm,
+		AuthHandler: turnAuthHandler(),
 		PacketConnConfigs: []turn.PacketConnConfig{
 			{
-				PacketConn: udpListener,
+				PacketConn:        limiter.Wrap(udpListener),
+				PermissionHandler: turnPeerACL.PermissionHandler(),
 			},
 		},
 	})
@@ -296,6 +506,17 @@ func runStunServer(ctx context.Context) {
 	}
 }
 
+// turnAuthHandler는 시그널링 서버가 발급한 임시 계정만 받는다.
+func turnAuthHandler() turn.AuthHandler {
+	if turnConfig.Secret == "" {
+		return func(username string, realm string, srcAddr net.Addr) ([]byte, bool) {
+			fmt.Printf("rejected TURN auth from %v: turn-secret is not configured\n", srcAddr)
+			return nil, false
+		}
+	}
+	return turn.LongTermTURNRESTAuthHandler(turnConfig.Secret, nil)
+}
+
 // https://grafana.com/blog/2024/02/09/how-i-write-http-services-in-go-after-13-years/
 func encode[T any](w http.ResponseWriter, r *http.Request, status int, v T) error {
 	w.Header().Set("Content-Type", "application/json")
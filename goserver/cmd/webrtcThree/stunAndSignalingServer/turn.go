@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"server.firehunter.juhyung.dev/internal/authtoken"
+	"server.firehunter.juhyung.dev/internal/peeracl"
+	"server.firehunter.juhyung.dev/internal/turncred"
+	"server.firehunter.juhyung.dev/internal/turnguard"
+)
+
+var (
+	turnConfig turncred.Config
+	turnRealm  string
+	// 내부망으로 relay 하지 않도록 peer 주소를 제한한다.
+	turnPeerACL *peeracl.ACL
+	// 공개된 UDP 서비스라서 출발지 IP 별로 요청 수를 제한한다.
+	stunRateLimits turnguard.RateLimits
+)
+
+type ICEServersResponse struct {
+	ICEServers []turncred.ICEServer `json:"iceServers"`
+}
+
+// 리소스 서버처럼 /client/ws 를 쓰지 않는 쪽도 임시 TURN 계정을 받을 수 있게 한다.
+func registerICEServersHandler(serverMux *http.ServeMux) {
+	serverMux.HandleFunc("GET /api/ice-servers", func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := authorizeRequest(w, r, authtoken.RoleResourceServer, authtoken.RoleTablet, authtoken.RoleOperator)
+		if !ok {
+			return
+		}
+
+		iceServers, err := turnConfig.ICEServers(claims.Subject)
+		if err != nil {
+			fmt.Println("Error creating ICE servers: ", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := encode(w, r, http.StatusOK, ICEServersResponse{ICEServers: iceServers}); err != nil {
+			fmt.Println("Error encoding ICE servers: ", err)
+		}
+	})
+}
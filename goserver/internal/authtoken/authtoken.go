@@ -0,0 +1,142 @@
+// Package authtoken은 시그널링 서버에 연결할 때 쓰는 HS256 JWT 를 만들고 검증한다.
+package authtoken
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+type Role string
+
+const (
+	RoleResourceServer Role = "resource"
+	RoleTablet         Role = "tablet"
+	RoleOperator       Role = "operator"
+)
+
+var (
+	ErrMissing          = errors.New("missing token")
+	ErrMalformed        = errors.New("malformed token")
+	ErrInvalidSignature = errors.New("invalid token signature")
+	ErrExpired          = errors.New("token expired")
+	ErrForbiddenRole    = errors.New("role not allowed")
+)
+
+type Claims struct {
+	Subject   string `json:"sub"`
+	Role      Role   `json:"role"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func ParseRole(role string) (Role, error) {
+	switch Role(role) {
+	case RoleResourceServer, RoleTablet, RoleOperator:
+		return Role(role), nil
+	}
+	return "", fmt.Errorf("unknown role: %s", role)
+}
+
+// NewClaims는 지금부터 ttl 동안 유효한 claims 를 만든다.
+func NewClaims(subject string, role Role, ttl time.Duration) Claims {
+	now := time.Now()
+	return Claims{
+		Subject:   subject,
+		Role:      role,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+}
+
+var encoding = base64.RawURLEncoding
+
+// header 는 항상 같으므로 미리 만들어 둔다.
+var header = encoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+func Sign(secret []byte, claims Claims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal claims: %w", err)
+	}
+
+	signingInput := header + "." + encoding.EncodeToString(payload)
+	return signingInput + "." + encoding.EncodeToString(sign(secret, signingInput)), nil
+}
+
+func Verify(secret []byte, token string, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformed
+	}
+	if parts[0] != header {
+		return Claims{}, fmt.Errorf("%w: unsupported header", ErrMalformed)
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if !hmac.Equal(signature, sign(secret, parts[0]+"."+parts[1])) {
+		return Claims{}, ErrInvalidSignature
+	}
+
+	payload, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	if now.Unix() >= claims.ExpiresAt {
+		return Claims{}, ErrExpired
+	}
+	if _, err := ParseRole(string(claims.Role)); err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	return claims, nil
+}
+
+// FromRequest는 Authorization 헤더나 token 쿼리에서 토큰을 꺼낸다.
+// 브라우저 WebSocket 은 헤더를 넣을 수 없어서 쿼리도 받는다.
+func FromRequest(r *http.Request) string {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return bearer
+	}
+	return r.URL.Query().Get("token")
+}
+
+// Authorize는 요청의 토큰을 검증하고 roles 중 하나인지 확인한다.
+func Authorize(secret []byte, r *http.Request, roles ...Role) (Claims, error) {
+	token := FromRequest(r)
+	if token == "" {
+		return Claims{}, ErrMissing
+	}
+
+	claims, err := Verify(secret, token, time.Now())
+	if err != nil {
+		return Claims{}, err
+	}
+
+	if !slices.Contains(roles, claims.Role) {
+		return Claims{}, fmt.Errorf("%w: %s", ErrForbiddenRole, claims.Role)
+	}
+
+	return claims, nil
+}
+
+func sign(secret []byte, signingInput string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,156 @@
+package authtoken
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testSecret = []byte("test-secret")
+	testNow    = time.Unix(1_700_000_000, 0)
+)
+
+func testClaims(role Role) Claims {
+	return Claims{Subject: "tablet-1", Role: role, IssuedAt: testNow.Unix(), ExpiresAt: testNow.Add(time.Hour).Unix()}
+}
+
+func mustSign(t *testing.T, secret []byte, claims Claims) string {
+	t.Helper()
+	token, err := Sign(secret, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// withPayload는 서명은 그대로 두고 payload 만 바꾼다.
+func withPayload(t *testing.T, token string, claims any) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	parts[1] = encoding.EncodeToString(payload)
+	return strings.Join(parts, ".")
+}
+
+// withHeader는 header 를 바꾸고 바뀐 header 로 다시 서명한다.
+func withHeader(t *testing.T, secret []byte, token string, header string) string {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	signingInput := encoding.EncodeToString([]byte(header)) + "." + parts[1]
+	return signingInput + "." + encoding.EncodeToString(sign(secret, signingInput))
+}
+
+func TestVerify(t *testing.T) {
+	valid := mustSign(t, testSecret, testClaims(RoleTablet))
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name  string
+		token string
+		now   time.Time
+		want  error
+	}{
+		{name: "valid", token: valid, now: testNow},
+		{name: "just before expiry", token: valid, now: testNow.Add(time.Hour - time.Second)},
+		{name: "at expiry", token: valid, now: testNow.Add(time.Hour), want: ErrExpired},
+		{name: "after expiry", token: valid, now: testNow.Add(2 * time.Hour), want: ErrExpired},
+		{name: "wrong key", token: mustSign(t, []byte("other-secret"), testClaims(RoleTablet)), now: testNow, want: ErrInvalidSignature},
+		{name: "empty key", token: mustSign(t, nil, testClaims(RoleTablet)), now: testNow, want: ErrInvalidSignature},
+		{name: "tampered role", token: withPayload(t, valid, testClaims(RoleOperator)), now: testNow, want: ErrInvalidSignature},
+		{name: "tampered expiry", token: withPayload(t, valid, Claims{Subject: "tablet-1", Role: RoleTablet, ExpiresAt: testNow.Add(100 * time.Hour).Unix()}), now: testNow, want: ErrInvalidSignature},
+		{name: "alg none", token: encoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + ".", now: testNow, want: ErrMalformed},
+		{name: "alg HS512 with valid mac", token: withHeader(t, testSecret, valid, `{"alg":"HS512","typ":"JWT"}`), now: testNow, want: ErrMalformed},
+		{name: "alg RS256 with valid mac", token: withHeader(t, testSecret, valid, `{"alg":"RS256","typ":"JWT"}`), now: testNow, want: ErrMalformed},
+		{name: "missing signature", token: parts[0] + "." + parts[1], now: testNow, want: ErrMalformed},
+		{name: "extra part", token: valid + ".x", now: testNow, want: ErrMalformed},
+		{name: "signature not base64", token: parts[0] + "." + parts[1] + ".!!!", now: testNow, want: ErrMalformed},
+		{name: "empty", token: "", now: testNow, want: ErrMalformed},
+		{name: "unknown role", token: mustSign(t, testSecret, testClaims("admin")), now: testNow, want: ErrMalformed},
+		{name: "payload not json", token: func() string {
+			signingInput := parts[0] + "." + encoding.EncodeToString([]byte("not json"))
+			return signingInput + "." + encoding.EncodeToString(sign(testSecret, signingInput))
+		}(), now: testNow, want: ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := Verify(testSecret, tt.token, tt.now)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Verify() error = %v", err)
+				}
+				if claims != testClaims(RoleTablet) {
+					t.Fatalf("Verify() = %+v, want %+v", claims, testClaims(RoleTablet))
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	tablet := mustSign(t, testSecret, NewClaims("tablet-1", RoleTablet, time.Hour))
+	resource := mustSign(t, testSecret, NewClaims("resource-1", RoleResourceServer, time.Hour))
+	expired := mustSign(t, testSecret, NewClaims("tablet-1", RoleTablet, -time.Second))
+
+	tests := []struct {
+		name   string
+		header string
+		query  string
+		roles  []Role
+		want   error
+	}{
+		{name: "bearer header", header: "Bearer " + tablet, roles: []Role{RoleTablet}},
+		{name: "query", query: tablet, roles: []Role{RoleTablet, RoleOperator}},
+		{name: "header wins over query", header: "Bearer " + tablet, query: "garbage", roles: []Role{RoleTablet}},
+		{name: "missing", roles: []Role{RoleTablet}, want: ErrMissing},
+		{name: "not bearer", header: "Basic " + tablet, roles: []Role{RoleTablet}, want: ErrMissing},
+		{name: "forbidden role", query: resource, roles: []Role{RoleTablet, RoleOperator}, want: ErrForbiddenRole},
+		{name: "no roles allowed", query: tablet, want: ErrForbiddenRole},
+		{name: "expired", query: expired, roles: []Role{RoleTablet}, want: ErrExpired},
+		{name: "wrong key", query: mustSign(t, []byte("other"), NewClaims("tablet-1", RoleTablet, time.Hour)), roles: []Role{RoleTablet}, want: ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ws", nil)
+			if tt.query != "" {
+				r = httptest.NewRequest("GET", "/ws?token="+tt.query, nil)
+			}
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+
+			_, err := Authorize(testSecret, r, tt.roles...)
+			if tt.want == nil && err != nil {
+				t.Fatalf("Authorize() error = %v", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("Authorize() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	for _, role := range []Role{RoleResourceServer, RoleTablet, RoleOperator} {
+		if got, err := ParseRole(string(role)); err != nil || got != role {
+			t.Errorf("ParseRole(%q) = %q, %v", role, got, err)
+		}
+	}
+	for _, role := range []string{"", "admin", "Tablet"} {
+		if _, err := ParseRole(role); err == nil {
+			t.Errorf("ParseRole(%q) succeeded", role)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Package fec은 잃어버린 RTP 패킷을 재전송 없이 되살릴 수 있도록 중복을 더해서 보내는 pion interceptor 다.
+//
+// 영상은 RFC 5109 ULPFEC 을 RFC 2198 RED 에 담아서 같은 SSRC 로 보낸다. 브라우저는 FEC 를 RED 로만 받는다.
+// 소리는 RED 에 바로 앞 packet 을 한 번 더 담는다. Opus packet 은 작아서 다시 보내도 부담이 적다.
+// FlexFEC 은 Chrome 이 기본으로 협상하지 않으므로 쓰지 않는다.
+//
+// FEC packet 도 NACK 으로 다시 보내고 transport-wide sequence number 를 받아야 하므로 NACK responder 와
+// TWCC header extension interceptor 보다 먼저 packet 을 받게 둔다. 뒤 interceptor 들은 header 를 고쳐서 넘기므로
+// media packet 을 보낸 뒤 그 header 로 보호할 packet 을 만든다. 브라우저가 받은 byte 그대로 XOR 하므로
+// 되살린 packet 도 원래 transport-wide sequence number 를 가진다. NACK 으로 다시 보낸 packet 은 새 번호를 받으므로
+// 그 packet 으로 되살린 packet 은 이 번호만 틀린다.
+package fec
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/interceptor"
+)
+
+var errInvalidOverhead = errors.New("fec overhead must be between 1 and 100 percent")
+
+// PayloadTypes는 peer 와 협상된 RED/ULPFEC payload type 이다. 0 이면 협상되지 않은 것이다.
+type PayloadTypes struct {
+	AudioRED    uint8
+	VideoRED    uint8
+	VideoULPFEC uint8
+}
+
+// Stats는 interceptor 가 지금까지 보낸 양이다.
+type Stats struct {
+	// 보호하지 않았으면 보냈을 RTP payload 크기
+	MediaBytes uint64
+	// RED header, 소리 중복, ULPFEC packet 으로 더 보낸 크기
+	RedundantBytes uint64
+	FECPackets     uint64
+}
+
+// Overhead는 보호 때문에 더 보낸 비율이다. 0.2 면 20% 더 보냈다.
+func (s Stats) Overhead() float64 {
+	if s.MediaBytes == 0 {
+		return 0
+	}
+	return float64(s.RedundantBytes) / float64(s.MediaBytes)
+}
+
+// InterceptorFactory는 peer 마다 Interceptor 를 만든다.
+type InterceptorFactory struct {
+	overhead int
+	onNew    func(id string, i *Interceptor)
+}
+
+// NewInterceptor는 영상 packet overhead% 만큼 ULPFEC packet 을 보내는 factory 를 만든다.
+// overhead 는 1 에서 100 사이다.
+func NewInterceptor(overhead int) (*InterceptorFactory, error) {
+	if overhead < 1 || overhead > 100 {
+		return nil, errInvalidOverhead
+	}
+	return &InterceptorFactory{overhead: overhead}, nil
+}
+
+// OnNewPeerConnection은 PeerConnection 마다 만든 Interceptor 를 받는다.
+// 협상이 끝나면 SetPayloadTypes 를 불러야 보호를 시작한다.
+func (f *InterceptorFactory) OnNewPeerConnection(onNew func(id string, i *Interceptor)) {
+	f.onNew = onNew
+}
+
+// NewInterceptor는 interceptor.Factory 를 구현한다.
+func (f *InterceptorFactory) NewInterceptor(id string) (interceptor.Interceptor, error) {
+	i := &Interceptor{overhead: f.overhead}
+	if f.onNew != nil {
+		f.onNew(id, i)
+	}
+	return i, nil
+}
+
+// Interceptor는 PeerConnection 하나의 local stream 들을 보호한다.
+type Interceptor struct {
+	interceptor.NoOp
+	overhead int
+
+	payloadTypes PayloadTypes
+	mu           sync.Mutex
+
+	mediaBytes     atomic.Uint64
+	redundantBytes atomic.Uint64
+	fecPackets     atomic.Uint64
+}
+
+// SetPayloadTypes는 answer 에서 협상된 payload type 을 알려준다.
+// 이미 보내기 시작한 stream 에는 적용되지 않으므로 SetLocalDescription 전에 부른다.
+func (i *Interceptor) SetPayloadTypes(payloadTypes PayloadTypes) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.payloadTypes = payloadTypes
+}
+
+// Stats는 지금까지 보낸 양이다.
+func (i *Interceptor) Stats() Stats {
+	return Stats{
+		MediaBytes:     i.mediaBytes.Load(),
+		RedundantBytes: i.redundantBytes.Load(),
+		FECPackets:     i.fecPackets.Load(),
+	}
+}
+
+// BindLocalStream은 협상된 payload type 이 있는 stream 만 RED/ULPFEC 로 바꿔 보낸다.
+func (i *Interceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	i.mu.Lock()
+	payloadTypes := i.payloadTypes
+	i.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(strings.ToLower(info.MimeType), "audio/") && payloadTypes.AudioRED != 0:
+		return &redWriter{interceptor: i, writer: writer, payloadType: payloadTypes.AudioRED}
+	case strings.HasPrefix(strings.ToLower(info.MimeType), "video/") && payloadTypes.VideoRED != 0 && payloadTypes.VideoULPFEC != 0:
+		return &ulpfecWriter{
+			interceptor:    i,
+			writer:         writer,
+			redPayloadType: payloadTypes.VideoRED,
+			fecPayloadType: payloadTypes.VideoULPFEC,
+		}
+	}
+	return writer
+}
+
+func (i *Interceptor) count(mediaBytes int, redundantBytes int) {
+	i.mediaBytes.Add(uint64(mediaBytes))
+	i.redundantBytes.Add(uint64(redundantBytes))
+}
@@ -0,0 +1,57 @@
+package fec
+
+import (
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+const (
+	// RED block header 의 timestamp offset 과 block length 는 14, 10 bit 다.
+	maxRedundantOffset = 1<<14 - 1
+	maxRedundantLength = 1<<10 - 1
+)
+
+// redWriter는 소리 packet 마다 바로 앞 packet 을 한 번 더 담는다 (RFC 2198).
+// packet 수는 그대로라서 sequence number 는 바꾸지 않는다.
+type redWriter struct {
+	interceptor *Interceptor
+	writer      interceptor.RTPWriter
+	payloadType uint8
+
+	previous          []byte
+	previousTimestamp uint32
+	mu                sync.Mutex
+}
+
+func (w *redWriter) Write(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	primaryPayloadType := header.PayloadType
+	red := make([]byte, 0, 5+len(w.previous)+len(payload))
+
+	offset := header.Timestamp - w.previousTimestamp
+	if len(w.previous) > 0 && offset <= maxRedundantOffset && len(w.previous) <= maxRedundantLength {
+		red = append(red,
+			0x80|primaryPayloadType,
+			byte(offset>>6),
+			byte(offset<<2)|byte(len(w.previous)>>8),
+			byte(len(w.previous)),
+		)
+	}
+	red = append(red, primaryPayloadType)
+	if len(red) > 1 {
+		red = append(red, w.previous...)
+	}
+	red = append(red, payload...)
+
+	w.previous = append(w.previous[:0], payload...)
+	w.previousTimestamp = header.Timestamp
+	w.interceptor.count(len(payload), len(red)-len(payload))
+
+	redHeader := *header
+	redHeader.PayloadType = w.payloadType
+	return w.writer.Write(&redHeader, red, attributes)
+}
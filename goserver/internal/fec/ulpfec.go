@@ -0,0 +1,162 @@
+package fec
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+const (
+	// ULPFEC mask 는 길어야 48 bit 라서 FEC packet 하나가 가리킬 수 있는 media packet 수도 그만큼이다.
+	maxProtectedPackets = 48
+	// mask 가 이보다 길면 L bit 를 켜고 48 bit mask 를 쓴다.
+	shortMaskPackets = 16
+
+	rtpFixedHeaderSize = 12
+	ulpfecHeaderSize   = 10
+)
+
+// ulpfecWriter는 영상 packet 을 RED 에 담아 보내고, 화면이 끝날 때마다 ULPFEC packet 을 더 보낸다 (RFC 5109).
+// 작은 화면은 FEC packet 하나 몫이 모일 때까지 다음 화면과 묶는다.
+// FEC packet 이 media packet 사이에 끼므로 sequence number 를 새로 매긴다.
+type ulpfecWriter struct {
+	interceptor    *Interceptor
+	writer         interceptor.RTPWriter
+	redPayloadType uint8
+	fecPayloadType uint8
+
+	sequenceNumber uint16
+	started        bool
+	// 아직 FEC 로 보호하지 않은 media packet. RED 를 벗긴, 브라우저가 되살릴 모양이다.
+	protected [][]byte
+	mu        sync.Mutex
+}
+
+func (w *ulpfecWriter) Write(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.started {
+		w.sequenceNumber = header.SequenceNumber
+		w.started = true
+	}
+	media := *header
+	media.SequenceNumber = w.sequenceNumber
+	w.sequenceNumber++
+
+	red := media
+	red.PayloadType = w.redPayloadType
+	n, err := w.writer.Write(&red, append([]byte{media.PayloadType}, payload...), attributes)
+	w.interceptor.count(len(payload), 1)
+
+	packet, marshalErr := protectedPacket(red, media.PayloadType, payload)
+	if marshalErr != nil {
+		// sequence number 가 끊기면 mask 가 틀리므로 다음 packet 부터 새로 묶는다.
+		w.protected = w.protected[:0]
+		return n, marshalErr
+	}
+	w.protected = append(w.protected, packet)
+
+	fecPackets := w.fecPackets()
+	if len(w.protected) == maxProtectedPackets {
+		fecPackets = max(fecPackets, 1)
+	} else if !media.Marker {
+		fecPackets = 0
+	}
+	if fecPackets > 0 {
+		w.writeFEC(&media, fecPackets, attributes)
+	}
+
+	return n, err
+}
+
+// protectedPacket은 보낸 RED packet 에서 RED header 를 벗긴 모양이다.
+// 뒤 interceptor 가 header 에 붙인 transport-wide sequence number 까지 브라우저가 받은 것과 같다.
+func protectedPacket(red rtp.Header, payloadType uint8, payload []byte) ([]byte, error) {
+	red.PayloadType = payloadType
+	return (&rtp.Packet{Header: red, Payload: payload}).Marshal()
+}
+
+// fecPackets는 모인 media packet 에 overhead 를 곱해 반올림한 FEC packet 수다.
+func (w *ulpfecWriter) fecPackets() int {
+	return (len(w.protected)*w.interceptor.overhead + 50) / 100
+}
+
+// writeFEC는 last 와 같은 SSRC, timestamp 로 FEC packet 을 보낸다. 보내지 못한 FEC packet 은 버린다.
+func (w *ulpfecWriter) writeFEC(last *rtp.Header, count int, attributes interceptor.Attributes) {
+	for _, fec := range encodeULPFEC(w.protected, count) {
+		header := rtp.Header{
+			Version:        2,
+			PayloadType:    w.redPayloadType,
+			SequenceNumber: w.sequenceNumber,
+			Timestamp:      last.Timestamp,
+			SSRC:           last.SSRC,
+		}
+		w.sequenceNumber++
+
+		payload := append([]byte{w.fecPayloadType}, fec...)
+		if _, err := w.writer.Write(&header, payload, attributes); err != nil {
+			break
+		}
+		w.interceptor.count(0, header.MarshalSize()+len(payload))
+		w.interceptor.fecPackets.Add(1)
+	}
+	w.protected = w.protected[:0]
+}
+
+// encodeULPFEC는 sequence number 가 이어진 packets 를 count 개의 level 0 ULPFEC payload 로 보호한다.
+// FEC packet j 는 j, j+count, j+2*count 번째 packet 을 묶어서 연속으로 잃어버린 packet 도 되살릴 수 있게 한다.
+func encodeULPFEC(packets [][]byte, count int) [][]byte {
+	base := binary.BigEndian.Uint16(packets[0][2:4])
+	maskBits := shortMaskPackets
+	if len(packets) > shortMaskPackets {
+		maskBits = maxProtectedPackets
+	}
+
+	fecs := make([][]byte, 0, count)
+	for j := 0; j < count; j++ {
+		// RTP header 앞 8 byte 와 header 뒤 길이, 그리고 header 뒤 전부를 XOR 한다.
+		var recovery [ulpfecHeaderSize]byte
+		var protection []byte
+		var mask uint64
+		for i := j; i < len(packets); i += count {
+			packet := packets[i]
+			mask |= 1 << (maskBits - 1 - i)
+			for b := 0; b < 8; b++ {
+				recovery[b] ^= packet[b]
+			}
+			length := len(packet) - rtpFixedHeaderSize
+			recovery[8] ^= byte(length >> 8)
+			recovery[9] ^= byte(length)
+
+			if length > len(protection) {
+				protection = append(protection, make([]byte, length-len(protection))...)
+			}
+			for b, v := range packet[rtpFixedHeaderSize:] {
+				protection[b] ^= v
+			}
+		}
+
+		fec := make([]byte, ulpfecHeaderSize, ulpfecHeaderSize+2+maskBits/8+len(protection))
+		// E bit 는 0, V 는 보호하지 않는다.
+		fec[0] = recovery[0] & 0x3f
+		if maskBits == maxProtectedPackets {
+			fec[0] |= 0x40
+		}
+		fec[1] = recovery[1]
+		binary.BigEndian.PutUint16(fec[2:4], base)
+		copy(fec[4:10], recovery[4:10])
+
+		fec = binary.BigEndian.AppendUint16(fec, uint16(len(protection)))
+		if maskBits == maxProtectedPackets {
+			fec = binary.BigEndian.AppendUint16(fec, uint16(mask>>32))
+			fec = binary.BigEndian.AppendUint32(fec, uint32(mask))
+		} else {
+			fec = binary.BigEndian.AppendUint16(fec, uint16(mask))
+		}
+		fecs = append(fecs, append(fec, protection...))
+	}
+	return fecs
+}
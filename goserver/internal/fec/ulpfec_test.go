@@ -0,0 +1,334 @@
+package fec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/twcc"
+	"github.com/pion/rtp"
+)
+
+const (
+	testSSRC          = 0x01020304
+	testTransportCCID = 5
+)
+
+func marshalPacket(t *testing.T, header rtp.Header, payload []byte) []byte {
+	t.Helper()
+	packet, err := (&rtp.Packet{Header: header, Payload: payload}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+// recoverULPFEC는 RFC 5109 10.2 대로 received 에 없는 packet 하나를 되살린다. 되살릴 packet 이 없으면 nil 이다.
+func recoverULPFEC(t *testing.T, fec []byte, received map[uint16][]byte) []byte {
+	t.Helper()
+	maskSize := 2
+	if fec[0]&0x40 != 0 {
+		maskSize = 6
+	}
+	base := binary.BigEndian.Uint16(fec[2:4])
+	protectionLength := int(binary.BigEndian.Uint16(fec[10:12]))
+	mask := fec[12 : 12+maskSize]
+	recovery := append([]byte(nil), fec[:ulpfecHeaderSize]...)
+	protection := append([]byte(nil), fec[12+maskSize:]...)
+	if len(protection) != protectionLength {
+		t.Fatalf("protection length = %d, payload has %d bytes", protectionLength, len(protection))
+	}
+
+	missing := -1
+	for i := 0; i < maskSize*8; i++ {
+		if mask[i/8]&(0x80>>(i%8)) == 0 {
+			continue
+		}
+		sequenceNumber := base + uint16(i)
+		packet, ok := received[sequenceNumber]
+		if !ok {
+			if missing >= 0 {
+				t.Fatalf("packets %d and %d are both missing", missing, sequenceNumber)
+			}
+			missing = int(sequenceNumber)
+			continue
+		}
+		for _, b := range []int{0, 1, 4, 5, 6, 7} {
+			recovery[b] ^= packet[b]
+		}
+		length := len(packet) - rtpFixedHeaderSize
+		recovery[8] ^= byte(length >> 8)
+		recovery[9] ^= byte(length)
+		for b, v := range packet[rtpFixedHeaderSize:] {
+			protection[b] ^= v
+		}
+	}
+	if missing < 0 {
+		return nil
+	}
+
+	length := int(binary.BigEndian.Uint16(recovery[8:10]))
+	packet := make([]byte, rtpFixedHeaderSize, rtpFixedHeaderSize+length)
+	packet[0] = 0x80 | recovery[0]&0x3f
+	packet[1] = recovery[1]
+	binary.BigEndian.PutUint16(packet[2:4], uint16(missing))
+	copy(packet[4:8], recovery[4:8])
+	binary.BigEndian.PutUint32(packet[8:12], testSSRC)
+	return append(packet, protection[:length]...)
+}
+
+func TestEncodeULPFECLayout(t *testing.T) {
+	twccHeader := rtp.Header{Version: 2, PayloadType: 97, SequenceNumber: 1002, Timestamp: 0x55667788, SSRC: testSSRC}
+	if err := twccHeader.SetExtension(testTransportCCID, []byte{0x12, 0x34}); err != nil {
+		t.Fatal(err)
+	}
+	packets := [][]byte{
+		marshalPacket(t, rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 1000, Timestamp: 0x11223344, SSRC: testSSRC}, []byte{1, 2, 3, 4, 5}),
+		marshalPacket(t, rtp.Header{Version: 2, Marker: true, PayloadType: 96, SequenceNumber: 1001, Timestamp: 0x11223344, SSRC: testSSRC, CSRC: []uint32{7}}, []byte{6, 7, 8}),
+		marshalPacket(t, twccHeader, []byte{9, 10, 11, 12, 13, 14, 15, 16}),
+	}
+
+	fecs := encodeULPFEC(packets, 1)
+	if len(fecs) != 1 {
+		t.Fatalf("%d FEC payloads, want 1", len(fecs))
+	}
+	fec := fecs[0]
+
+	// E, L 은 0 이고 나머지는 X=1, CC=1 을 XOR 한 값이다.
+	if fec[0] != 0x11 {
+		t.Errorf("first byte = %#x, want E=0 L=0 P=0 X=1 CC=1", fec[0])
+	}
+	// M 0^1^0, PT 96^96^97
+	if fec[1] != 0xe1 {
+		t.Errorf("M/PT recovery = %#x, want 0xe1", fec[1])
+	}
+	if got := binary.BigEndian.Uint16(fec[2:4]); got != 1000 {
+		t.Errorf("SN base = %d, want 1000", got)
+	}
+	if got := binary.BigEndian.Uint32(fec[4:8]); got != 0x55667788 {
+		t.Errorf("TS recovery = %#x, want 0x55667788", got)
+	}
+	// header 뒤 길이는 payload 5, CSRC 4 + payload 3, extension 8 + payload 8 이다.
+	if got := binary.BigEndian.Uint16(fec[8:10]); got != 5^7^16 {
+		t.Errorf("length recovery = %d, want %d", got, 5^7^16)
+	}
+	if got := binary.BigEndian.Uint16(fec[10:12]); got != 16 {
+		t.Errorf("protection length = %d, want the longest, 16", got)
+	}
+	if got := binary.BigEndian.Uint16(fec[12:14]); got != 0xe000 {
+		t.Errorf("mask = %#04x, want 0xe000", got)
+	}
+	if len(fec) != 14+16 {
+		t.Fatalf("FEC payload is %d bytes, want %d", len(fec), 14+16)
+	}
+
+	for lost := range packets {
+		received := make(map[uint16][]byte)
+		for i, packet := range packets {
+			if i != lost {
+				received[1000+uint16(i)] = packet
+			}
+		}
+		if got := recoverULPFEC(t, fec, received); !bytes.Equal(got, packets[lost]) {
+			t.Errorf("recovered packet %d = %x, want %x", lost, got, packets[lost])
+		}
+	}
+}
+
+func TestEncodeULPFECInterleave(t *testing.T) {
+	var packets [][]byte
+	for i := 0; i < 5; i++ {
+		packets = append(packets, marshalPacket(t, rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: 65534 + uint16(i), SSRC: testSSRC}, make([]byte, 10+i)))
+	}
+
+	fecs := encodeULPFEC(packets, 2)
+	if len(fecs) != 2 {
+		t.Fatalf("%d FEC payloads, want 2", len(fecs))
+	}
+	// FEC 0 은 0, 2, 4 번째, FEC 1 은 1, 3 번째 packet 을 묶는다.
+	tests := []struct {
+		mask             uint16
+		protectionLength uint16
+	}{
+		{mask: 0xa800, protectionLength: 14},
+		{mask: 0x5000, protectionLength: 13},
+	}
+	for j, tt := range tests {
+		fec := fecs[j]
+		if got := binary.BigEndian.Uint16(fec[2:4]); got != 65534 {
+			t.Errorf("FEC %d SN base = %d, want 65534", j, got)
+		}
+		if got := binary.BigEndian.Uint16(fec[12:14]); got != tt.mask {
+			t.Errorf("FEC %d mask = %#04x, want %#04x", j, got, tt.mask)
+		}
+		if got := binary.BigEndian.Uint16(fec[10:12]); got != tt.protectionLength {
+			t.Errorf("FEC %d protection length = %d, want %d", j, got, tt.protectionLength)
+		}
+	}
+
+	// 이어서 잃어버린 두 packet 도 서로 다른 FEC 로 되살린다.
+	received := map[uint16][]byte{65534: packets[0], 1: packets[3], 2: packets[4]}
+	if got := recoverULPFEC(t, fecs[0], received); !bytes.Equal(got, packets[2]) {
+		t.Errorf("FEC 0 recovered %x, want %x", got, packets[2])
+	}
+	if got := recoverULPFEC(t, fecs[1], received); !bytes.Equal(got, packets[1]) {
+		t.Errorf("FEC 1 recovered %x, want %x", got, packets[1])
+	}
+}
+
+func TestEncodeULPFECLongMask(t *testing.T) {
+	tests := []struct {
+		packets int
+		mask    []byte
+	}{
+		{packets: 16, mask: []byte{0xff, 0xff}},
+		{packets: 17, mask: []byte{0xff, 0xff, 0x80, 0, 0, 0}},
+		{packets: maxProtectedPackets, mask: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		var packets [][]byte
+		for i := 0; i < tt.packets; i++ {
+			packets = append(packets, marshalPacket(t, rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: uint16(i), SSRC: testSSRC}, []byte{byte(i)}))
+		}
+		fec := encodeULPFEC(packets, 1)[0]
+		long := len(tt.mask) == 6
+		if got := fec[0]&0x40 != 0; got != long {
+			t.Errorf("%d packets: L bit = %v, want %v", tt.packets, got, long)
+		}
+		if got := fec[12 : 12+len(tt.mask)]; !bytes.Equal(got, tt.mask) {
+			t.Errorf("%d packets: mask = %x, want %x", tt.packets, got, tt.mask)
+		}
+		if len(fec) != 12+len(tt.mask)+1 {
+			t.Errorf("%d packets: FEC payload is %d bytes, want %d", tt.packets, len(fec), 12+len(tt.mask)+1)
+		}
+	}
+}
+
+// sentPacket은 RED 를 벗긴 packet 이다. FEC packet 이면 fec 에 ULPFEC payload 가 있다.
+type sentPacket struct {
+	header rtp.Header
+	raw    []byte
+	fec    []byte
+}
+
+// newTestULPFECWriter는 pion TWCC header extension interceptor 앞에 ulpfecWriter 를 둔다.
+func newTestULPFECWriter(t *testing.T, overhead int) (interceptor.RTPWriter, *Interceptor, *[]sentPacket) {
+	t.Helper()
+	var sent []sentPacket
+	recorder := interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		if header.PayloadType != 114 {
+			t.Fatalf("payload type = %d, want RED", header.PayloadType)
+		}
+		unwrapped := header.Clone()
+		unwrapped.PayloadType = payload[0]
+		packet := sentPacket{header: unwrapped, raw: marshalPacket(t, unwrapped, payload[1:])}
+		if payload[0] == 115 {
+			packet.fec = payload[1:]
+		}
+		sent = append(sent, packet)
+		return header.MarshalSize() + len(payload), nil
+	})
+
+	factory, err := twcc.NewHeaderExtensionInterceptor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	transportCC, err := factory.NewInterceptor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &interceptor.StreamInfo{
+		SSRC:                testSSRC,
+		MimeType:            "video/H264",
+		RTPHeaderExtensions: []interceptor.RTPHeaderExtension{{URI: "http://www.ietf.org/id/draft-holmer-rmcat-transport-wide-cc-extensions-01", ID: testTransportCCID}},
+	}
+
+	i := &Interceptor{overhead: overhead}
+	i.SetPayloadTypes(PayloadTypes{VideoRED: 114, VideoULPFEC: 115})
+	return i.BindLocalStream(info, transportCC.BindLocalStream(info, recorder)), i, &sent
+}
+
+func transportSequence(t *testing.T, header rtp.Header) uint16 {
+	t.Helper()
+	var extension rtp.TransportCCExtension
+	if err := extension.Unmarshal(header.GetExtension(testTransportCCID)); err != nil {
+		t.Fatalf("packet %d has no transport-wide sequence number: %v", header.SequenceNumber, err)
+	}
+	return extension.TransportSequence
+}
+
+func TestULPFECWriterRecoversTransportSequence(t *testing.T) {
+	writer, i, sent := newTestULPFECWriter(t, 20)
+	for n := 0; n < 5; n++ {
+		header := &rtp.Header{Version: 2, Marker: n == 4, PayloadType: 96, SequenceNumber: 7000 + uint16(n), Timestamp: 9000, SSRC: testSSRC}
+		if _, err := writer.Write(header, bytes.Repeat([]byte{byte(n)}, 20+n), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 화면이 끝나면 5 packet 의 20% 인 FEC packet 하나를 보낸다.
+	if len(*sent) != 6 || (*sent)[5].fec == nil {
+		t.Fatalf("sent %d packets, want 5 media packets and a FEC packet", len(*sent))
+	}
+	for n, packet := range *sent {
+		if packet.header.SequenceNumber != 7000+uint16(n) {
+			t.Errorf("packet %d sequence number = %d, want %d", n, packet.header.SequenceNumber, 7000+n)
+		}
+		// FEC packet 도 TWCC feedback 에 들어가도록 번호를 받는다.
+		if got := transportSequence(t, packet.header); got != uint16(n) {
+			t.Errorf("packet %d transport-wide sequence number = %d, want %d", n, got, n)
+		}
+	}
+	if got := i.Stats().FECPackets; got != 1 {
+		t.Errorf("FEC packets = %d, want 1", got)
+	}
+
+	// 보호한 packet 은 TWCC 번호까지 브라우저가 받은 것과 같아서 어느 것을 잃어도 그대로 되살아난다.
+	fec := (*sent)[5].fec
+	for lost := 0; lost < 5; lost++ {
+		received := make(map[uint16][]byte)
+		for n, packet := range (*sent)[:5] {
+			if n != lost {
+				received[packet.header.SequenceNumber] = packet.raw
+			}
+		}
+		got := recoverULPFEC(t, fec, received)
+		if !bytes.Equal(got, (*sent)[lost].raw) {
+			t.Fatalf("recovered packet %d = %x, want %x", lost, got, (*sent)[lost].raw)
+		}
+		var recovered rtp.Packet
+		if err := recovered.Unmarshal(got); err != nil {
+			t.Fatal(err)
+		}
+		if seq := transportSequence(t, recovered.Header); seq != uint16(lost) {
+			t.Errorf("recovered packet %d transport-wide sequence number = %d, want %d", lost, seq, lost)
+		}
+	}
+}
+
+func TestULPFECWriterGroupsSmallFrames(t *testing.T) {
+	writer, _, sent := newTestULPFECWriter(t, 20)
+	// 한 packet 짜리 화면은 20% 가 반올림해서 0 이라 FEC packet 하나 몫인 3 packet 이 모일 때까지 묶는다.
+	for n := 0; n < 3; n++ {
+		header := &rtp.Header{Version: 2, Marker: true, PayloadType: 96, SequenceNumber: uint16(n), Timestamp: uint32(n) * 3000, SSRC: testSSRC}
+		if _, err := writer.Write(header, []byte{byte(n)}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var fecs []sentPacket
+	for _, packet := range *sent {
+		if packet.fec != nil {
+			fecs = append(fecs, packet)
+		}
+	}
+	if len(*sent) != 4 || len(fecs) != 1 {
+		t.Fatalf("sent %d packets with %d FEC packets, want 3 media packets and a FEC packet", len(*sent), len(fecs))
+	}
+	if got := binary.BigEndian.Uint16(fecs[0].fec[12:14]); got != 0xe000 {
+		t.Fatalf("mask = %#04x, want the three frames", got)
+	}
+	if fecs[0].header.Timestamp != 6000 {
+		t.Fatalf("FEC timestamp = %d, want the last frame's", fecs[0].header.Timestamp)
+	}
+}
@@ -0,0 +1,207 @@
+// Package moviecatalog은 resource/movies.json 에 적힌 영화 목록이다.
+//
+// 영화 ID, 제목, 파일 종류별 경로(rendition), 코덱, 길이, fps, 360 투영 방식을 한 곳에 적어 두고
+// 리소스 서버, HLS/파일 서버, 프론트엔드가 모두 이 목록을 쓴다. 프론트엔드는 GET /api/movies 로 받는다.
+// rendition 경로는 catalog 파일이 있는 디렉터리 기준이다. 파일 서버는 이 디렉터리를 /videos/ 로 연다.
+package moviecatalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// DefaultPath는 goserver-root 에서 실행할 때의 catalog 위치다.
+const DefaultPath = "./resource/movies.json"
+
+const (
+	ProjectionEquirectangular = "equirectangular"
+	ProjectionFlat            = "flat"
+)
+
+// Renditions는 같은 영화를 담은 파일들이다. 없는 종류는 비워 둔다.
+type Renditions struct {
+	// VP8/VP9/AV1 IVF. 코덱은 파일 헤더의 FourCC 를 따른다.
+	IVF string `json:"ivf,omitempty"`
+	// Annex B H.264
+	H264 string `json:"h264,omitempty"`
+	// HLS playlist (.m3u8)
+	HLS string `json:"hls,omitempty"`
+	MP4 string `json:"mp4,omitempty"`
+	// Ogg Opus 소리. 없으면 MP4 의 Opus track 을 쓴다.
+	Audio string `json:"audio,omitempty"`
+	// 같은 영상을 화질별로 미리 인코딩한 파일들. 화질이 높은 것부터 적는다.
+	// bitrate 나 height 가 거꾸로 적혀 있으면 Load 가 거절한다.
+	Simulcast []Layer `json:"simulcast,omitempty"`
+}
+
+// Layer는 simulcast 의 한 화질이다.
+// 리소스 서버가 keyframe 에서 peer 마다 layer 를 바꾸므로 모든 layer 는 같은 코덱, 같은 화면 수로 만들고
+// keyframe 위치를 맞춰야 한다. (예: ffmpeg 의 -force_key_frames 나 같은 -g, -sc_threshold 0)
+type Layer struct {
+	Name   string `json:"name"`
+	Height int    `json:"height,omitempty"`
+	// 평균 bitrate (bps)
+	Bitrate int `json:"bitrate,omitempty"`
+	// H.264 Annex B, IVF, MP4 중 하나
+	File string `json:"file"`
+}
+
+type Movie struct {
+	ID          string     `json:"id"`
+	Title       string     `json:"title"`
+	Codec       string     `json:"codec"`
+	DurationSec float64    `json:"durationSec"`
+	FPS         float64    `json:"fps"`
+	Projection  string     `json:"projection"`
+	Renditions  Renditions `json:"renditions"`
+}
+
+type Catalog struct {
+	Movies []Movie `json:"movies"`
+
+	dir string
+}
+
+func Load(path string) (*Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read movie catalog: %w", err)
+	}
+
+	var catalog Catalog
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, fmt.Errorf("failed to parse movie catalog %s: %w", path, err)
+	}
+	if err := catalog.validate(); err != nil {
+		return nil, fmt.Errorf("invalid movie catalog %s: %w", path, err)
+	}
+	catalog.dir = filepath.Dir(path)
+	return &catalog, nil
+}
+
+func (c *Catalog) validate() error {
+	if len(c.Movies) == 0 {
+		return errors.New("no movies")
+	}
+	seen := make(map[string]bool)
+	for _, movie := range c.Movies {
+		if movie.ID == "" {
+			return errors.New("movie without id")
+		}
+		if seen[movie.ID] {
+			return fmt.Errorf("duplicate movie id %q", movie.ID)
+		}
+		seen[movie.ID] = true
+
+		switch movie.Projection {
+		case ProjectionEquirectangular, ProjectionFlat:
+		default:
+			return fmt.Errorf("movie %s: unknown projection %q", movie.ID, movie.Projection)
+		}
+
+		layers := make(map[string]bool)
+		for i, layer := range movie.Renditions.Simulcast {
+			if layer.Name == "" || layer.File == "" {
+				return fmt.Errorf("movie %s: simulcast layer needs name and file", movie.ID)
+			}
+			if layers[layer.Name] {
+				return fmt.Errorf("movie %s: duplicate simulcast layer %q", movie.ID, layer.Name)
+			}
+			layers[layer.Name] = true
+
+			// 리소스 서버는 앞에 적힌 layer 를 높은 화질로 보고 고른다.
+			if i == 0 {
+				continue
+			}
+			previous := movie.Renditions.Simulcast[i-1]
+			if layer.Bitrate > 0 && previous.Bitrate > 0 && layer.Bitrate >= previous.Bitrate {
+				return fmt.Errorf("movie %s: simulcast layer %q (%d bps) is not below %q (%d bps), list the highest bitrate first",
+					movie.ID, layer.Name, layer.Bitrate, previous.Name, previous.Bitrate)
+			}
+			if layer.Height > previous.Height && previous.Height > 0 {
+				return fmt.Errorf("movie %s: simulcast layer %q (%dp) is above %q (%dp), list the highest first",
+					movie.ID, layer.Name, layer.Height, previous.Name, previous.Height)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Catalog) Movie(id string) (Movie, bool) {
+	for _, movie := range c.Movies {
+		if movie.ID == id {
+			return movie, true
+		}
+	}
+	return Movie{}, false
+}
+
+// IDs는 catalog 에 적힌 순서대로 영화 ID 를 돌려준다.
+func (c *Catalog) IDs() []string {
+	ids := make([]string, 0, len(c.Movies))
+	for _, movie := range c.Movies {
+		ids = append(ids, movie.ID)
+	}
+	return ids
+}
+
+// File은 rendition 경로를 catalog 디렉터리 기준의 파일 경로로 바꾼다.
+// renditions 를 순서대로 보고 처음 있는 것을 쓴다. 영화가 없거나 그 rendition 들이 모두 없으면 에러다.
+func (c *Catalog) File(id string, renditions ...func(Renditions) string) (string, error) {
+	movie, ok := c.Movie(id)
+	if !ok {
+		return "", fmt.Errorf("movie %q is not in the catalog", id)
+	}
+	for _, rendition := range renditions {
+		if path := rendition(movie.Renditions); path != "" {
+			return filepath.Join(c.dir, path), nil
+		}
+	}
+	return "", fmt.Errorf("movie %q has no such rendition", id)
+}
+
+// Layers는 영화의 simulcast layer 들을 File 을 파일 경로로 바꿔서 돌려준다. 없으면 비어 있다.
+func (c *Catalog) Layers(id string) []Layer {
+	movie, ok := c.Movie(id)
+	if !ok {
+		return nil
+	}
+	layers := make([]Layer, len(movie.Renditions.Simulcast))
+	for i, layer := range movie.Renditions.Simulcast {
+		layer.File = filepath.Join(c.dir, layer.File)
+		layers[i] = layer
+	}
+	return layers
+}
+
+func IVF(r Renditions) string   { return r.IVF }
+func H264(r Renditions) string  { return r.H264 }
+func HLS(r Renditions) string   { return r.HLS }
+func MP4(r Renditions) string   { return r.MP4 }
+func Audio(r Renditions) string { return r.Audio }
+
+// RegisterHandler는 GET /api/movies 와 GET /api/movies/{id} 를 mux 에 등록한다.
+func (c *Catalog) RegisterHandler(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/movies", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, c)
+	})
+	mux.HandleFunc("GET /api/movies/{id}", func(w http.ResponseWriter, r *http.Request) {
+		movie, ok := c.Movie(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "movie not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, movie)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Println("moviecatalog: failed to encode response:", err)
+	}
+}
@@ -0,0 +1,104 @@
+package moviecatalog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeCatalog(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "movies.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// movieJSON은 simulcast layer 들만 바꾼 영화 하나짜리 catalog 다.
+func movieJSON(simulcast string) string {
+	return `{"movies":[{"id":"1","projection":"equirectangular","renditions":{"mp4":"video-1.mp4","simulcast":[` + simulcast + `]}}]}`
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "valid", content: movieJSON(`{"name":"high","height":1080,"bitrate":4000000,"file":"high.mp4"},{"name":"low","height":720,"bitrate":1000000,"file":"low.mp4"}`)},
+		{name: "no bitrates", content: movieJSON(`{"name":"high","file":"high.mp4"},{"name":"low","file":"low.mp4"}`)},
+		{name: "no movies", content: `{"movies":[]}`, wantErr: "no movies"},
+		{name: "bad json", content: `{"movies":`, wantErr: "failed to parse"},
+		{name: "movie without id", content: `{"movies":[{"projection":"flat"}]}`, wantErr: "without id"},
+		{name: "duplicate id", content: `{"movies":[{"id":"1","projection":"flat"},{"id":"1","projection":"flat"}]}`, wantErr: "duplicate movie id"},
+		{name: "unknown projection", content: `{"movies":[{"id":"1","projection":"cubemap"}]}`, wantErr: "unknown projection"},
+		{name: "layer without file", content: movieJSON(`{"name":"high"}`), wantErr: "needs name and file"},
+		{name: "duplicate layer", content: movieJSON(`{"name":"high","file":"a.mp4"},{"name":"high","file":"b.mp4"}`), wantErr: "duplicate simulcast layer"},
+		{
+			name:    "ascending bitrate",
+			content: movieJSON(`{"name":"low","bitrate":1000000,"file":"low.mp4"},{"name":"high","bitrate":4000000,"file":"high.mp4"}`),
+			wantErr: "highest bitrate first",
+		},
+		{
+			name:    "equal bitrate",
+			content: movieJSON(`{"name":"a","bitrate":1000000,"file":"a.mp4"},{"name":"b","bitrate":1000000,"file":"b.mp4"}`),
+			wantErr: "highest bitrate first",
+		},
+		{
+			name:    "ascending height",
+			content: movieJSON(`{"name":"low","height":720,"file":"low.mp4"},{"name":"high","height":1080,"file":"high.mp4"}`),
+			wantErr: "highest first",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeCatalog(t, tt.content))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Load() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "movies.json")); err == nil {
+		t.Fatal("Load() of a missing file succeeded")
+	}
+}
+
+func TestCatalogFiles(t *testing.T) {
+	path := writeCatalog(t, movieJSON(`{"name":"high","bitrate":4000000,"file":"high.mp4"},{"name":"low","bitrate":1000000,"file":"low.mp4"}`))
+	catalog, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Dir(path)
+
+	// 처음 있는 rendition 을 catalog 디렉터리 기준으로 돌려준다.
+	if got, err := catalog.File("1", H264, MP4); err != nil || got != filepath.Join(dir, "video-1.mp4") {
+		t.Fatalf("File() = %q, %v", got, err)
+	}
+	if _, err := catalog.File("1", H264); err == nil {
+		t.Fatal("File() of a missing rendition succeeded")
+	}
+	if _, err := catalog.File("2", MP4); err == nil {
+		t.Fatal("File() of a missing movie succeeded")
+	}
+
+	layers := catalog.Layers("1")
+	if len(layers) != 2 || layers[0].Name != "high" || layers[1].File != filepath.Join(dir, "low.mp4") {
+		t.Fatalf("Layers() = %+v", layers)
+	}
+	if layers := catalog.Layers("2"); layers != nil {
+		t.Fatalf("Layers() of a missing movie = %+v", layers)
+	}
+}
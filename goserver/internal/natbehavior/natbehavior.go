@@ -0,0 +1,56 @@
+// Package natbehavior는 RFC 5780 NAT behavior discovery 에 필요한 STUN 응답기와 속성이다.
+//
+// 서버는 두 IP 와 두 포트로 소켓 네 개를 열고, binding 응답에 OTHER-ADDRESS 와
+// RESPONSE-ORIGIN 을 넣는다. CHANGE-REQUEST 가 있으면 다른 IP/포트의 소켓에서 답한다.
+// 클라이언트는 이 응답들로 NAT 의 mapping 과 filtering 동작을 구분한다.
+package natbehavior
+
+import (
+	"encoding/binary"
+
+	"github.com/pion/stun/v2"
+)
+
+// ChangeRequest는 CHANGE-REQUEST 속성이다. (RFC 5780 7.2)
+type ChangeRequest struct {
+	ChangeIP   bool
+	ChangePort bool
+}
+
+const (
+	changeIPFlag   = 0x04
+	changePortFlag = 0x02
+)
+
+func (c ChangeRequest) AddTo(m *stun.Message) error {
+	var value uint32
+	if c.ChangeIP {
+		value |= changeIPFlag
+	}
+	if c.ChangePort {
+		value |= changePortFlag
+	}
+	v := make([]byte, 4)
+	binary.BigEndian.PutUint32(v, value)
+	m.Add(stun.AttrChangeRequest, v)
+	return nil
+}
+
+// GetFrom은 속성이 없으면 아무것도 바꾸지 않는 요청으로 읽는다.
+func (c *ChangeRequest) GetFrom(m *stun.Message) error {
+	v, err := m.Get(stun.AttrChangeRequest)
+	if err == stun.ErrAttributeNotFound {
+		*c = ChangeRequest{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := stun.CheckSize(stun.AttrChangeRequest, len(v), 4); err != nil {
+		return err
+	}
+	value := binary.BigEndian.Uint32(v)
+	c.ChangeIP = value&changeIPFlag != 0
+	c.ChangePort = value&changePortFlag != 0
+	return nil
+}
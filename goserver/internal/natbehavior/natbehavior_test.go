@@ -0,0 +1,57 @@
+package natbehavior
+
+import (
+	"testing"
+
+	"github.com/pion/stun/v2"
+)
+
+func TestChangeRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		request ChangeRequest
+		value   []byte
+	}{
+		{name: "none", value: []byte{0, 0, 0, 0}},
+		{name: "ip", request: ChangeRequest{ChangeIP: true}, value: []byte{0, 0, 0, 0x04}},
+		{name: "port", request: ChangeRequest{ChangePort: true}, value: []byte{0, 0, 0, 0x02}},
+		{name: "both", request: ChangeRequest{ChangeIP: true, ChangePort: true}, value: []byte{0, 0, 0, 0x06}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := stun.Build(stun.TransactionID, stun.BindingRequest, tt.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			value, err := m.Get(stun.AttrChangeRequest)
+			if err != nil || string(value) != string(tt.value) {
+				t.Fatalf("CHANGE-REQUEST = %x, %v, want %x", value, err, tt.value)
+			}
+			got := ChangeRequest{ChangeIP: true, ChangePort: true}
+			if err := got.GetFrom(m); err != nil || got != tt.request {
+				t.Fatalf("GetFrom() = %+v, %v, want %+v", got, err, tt.request)
+			}
+		})
+	}
+}
+
+func TestChangeRequestGetFrom(t *testing.T) {
+	// 속성이 없으면 아무것도 바꾸지 않는다.
+	m, err := stun.Build(stun.TransactionID, stun.BindingRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := ChangeRequest{ChangeIP: true}
+	if err := got.GetFrom(m); err != nil || got != (ChangeRequest{}) {
+		t.Fatalf("GetFrom() without the attribute = %+v, %v", got, err)
+	}
+
+	m, err = stun.Build(stun.TransactionID, stun.BindingRequest, stun.RawAttribute{Type: stun.AttrChangeRequest, Value: []byte{0, 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := got.GetFrom(m); err == nil {
+		t.Fatal("GetFrom() accepted a 2 byte CHANGE-REQUEST")
+	}
+}
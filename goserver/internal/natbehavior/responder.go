@@ -0,0 +1,179 @@
+package natbehavior
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/pion/stun/v2"
+)
+
+const software = "firehunter stunserver"
+
+// Responder는 IP 두 개 × 포트 두 개 소켓에서 binding 요청에 답한다.
+// primary 소켓은 Primary 로 감싸서 turn 서버에 넘긴다.
+type Responder struct {
+	// [IP][포트]
+	conns [2][2]net.PacketConn
+	addrs [2][2]*net.UDPAddr
+
+	closeOnce sync.Once
+}
+
+// NewResponder는 primary 와 같은 포트, 다른 IP/포트로 소켓 세 개를 더 연다.
+// primary 는 0.0.0.0 이 아니라 실제 IP 에 listen 해야 한다.
+// RESPONSE-ORIGIN 과 OTHER-ADDRESS 로 그 주소를 알려주기 때문이다.
+// wrap 이 있으면 새로 연 소켓을 primary 와 같은 방식으로 감쌀 수 있다.
+func NewResponder(primary net.PacketConn, otherIP net.IP, otherPort int, wrap func(net.PacketConn) net.PacketConn) (*Responder, error) {
+	primaryAddr, ok := primary.LocalAddr().(*net.UDPAddr)
+	if !ok || primaryAddr.IP.IsUnspecified() {
+		return nil, fmt.Errorf("primary must listen on a specific UDP address: %v", primary.LocalAddr())
+	}
+	if primaryAddr.IP.Equal(otherIP) || primaryAddr.Port == otherPort {
+		return nil, fmt.Errorf("need two different IPs and ports: %v, %s:%d", primaryAddr, otherIP, otherPort)
+	}
+
+	r := &Responder{}
+	r.conns[0][0] = primary
+	r.addrs[0][0] = primaryAddr
+	ips := [2]net.IP{primaryAddr.IP, otherIP}
+	ports := [2]int{primaryAddr.Port, otherPort}
+	for i, ip := range ips {
+		for j, port := range ports {
+			if i == 0 && j == 0 {
+				continue
+			}
+			conn, err := net.ListenPacket("udp4", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+			if err != nil {
+				r.Close()
+				return nil, fmt.Errorf("failed to listen: %w", err)
+			}
+			r.addrs[i][j] = conn.LocalAddr().(*net.UDPAddr)
+			if wrap != nil {
+				conn = wrap(conn)
+			}
+			r.conns[i][j] = conn
+		}
+	}
+
+	// Primary 는 turn 서버가 읽으므로 나머지 세 소켓만 여기서 읽는다.
+	for i := range r.conns {
+		for j := range r.conns[i] {
+			if i == 0 && j == 0 {
+				continue
+			}
+			go r.serve(i, j)
+		}
+	}
+	return r, nil
+}
+
+// Primary는 binding 요청을 가로채서 답하고 나머지는 그대로 돌려주는 PacketConn 이다.
+func (r *Responder) Primary() net.PacketConn {
+	return &primaryConn{PacketConn: r.conns[0][0], responder: r}
+}
+
+func (r *Responder) Addrs() []net.Addr {
+	return []net.Addr{r.addrs[0][0], r.addrs[0][1], r.addrs[1][0], r.addrs[1][1]}
+}
+
+// Close는 primary 를 뺀 소켓을 닫는다. primary 는 turn 서버가 닫는다.
+func (r *Responder) Close() {
+	r.closeOnce.Do(func() {
+		for i := range r.conns {
+			for j := range r.conns[i] {
+				if (i != 0 || j != 0) && r.conns[i][j] != nil {
+					r.conns[i][j].Close()
+				}
+			}
+		}
+	})
+}
+
+type primaryConn struct {
+	net.PacketConn
+	responder *Responder
+}
+
+func (c *primaryConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil {
+			return n, addr, err
+		}
+		if !c.responder.handle(0, 0, p[:n], addr) {
+			return n, addr, nil
+		}
+	}
+}
+
+func (r *Responder) serve(i, j int) {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := r.conns[i][j].ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		r.handle(i, j, buf[:n], addr)
+	}
+}
+
+// handle은 binding 요청이면 답하고 true 를 돌려준다.
+func (r *Responder) handle(i, j int, p []byte, from net.Addr) bool {
+	if !stun.IsMessage(p) {
+		return false
+	}
+	m := &stun.Message{Raw: append([]byte{}, p...)}
+	if err := m.Decode(); err != nil || m.Type != stun.BindingRequest {
+		return false
+	}
+
+	udpAddr, ok := from.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+
+	var change ChangeRequest
+	if err := change.GetFrom(m); err != nil {
+		fmt.Printf("natbehavior: invalid CHANGE-REQUEST from %v: %v\n", from, err)
+		return true
+	}
+	if change.ChangeIP {
+		i ^= 1
+	}
+	if change.ChangePort {
+		j ^= 1
+	}
+
+	origin := r.addrs[i][j]
+	other := r.addrs[i^1][j^1]
+	response, err := stun.Build(
+		stun.NewTransactionIDSetter(m.TransactionID),
+		stun.BindingSuccess,
+		&stun.XORMappedAddress{IP: udpAddr.IP, Port: udpAddr.Port},
+		addrAttr{stun.AttrResponseOrigin, origin},
+		addrAttr{stun.AttrOtherAddress, other},
+		stun.NewSoftware(software),
+		stun.Fingerprint,
+	)
+	if err != nil {
+		fmt.Printf("natbehavior: failed to build response: %v\n", err)
+		return true
+	}
+	if _, err := r.conns[i][j].WriteTo(response.Raw, from); err != nil {
+		fmt.Printf("natbehavior: failed to write response to %v: %v\n", from, err)
+	}
+	return true
+}
+
+// addrAttr는 RESPONSE-ORIGIN, OTHER-ADDRESS 처럼 MAPPED-ADDRESS 형식인 속성이다.
+type addrAttr struct {
+	attr stun.AttrType
+	addr *net.UDPAddr
+}
+
+func (a addrAttr) AddTo(m *stun.Message) error {
+	mapped := stun.MappedAddress{IP: a.addr.IP, Port: a.addr.Port}
+	return mapped.AddToAs(m, a.attr)
+}
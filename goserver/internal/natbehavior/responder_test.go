@@ -0,0 +1,222 @@
+package natbehavior
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/stun/v2"
+)
+
+var (
+	primaryIP = net.IPv4(127, 0, 0, 1)
+	otherIP   = net.IPv4(127, 0, 0, 2)
+)
+
+func listen(t *testing.T, ip net.IP) net.PacketConn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", net.JoinHostPort(ip.String(), "0"))
+	if err != nil {
+		t.Skipf("cannot listen on %v: %v", ip, err)
+	}
+	return conn
+}
+
+// freePort는 잠깐 열었다 닫은 포트다. 다른 IP 의 같은 포트도 비어 있다고 본다.
+func freePort(t *testing.T) int {
+	t.Helper()
+	conn := listen(t, otherIP)
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// startResponder는 primary 를 turn 서버 대신 읽어서 binding 요청이 아닌 패킷을 passed 로 넘긴다.
+func startResponder(t *testing.T) (*Responder, <-chan []byte) {
+	t.Helper()
+	primary := listen(t, primaryIP)
+	responder, err := NewResponder(primary, otherIP, freePort(t), nil)
+	if err != nil {
+		primary.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		responder.Close()
+		primary.Close()
+	})
+
+	passed := make(chan []byte, 4)
+	go func() {
+		conn := responder.Primary()
+		buf := make([]byte, 1500)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			passed <- append([]byte{}, buf[:n]...)
+		}
+	}()
+	return responder, passed
+}
+
+type response struct {
+	from   net.Addr
+	mapped stun.XORMappedAddress
+	origin stun.MappedAddress
+	other  stun.MappedAddress
+}
+
+// bind는 to 로 binding 요청을 보내고 응답을 읽는다. 응답이 없으면 nil 이다.
+func bind(t *testing.T, client net.PacketConn, to net.Addr, setters ...stun.Setter) *response {
+	t.Helper()
+	request, err := stun.Build(append([]stun.Setter{stun.TransactionID, stun.BindingRequest}, setters...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.WriteTo(request.Raw, to); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1500)
+	client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	n, from, err := client.ReadFrom(buf)
+	if err != nil {
+		return nil
+	}
+	m := &stun.Message{Raw: buf[:n]}
+	if err := m.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != stun.BindingSuccess || m.TransactionID != request.TransactionID {
+		t.Fatalf("response = %v", m)
+	}
+	r := &response{from: from}
+	if err := r.mapped.GetFrom(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.origin.GetFromAs(m, stun.AttrResponseOrigin); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.other.GetFromAs(m, stun.AttrOtherAddress); err != nil {
+		t.Fatal(err)
+	}
+	if err := stun.Fingerprint.Check(m); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func sameAddr(mapped stun.MappedAddress, addr net.Addr) bool {
+	udpAddr := addr.(*net.UDPAddr)
+	return mapped.IP.Equal(udpAddr.IP) && mapped.Port == udpAddr.Port
+}
+
+func TestResponderChangeRequest(t *testing.T) {
+	responder, passed := startResponder(t)
+	client := listen(t, primaryIP)
+	defer client.Close()
+	clientAddr := client.LocalAddr().(*net.UDPAddr)
+	// Addrs 는 primary, 다른 포트, 다른 IP, 다른 IP 와 포트 순이다.
+	addrs := responder.Addrs()
+
+	tests := []struct {
+		name    string
+		to      int
+		request ChangeRequest
+		from    int
+	}{
+		{name: "primary", to: 0, from: 0},
+		{name: "change port", to: 0, request: ChangeRequest{ChangePort: true}, from: 1},
+		{name: "change ip", to: 0, request: ChangeRequest{ChangeIP: true}, from: 2},
+		{name: "change both", to: 0, request: ChangeRequest{ChangeIP: true, ChangePort: true}, from: 3},
+		// 다른 소켓에 온 요청도 그 소켓 기준으로 바꾼다.
+		{name: "other address", to: 3, from: 3},
+		{name: "other address change ip", to: 3, request: ChangeRequest{ChangeIP: true}, from: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bind(t, client, addrs[tt.to], tt.request)
+			if r == nil {
+				t.Fatal("no response")
+			}
+			if r.from.String() != addrs[tt.from].String() {
+				t.Fatalf("response from %v, want %v", r.from, addrs[tt.from])
+			}
+			if !r.mapped.IP.Equal(clientAddr.IP) || r.mapped.Port != clientAddr.Port {
+				t.Fatalf("XOR-MAPPED-ADDRESS = %v, want %v", r.mapped, clientAddr)
+			}
+			// RESPONSE-ORIGIN 은 답한 소켓이고 OTHER-ADDRESS 는 IP 와 포트가 모두 다른 소켓이다.
+			if !sameAddr(r.origin, r.from) {
+				t.Fatalf("RESPONSE-ORIGIN = %v, want %v", r.origin, r.from)
+			}
+			if !sameAddr(r.other, addrs[3-tt.from]) {
+				t.Fatalf("OTHER-ADDRESS = %v, want %v", r.other, addrs[3-tt.from])
+			}
+		})
+	}
+
+	select {
+	case p := <-passed:
+		t.Fatalf("binding request was passed to the turn server: %x", p)
+	default:
+	}
+}
+
+func TestResponderPassesOtherPackets(t *testing.T) {
+	responder, passed := startResponder(t)
+	client := listen(t, primaryIP)
+	defer client.Close()
+	primary := responder.Addrs()[0]
+
+	// 깨진 CHANGE-REQUEST 에는 답하지 않고 turn 서버에도 넘기지 않는다.
+	if r := bind(t, client, primary, stun.RawAttribute{Type: stun.AttrChangeRequest, Value: []byte{0, 4}}); r != nil {
+		t.Fatalf("response to an invalid CHANGE-REQUEST from %v", r.from)
+	}
+
+	allocate, err := stun.Build(stun.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range [][]byte{allocate.Raw, {0x40, 0x00, 0x00, 0x00}} {
+		if _, err := client.WriteTo(p, primary); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-passed:
+			if string(got) != string(p) {
+				t.Fatalf("passed %x, want %x", got, p)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("packet %x was not passed to the turn server", p)
+		}
+	}
+}
+
+func TestNewResponderErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		ip       net.IP
+		otherIP  net.IP
+		samePort bool
+	}{
+		{name: "unspecified primary", ip: net.IPv4zero, otherIP: otherIP},
+		{name: "same ip", ip: primaryIP, otherIP: primaryIP},
+		{name: "same port", ip: primaryIP, otherIP: otherIP, samePort: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := listen(t, tt.ip)
+			defer primary.Close()
+			port := freePort(t)
+			if tt.samePort {
+				port = primary.LocalAddr().(*net.UDPAddr).Port
+			}
+			if responder, err := NewResponder(primary, tt.otherIP, port, nil); err == nil {
+				responder.Close()
+				t.Fatal("NewResponder() succeeded")
+			}
+		})
+	}
+}
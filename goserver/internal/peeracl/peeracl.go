@@ -0,0 +1,104 @@
+// Package peeracl은 TURN relay 가 보낼 수 있는 peer 주소를 CIDR 목록으로 제한한다.
+// pion/turn 은 기본으로 모든 주소에 relay 하므로, 막지 않으면 relay 서버가 있는
+// 내부망(사설망, loopback, link-local) 으로 들어가는 통로가 된다.
+package peeracl
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pion/turn/v3"
+)
+
+// DefaultDeny는 기본으로 막는 대역이다. 사설망, loopback, link-local, CGNAT, multicast 등.
+const DefaultDeny = "0.0.0.0/8,10.0.0.0/8,100.64.0.0/10,127.0.0.0/8,169.254.0.0/16,172.16.0.0/12,192.0.0.0/24,192.168.0.0/16,198.18.0.0/15,224.0.0.0/4,240.0.0.0/4,::/128,::1/128,fc00::/7,fe80::/10,ff00::/8"
+
+// ACL은 Self 에 있으면 막고, Allow 에 있으면 허용하고, 아니면 Deny 에 있는지 본다. 모두 아니면 허용한다.
+// Allow 는 Deny 의 예외다. (예: 행사장 내부망 10.1.0.0/16 만 허용)
+type ACL struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+	// relay 서버 자신의 주소. Allow 에 들어 있어도 막아서 relay 가 자기 자신에게 보내지 못하게 한다.
+	Self []*net.IPNet
+}
+
+// ParseCIDRs는 쉼표로 나눈 CIDR 목록을 읽는다. 빈 문자열이면 빈 목록이다.
+func ParseCIDRs(cidrs string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(cidrs, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// New는 flag 로 받은 allow, deny 목록으로 ACL 을 만든다.
+func New(allow, deny string) (*ACL, error) {
+	allowNetworks, err := ParseCIDRs(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyNetworks, err := ParseCIDRs(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &ACL{Allow: allowNetworks, Deny: denyNetworks}, nil
+}
+
+// DenySelf는 relay 서버가 쓰는 주소를 Self 에 더한다. 0.0.0.0 같은 unspecified 주소는 건너뛴다.
+func (a *ACL) DenySelf(ips ...net.IP) {
+	for _, ip := range ips {
+		if ip == nil || ip.IsUnspecified() {
+			continue
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		a.Self = append(a.Self, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+	}
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *ACL) Allowed(ip net.IP) bool {
+	// ::ffff:10.0.0.1 같은 주소도 IPv4 대역으로 검사한다.
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if contains(a.Self, ip) {
+		return false
+	}
+	if contains(a.Allow, ip) {
+		return true
+	}
+	return !contains(a.Deny, ip)
+}
+
+// PermissionHandler는 CreatePermission, ChannelBind 때 불린다. 거절하면 로그를 남긴다.
+func (a *ACL) PermissionHandler() turn.PermissionHandler {
+	return func(clientAddr net.Addr, peerIP net.IP) bool {
+		if a.Allowed(peerIP) {
+			return true
+		}
+		fmt.Printf("peeracl: rejected permission from %v to %v\n", clientAddr, peerIP)
+		return false
+	}
+}
+
+func (a *ACL) String() string {
+	return fmt.Sprintf("allow %v deny %v self %v", a.Allow, a.Deny, a.Self)
+}
@@ -0,0 +1,71 @@
+package peeracl
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow string
+		deny  string
+		self  []net.IP
+		ip    string
+		want  bool
+	}{
+		{name: "public by default", deny: DefaultDeny, ip: "8.8.8.8", want: true},
+		{name: "private by default", deny: DefaultDeny, ip: "10.1.2.3"},
+		{name: "loopback by default", deny: DefaultDeny, ip: "127.0.0.1"},
+		{name: "link-local by default", deny: DefaultDeny, ip: "169.254.169.254"},
+		{name: "cgnat by default", deny: DefaultDeny, ip: "100.64.0.1"},
+		{name: "ipv6 loopback by default", deny: DefaultDeny, ip: "::1"},
+		{name: "ipv6 unique local by default", deny: DefaultDeny, ip: "fd00::1"},
+		{name: "ipv4-mapped private", deny: DefaultDeny, ip: "::ffff:10.0.0.1"},
+		{name: "ipv4-mapped public", deny: DefaultDeny, ip: "::ffff:8.8.8.8", want: true},
+		{name: "allow is an exception of deny", allow: "10.1.0.0/16", deny: DefaultDeny, ip: "10.1.2.3", want: true},
+		{name: "allow does not widen other denied", allow: "10.1.0.0/16", deny: DefaultDeny, ip: "10.2.0.1"},
+		{name: "allow of a public ip", allow: "8.8.8.8/32", deny: DefaultDeny, ip: "8.8.8.8", want: true},
+		{name: "narrower deny inside allow loses", allow: "10.0.0.0/8", deny: "10.1.0.0/16", ip: "10.1.2.3", want: true},
+		{name: "narrower allow inside deny wins", allow: "10.1.2.0/24", deny: "10.0.0.0/8", ip: "10.1.2.3", want: true},
+		{name: "empty deny allows everything", ip: "127.0.0.1", want: true},
+		{name: "self", deny: DefaultDeny, self: []net.IP{net.ParseIP("3.34.13.104")}, ip: "3.34.13.104"},
+		{name: "self wins over allow", allow: "10.1.0.0/16", deny: DefaultDeny, self: []net.IP{net.ParseIP("10.1.0.5")}, ip: "10.1.0.5"},
+		{name: "next to self", allow: "10.1.0.0/16", deny: DefaultDeny, self: []net.IP{net.ParseIP("10.1.0.5")}, ip: "10.1.0.6", want: true},
+		{name: "ipv4-mapped self", self: []net.IP{net.ParseIP("3.34.13.104")}, ip: "::ffff:3.34.13.104"},
+		{name: "unspecified self is ignored", self: []net.IP{net.ParseIP("0.0.0.0")}, ip: "8.8.8.8", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acl, err := New(tt.allow, tt.deny)
+			if err != nil {
+				t.Fatal(err)
+			}
+			acl.DenySelf(tt.self...)
+
+			if got := acl.Allowed(net.ParseIP(tt.ip)); got != tt.want {
+				t.Fatalf("Allowed(%s) = %v, want %v (%v)", tt.ip, got, tt.want, acl)
+			}
+			if got := acl.PermissionHandler()(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}, net.ParseIP(tt.ip)); got != tt.want {
+				t.Fatalf("PermissionHandler(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	networks, err := ParseCIDRs(" 10.0.0.0/8, ,fe80::/10,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 2 || networks[0].String() != "10.0.0.0/8" || networks[1].String() != "fe80::/10" {
+		t.Fatalf("ParseCIDRs() = %v", networks)
+	}
+
+	for _, cidrs := range []string{"10.0.0.1", "10.0.0.0/33", "example.com/8"} {
+		if _, err := ParseCIDRs(cidrs); err == nil {
+			t.Errorf("ParseCIDRs(%q) succeeded", cidrs)
+		}
+	}
+}
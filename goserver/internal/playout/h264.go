@@ -0,0 +1,104 @@
+package playout
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/h264reader"
+)
+
+var annexBStartCode = []byte{0, 0, 0, 1}
+
+// LoadH264는 Annex-B H.264 파일을 화면 단위로 읽는다.
+// 화면 길이는 SPS 의 VUI timing 에서 정하고, 없으면 fallback 을 쓴다.
+func LoadH264(fileName string, fallback time.Duration) (*Clip, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open video file: %w", err)
+	}
+	defer file.Close()
+
+	h264, err := h264reader.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create H264 reader: %w", err)
+	}
+
+	frameDuration := time.Duration(0)
+	var frames []Frame
+	var current Frame
+	hasSlice := false
+	// 마지막으로 나온 SPS, PPS 와 지금 화면에 들어 있는지
+	var sps, pps []byte
+	hasParameterSets := false
+	finish := func() {
+		if current.Keyframe && !hasParameterSets && sps != nil && pps != nil {
+			current.ParameterSets = append(append([]byte{}, sps...), pps...)
+		}
+		frames = append(frames, current)
+	}
+	for {
+		nal, err := h264.NextNAL()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read NAL: %w", err)
+		}
+
+		if hasSlice && startsAccessUnit(nal) {
+			finish()
+			current = Frame{}
+			hasSlice = false
+			hasParameterSets = false
+		}
+
+		current.Data = append(current.Data, annexBStartCode...)
+		current.Data = append(current.Data, nal.Data...)
+		switch nal.UnitType {
+		case h264reader.NalUnitTypeSPS:
+			if frameDuration == 0 {
+				// 읽지 못하는 SPS 는 fallback 으로 넘어간다.
+				frameDuration, _ = spsFrameDuration(nal.Data)
+			}
+			sps = append(append([]byte{}, annexBStartCode...), nal.Data...)
+			hasParameterSets = true
+		case h264reader.NalUnitTypePPS:
+			pps = append(append([]byte{}, annexBStartCode...), nal.Data...)
+		case h264reader.NalUnitTypeCodedSliceIdr:
+			current.Keyframe = true
+			hasSlice = true
+		case h264reader.NalUnitTypeCodedSliceNonIdr, h264reader.NalUnitTypeCodedSliceDataPartitionA:
+			hasSlice = true
+		}
+	}
+	if hasSlice {
+		finish()
+	}
+
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no frames in video file: %s", fileName)
+	}
+	if frameDuration == 0 {
+		frameDuration = fallback
+	}
+	for i := range frames {
+		frames[i].Duration = frameDuration
+	}
+	return newClip(webrtc.MimeTypeH264, 0, frames), nil
+}
+
+// startsAccessUnit은 이미 slice 가 나온 뒤에 이 NAL 이 새 화면을 시작하는지 본다. (H.264 7.4.1.2.3)
+func startsAccessUnit(nal *h264reader.NAL) bool {
+	switch nal.UnitType {
+	case h264reader.NalUnitTypeAUD, h264reader.NalUnitTypeSPS, h264reader.NalUnitTypePPS, h264reader.NalUnitTypeSEI:
+		return true
+	case h264reader.NalUnitTypeCodedSliceIdr, h264reader.NalUnitTypeCodedSliceNonIdr, h264reader.NalUnitTypeCodedSliceDataPartitionA:
+		// first_mb_in_slice 가 0 이면 ue(v) 의 첫 비트가 1 이다.
+		return len(nal.Data) > 1 && nal.Data[1]&0x80 != 0
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package playout
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media/h264reader"
+)
+
+// first_mb_in_slice 가 0 이면 slice header 첫 비트가 1 이다.
+const (
+	firstSlice  = 0x88
+	secondSlice = 0x40
+)
+
+func TestStartsAccessUnit(t *testing.T) {
+	tests := []struct {
+		name string
+		nal  h264reader.NAL
+		want bool
+	}{
+		{name: "aud", nal: h264reader.NAL{UnitType: h264reader.NalUnitTypeAUD, Data: []byte{0x09, 0xf0}}, want: true},
+		{name: "sps", nal: h264reader.NAL{UnitType: h264reader.NalUnitTypeSPS, Data: []byte{0x67, 0x42}}, want: true},
+		{name: "pps", nal: h264reader.NAL{UnitType: h264reader.NalUnitTypePPS, Data: []byte{0x68, 0xce}}, want: true},
+		{name: "sei", nal: h264reader.NAL{UnitType: h264reader.NalUnitTypeSEI, Data: []byte{0x06, 0x05}}, want: true},
+		{name: "idr first slice", nal: h264reader.NAL{UnitType: h264reader.NalUnitTypeCodedSliceIdr, Data: []byte{0x65, firstSlice}}, want: true},
+		{name: "idr second slice", nal: h264reader.NAL{UnitType: h264reader.NalUnitTypeCodedSliceIdr, Data: []byte{0x65, secondSlice}}},
+		{name: "non-idr first slice", nal: h264reader.NAL{UnitType: h264reader.NalUnitTypeCodedSliceNonIdr, Data: []byte{0x41, firstSlice}}, want: true},
+		{name: "non-idr second slice", nal: h264reader.NAL{UnitType: h264reader.NalUnitTypeCodedSliceNonIdr, Data: []byte{0x41, secondSlice}}},
+		{name: "partition a first slice", nal: h264reader.NAL{UnitType: h264reader.NalUnitTypeCodedSliceDataPartitionA, Data: []byte{0x22, firstSlice}}, want: true},
+		{name: "slice without header", nal: h264reader.NAL{UnitType: h264reader.NalUnitTypeCodedSliceNonIdr, Data: []byte{0x41}}},
+		{name: "partition b", nal: h264reader.NAL{UnitType: h264reader.NalUnitTypeCodedSliceDataPartitionB, Data: []byte{0x23, firstSlice}}},
+		{name: "end of sequence", nal: h264reader.NAL{UnitType: h264reader.NalUnitTypeEndOfSequence, Data: []byte{0x0a}}},
+		{name: "filler", nal: h264reader.NAL{UnitType: h264reader.NalUnitTypeFiller, Data: []byte{0x0c, 0xff}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := startsAccessUnit(&tt.nal); got != tt.want {
+				t.Fatalf("startsAccessUnit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func annexB(nals ...[]byte) []byte {
+	var data []byte
+	for _, nal := range nals {
+		data = append(data, annexBStartCode...)
+		data = append(data, nal...)
+	}
+	return data
+}
+
+func TestLoadH264(t *testing.T) {
+	sps := buildSPS(spsOptions{profile: 66, numUnitsInTick: 1, timeScale: 50})
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	sei := []byte{0x06, 0x05, 0x01, 0x00, 0x80}
+	idr1 := []byte{0x65, firstSlice, 0x01}
+	idr2 := []byte{0x65, secondSlice, 0x02}
+	p1 := []byte{0x41, firstSlice, 0x03}
+	aud := []byte{0x09, 0xf0}
+	p2 := []byte{0x41, firstSlice, 0x04}
+	p2b := []byte{0x41, secondSlice, 0x05}
+	idr3 := []byte{0x65, firstSlice, 0x06}
+
+	fileName := filepath.Join(t.TempDir(), "test.h264")
+	if err := os.WriteFile(fileName, annexB(sps, pps, sei, idr1, idr2, p1, aud, p2, p2b, idr3), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	clip, err := LoadH264(fileName, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// SPS/PPS 와 AUD 는 뒤따르는 slice 와 같은 화면이고, 두 번째 slice 는 앞 화면에 붙는다.
+	// h264reader 는 SEI 를 넘겨주지 않는다.
+	want := []Frame{
+		{Data: annexB(sps, pps, idr1, idr2), Keyframe: true},
+		{Data: annexB(p1)},
+		{Data: annexB(aud, p2, p2b)},
+		{Data: annexB(idr3), Keyframe: true, ParameterSets: annexB(sps, pps)},
+	}
+	if len(clip.Frames) != len(want) {
+		t.Fatalf("%d frames, want %d", len(clip.Frames), len(want))
+	}
+	for i, frame := range clip.Frames {
+		if !bytes.Equal(frame.Data, want[i].Data) || frame.Keyframe != want[i].Keyframe || !bytes.Equal(frame.ParameterSets, want[i].ParameterSets) {
+			t.Errorf("frame %d = %+v, want %+v", i, frame, want[i])
+		}
+		// SPS 의 25fps 가 fallback 보다 먼저다.
+		if frame.Duration != 40*time.Millisecond {
+			t.Errorf("frame %d duration = %v, want 40ms", i, frame.Duration)
+		}
+	}
+	if clip.Duration() != 160*time.Millisecond {
+		t.Fatalf("duration = %v, want 160ms", clip.Duration())
+	}
+
+	// 따로 보내는 keyframe 에는 SPS/PPS 를 붙인다.
+	if got := clip.KeyframeSample(3).Data; !bytes.Equal(got, annexB(sps, pps, idr3)) {
+		t.Fatalf("KeyframeSample(3) = %x", got)
+	}
+	if got := clip.KeyframeSample(0).Data; !bytes.Equal(got, want[0].Data) {
+		t.Fatalf("KeyframeSample(0) = %x", got)
+	}
+}
+
+func TestLoadH264Fallback(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.h264")
+	sps := buildSPS(spsOptions{profile: 66, noTiming: true})
+	if err := os.WriteFile(fileName, annexB(sps, []byte{0x68, 0xce}, []byte{0x65, firstSlice}, []byte{0x41, firstSlice}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	clip, err := LoadH264(fileName, 33*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clip.Frames) != 2 || clip.Frames[0].Duration != 33*time.Millisecond {
+		t.Fatalf("frames = %+v, want 2 frames of the fallback duration", clip.Frames)
+	}
+}
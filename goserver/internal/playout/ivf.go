@@ -0,0 +1,101 @@
+package playout
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/ivfreader"
+)
+
+// LoadIVF는 IVF 파일을 읽는다. 화면 길이는 프레임마다 적힌 timestamp 의 차이다.
+func LoadIVF(fileName string) (*Clip, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open video file: %w", err)
+	}
+	defer file.Close()
+
+	ivf, header, err := ivfreader.NewWith(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IVF reader: %w", err)
+	}
+
+	var mimeType string
+	// fourcc: https://en.wikipedia.org/wiki/FourCC
+	switch header.FourCC {
+	case "AV01":
+		mimeType = webrtc.MimeTypeAV1
+	case "VP90":
+		mimeType = webrtc.MimeTypeVP9
+	case "VP80":
+		mimeType = webrtc.MimeTypeVP8
+	default:
+		return nil, fmt.Errorf("unable to handle FourCC %s", header.FourCC)
+	}
+	if header.TimebaseNumerator == 0 || header.TimebaseDenominator == 0 {
+		return nil, fmt.Errorf("invalid IVF timebase %d/%d", header.TimebaseNumerator, header.TimebaseDenominator)
+	}
+	timebase := func(ticks uint64) time.Duration {
+		return time.Duration(ticks * uint64(header.TimebaseNumerator) * uint64(time.Second) / uint64(header.TimebaseDenominator))
+	}
+
+	var frames []Frame
+	var timestamps []uint64
+	for {
+		frame, frameHeader, err := ivf.ParseNextFrame()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read IVF frame: %w", err)
+		}
+		keyframe := ivfKeyframe(mimeType, frame) || len(frames) == 0
+		frames = append(frames, Frame{Data: frame, Keyframe: keyframe})
+		timestamps = append(timestamps, frameHeader.Timestamp)
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no frames in video file: %s", fileName)
+	}
+
+	// 마지막 화면은 다음 timestamp 가 없으므로 앞 화면 길이를 쓴다. 한 장뿐이면 timebase 한 칸이다.
+	last := timebase(1)
+	for i := range frames {
+		if i+1 < len(frames) && timestamps[i+1] > timestamps[i] {
+			frames[i].Duration = timebase(timestamps[i+1] - timestamps[i])
+			last = frames[i].Duration
+		} else {
+			frames[i].Duration = last
+		}
+	}
+	// 첫 timestamp 가 0 이 아니면 그만큼 기다렸다가 첫 화면을 보낸다.
+	return newClip(mimeType, timebase(timestamps[0]), frames), nil
+}
+
+// ivfKeyframe은 VP8/VP9 의 frame header 로 keyframe 인지 본다.
+// AV1 은 OBU 를 풀어야 해서 보지 않는다. 첫 화면은 어느 코덱이든 keyframe 이다.
+func ivfKeyframe(mimeType string, frame []byte) bool {
+	if len(frame) == 0 {
+		return false
+	}
+	switch mimeType {
+	case webrtc.MimeTypeVP8:
+		// frame tag 의 첫 비트가 0 이면 keyframe
+		return frame[0]&0x01 == 0
+	case webrtc.MimeTypeVP9:
+		// frame_marker(2) profile(2, profile 3 은 reserved bit 하나 더) show_existing_frame(1) frame_type(1)
+		profile := (frame[0]>>5)&1 | (frame[0]>>3)&2
+		shift := 3
+		if profile == 3 {
+			shift = 2
+		}
+		if frame[0]>>shift&1 == 1 {
+			return false
+		}
+		return frame[0]>>(shift-1)&1 == 0
+	}
+	return false
+}
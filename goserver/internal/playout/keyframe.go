@@ -0,0 +1,152 @@
+package playout
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// 브라우저는 화면이 복구될 때까지 PLI 를 계속 보내므로 keyframe 을 이보다 자주 다시 보내지 않는다.
+const minKeyframeInterval = 500 * time.Millisecond
+
+// Sender는 peer 하나의 track 에 화면을 쓴다.
+// 처음 쓰는 화면과 PLI/FIR 을 받은 뒤의 화면은 keyframe 으로 바꿔서 보내고 다음 keyframe 까지 멈춘다.
+// 영상은 메모리에 있으므로 지금 위치 앞의 가장 가까운 keyframe 이 곧 keyframe cache 다.
+// 중간에 들어오거나 패킷을 잃은 태블릿이 다음 IDR 까지 회색/초록 화면을 보지 않게 한다.
+// simulcast 면 Switch 로 다른 화질의 clip 으로 바꿀 수 있다. 바꾸는 것은 그 clip 의 keyframe 에서 한다.
+type Sender struct {
+	track SampleWriter
+
+	mu                sync.Mutex
+	clip              *Clip
+	pending           *Clip
+	keyframeRequested bool
+	lastKeyframeAt    time.Time
+	// 앞의 keyframe 을 대신 보낸 뒤 다음 keyframe 까지 화면을 멈춘 중이다.
+	frozen bool
+}
+
+// SampleWriter는 Sender 가 화면을 쓸 곳이다. 보통 *webrtc.TrackLocalStaticSample 이다.
+type SampleWriter interface {
+	WriteSample(media.Sample) error
+}
+
+func NewSender(clip *Clip, track SampleWriter) *Sender {
+	return &Sender{clip: clip, track: track, keyframeRequested: true}
+}
+
+// RequestKeyframe은 다음에 쓰는 화면을 keyframe 으로 바꾼다.
+func (s *Sender) RequestKeyframe() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.lastKeyframeAt) < minKeyframeInterval {
+		return
+	}
+	s.keyframeRequested = true
+}
+
+// Resync는 화면을 건너뛰어 참조가 끊겼을 때 부른다. RequestKeyframe 과 달리 바로 전에 keyframe 을 보냈어도 다시 보낸다.
+func (s *Sender) Resync() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keyframeRequested = true
+}
+
+// Switch는 clip 의 다음 keyframe 부터 clip 을 보낸다. PLI/FIR 을 받으면 그때 바로 바꾼다.
+// clip 은 지금 clip 과 코덱과 화면 수가 같아야 한다.
+func (s *Sender) Switch(clip *Clip) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending = clip
+	if clip == s.clip {
+		s.pending = nil
+	}
+}
+
+// Clip은 지금 보내고 있는 clip 이다.
+func (s *Sender) Clip() *Clip {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.clip
+}
+
+// Write는 i 번째 화면을 쓴다. keyframe 이 필요하면 i 대신 그 앞의 keyframe 을 보내고 다음 keyframe 까지 멈춘다.
+// 그 사이의 화면은 보내지 않은 화면을 참조해서 깨지므로 버린다. 대신 보낸 keyframe 의 길이를
+// 다음 keyframe 까지로 늘려서 RTP timestamp 가 재생 시각과 맞게 한다.
+// 멈춘 동안 다시 keyframe 을 요청받아도 곧 올 keyframe 으로 충분하다.
+func (s *Sender) Write(i int) error {
+	s.mu.Lock()
+	if s.pending != nil && (s.pending.Frames[i].Keyframe || s.keyframeRequested) {
+		s.clip = s.pending
+		s.pending = nil
+	}
+	sample := s.clip.Sample(i)
+	switch {
+	case s.clip.Frames[i].Keyframe:
+		sample = s.clip.KeyframeSample(i)
+		s.keyframeRequested = false
+		s.frozen = false
+		s.lastKeyframeAt = time.Now()
+	case s.frozen:
+		s.mu.Unlock()
+		return nil
+	case s.keyframeRequested:
+		if k := s.clip.keyframeAt(i); k >= 0 {
+			sample = s.clip.KeyframeSample(k)
+			sample.Duration = s.clip.untilKeyframe(i)
+			s.keyframeRequested = false
+			s.frozen = true
+			s.lastKeyframeAt = time.Now()
+		}
+	}
+	s.mu.Unlock()
+
+	return s.track.WriteSample(sample)
+}
+
+// RTCPHandler는 ReadRTCP 가 받은 RTCP 를 넘길 곳이다. 필요 없는 것은 nil 로 둔다.
+type RTCPHandler struct {
+	// PLI/FIR
+	KeyframeRequest func()
+	// receiver report 중 이 sender 의 SSRC 에 대한 것
+	ReceptionReport func(rtcp.ReceptionReport)
+}
+
+// ReadRTCP는 rtpSender 의 RTCP 를 읽어서 handler 에 넘긴다.
+// 읽지 않으면 NACK 같은 interceptor 가 동작하지 않으므로 peer 가 닫힐 때까지 돌려야 한다.
+func ReadRTCP(rtpSender *webrtc.RTPSender, handler RTCPHandler) {
+	var ssrc webrtc.SSRC
+	if encodings := rtpSender.GetParameters().Encodings; len(encodings) > 0 {
+		ssrc = encodings[0].SSRC
+	}
+	for {
+		packets, _, err := rtpSender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, packet := range packets {
+			switch packet := packet.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				if handler.KeyframeRequest != nil {
+					handler.KeyframeRequest()
+				}
+			case *rtcp.ReceiverReport:
+				if handler.ReceptionReport == nil {
+					continue
+				}
+				for _, report := range packet.Reports {
+					if report.SSRC == uint32(ssrc) {
+						handler.ReceptionReport(report)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package playout
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+type sampleRecorder struct {
+	samples []media.Sample
+}
+
+func (r *sampleRecorder) WriteSample(sample media.Sample) error {
+	r.samples = append(r.samples, sample)
+	return nil
+}
+
+// gopClip은 K0 P1 P2 P3 K4 P5 이고 i 번째 화면 길이는 i+1 ms 다.
+func gopClip(tag byte) *Clip {
+	frames := make([]Frame, 6)
+	for i := range frames {
+		frames[i] = Frame{Data: []byte{tag, byte(i)}, Duration: time.Duration(i+1) * time.Millisecond}
+	}
+	frames[0].Keyframe = true
+	frames[0].ParameterSets = []byte{tag, 0x67}
+	frames[4].Keyframe = true
+	return newClip("video/H264", 0, frames)
+}
+
+// write는 indices 를 차례로 쓰고 새로 쓴 sample 을 돌려준다.
+func write(t *testing.T, sender *Sender, recorder *sampleRecorder, indices ...int) []media.Sample {
+	t.Helper()
+	before := len(recorder.samples)
+	for _, i := range indices {
+		if err := sender.Write(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return recorder.samples[before:]
+}
+
+func checkSamples(t *testing.T, got []media.Sample, want ...media.Sample) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d samples, want %d: %v", len(got), len(want), got)
+	}
+	for i := range got {
+		if !bytes.Equal(got[i].Data, want[i].Data) || got[i].Duration != want[i].Duration {
+			t.Errorf("sample %d = %x %v, want %x %v", i, got[i].Data, got[i].Duration, want[i].Data, want[i].Duration)
+		}
+	}
+}
+
+func TestSenderFreezesUntilKeyframe(t *testing.T) {
+	clip := gopClip(1)
+	recorder := &sampleRecorder{}
+	sender := NewSender(clip, recorder)
+
+	// 2 에서 시작하면 K0 을 보내고 P2, P3 은 버린다. K0 은 K4 까지의 길이(3ms+4ms)를 가진다.
+	frozenKeyframe := clip.KeyframeSample(0)
+	frozenKeyframe.Duration = 7 * time.Millisecond
+	checkSamples(t, write(t, sender, recorder, 2, 3), frozenKeyframe)
+	checkSamples(t, write(t, sender, recorder, 4, 5), clip.KeyframeSample(4), clip.Sample(5))
+}
+
+func TestSenderFreezeWraps(t *testing.T) {
+	clip := gopClip(1)
+	recorder := &sampleRecorder{}
+	sender := NewSender(clip, recorder)
+
+	// P5 다음은 처음의 K0 이다.
+	frozenKeyframe := clip.KeyframeSample(4)
+	frozenKeyframe.Duration = 6 * time.Millisecond
+	checkSamples(t, write(t, sender, recorder, 5, 0), frozenKeyframe, clip.KeyframeSample(0))
+}
+
+func TestSenderKeyframeRequests(t *testing.T) {
+	clip := gopClip(1)
+	recorder := &sampleRecorder{}
+	sender := NewSender(clip, recorder)
+	checkSamples(t, write(t, sender, recorder, 0), clip.KeyframeSample(0))
+
+	// 방금 keyframe 을 보냈으면 PLI 는 무시한다.
+	sender.RequestKeyframe()
+	checkSamples(t, write(t, sender, recorder, 1), clip.Sample(1))
+
+	// Resync 는 바로 다시 보낸다.
+	sender.Resync()
+	frozenKeyframe := clip.KeyframeSample(0)
+	frozenKeyframe.Duration = 7 * time.Millisecond
+	checkSamples(t, write(t, sender, recorder, 2), frozenKeyframe)
+
+	// 멈춘 동안의 요청은 다음 keyframe 이 처리한다.
+	sender.lastKeyframeAt = time.Time{}
+	sender.RequestKeyframe()
+	checkSamples(t, write(t, sender, recorder, 3))
+	checkSamples(t, write(t, sender, recorder, 4, 5), clip.KeyframeSample(4), clip.Sample(5))
+}
+
+func TestSenderSwitch(t *testing.T) {
+	low, high := gopClip(1), gopClip(2)
+	recorder := &sampleRecorder{}
+	sender := NewSender(low, recorder)
+	write(t, sender, recorder, 0)
+
+	// 바꾸는 것은 다음 keyframe 에서 한다.
+	sender.Switch(high)
+	checkSamples(t, write(t, sender, recorder, 1, 2, 3, 4), low.Sample(1), low.Sample(2), low.Sample(3), high.KeyframeSample(4))
+	if sender.Clip() != high {
+		t.Fatal("Clip() is not the switched clip")
+	}
+}
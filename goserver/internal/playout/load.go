@@ -0,0 +1,46 @@
+package playout
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Load는 확장자를 보고 H.264, IVF, MP4 중 맞는 것으로 읽는다.
+// fallback 은 H.264 에 timing 정보가 없을 때의 화면 길이다.
+func Load(fileName string, fallback time.Duration) (*Clip, error) {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".h264", ".264":
+		return LoadH264(fileName, fallback)
+	case ".ivf":
+		return LoadIVF(fileName)
+	case ".mp4", ".m4v", ".mov":
+		return LoadMP4(fileName)
+	}
+	return nil, fmt.Errorf("unknown video file type: %s", fileName)
+}
+
+// LoadAudio는 Ogg Opus 나 MP4 의 Opus track 을 읽는다.
+func LoadAudio(fileName string) (*Clip, error) {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".ogg", ".opus":
+		return LoadOgg(fileName)
+	case ".mp4", ".m4a", ".m4v", ".mov":
+		return LoadMP4Audio(fileName)
+	}
+	return nil, fmt.Errorf("unknown audio file type: %s", fileName)
+}
+
+// Sendable은 지금 쓰는 pion 에 mimeType 의 payloader 가 있는지 본다.
+// 없는 코덱의 track 을 붙이면 answer 를 만들 때 peer 전체가 실패한다.
+func Sendable(mimeType string) bool {
+	for _, sendable := range []string{webrtc.MimeTypeH264, webrtc.MimeTypeVP8, webrtc.MimeTypeVP9, webrtc.MimeTypeAV1, webrtc.MimeTypeOpus} {
+		if strings.EqualFold(mimeType, sendable) {
+			return true
+		}
+	}
+	return false
+}
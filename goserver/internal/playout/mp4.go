@@ -0,0 +1,777 @@
+package playout
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// mp4Sample은 파일 안의 sample 하나다. 시간은 track timescale 단위다.
+type mp4Sample struct {
+	offset   int64
+	size     uint32
+	dts      int64
+	duration uint32
+	// composition time offset. pts = dts + cto
+	cto  int32
+	sync bool
+}
+
+// 화면을 모두 메모리에 읽어 두므로 이보다 sample 이 많으면 잘못된 파일로 본다. 60fps 로 4시간 반쯤이다.
+const maxMP4Samples = 1 << 20
+
+type mp4Edit struct {
+	// movie timescale 단위
+	segmentDuration uint64
+	// -1 이면 빈 구간
+	mediaTime int64
+}
+
+type mp4Track struct {
+	id        uint32
+	timescale uint32
+	mimeType  string
+	// H.264/H.265 sample 의 NAL 길이 필드 크기. 0 이면 sample 을 그대로 보낸다.
+	lengthSize int
+	// avcC/hvcC 의 VPS/SPS/PPS 를 Annex-B 로 이어 붙인 것
+	parameterSets []byte
+	edits         []mp4Edit
+	samples       []mp4Sample
+
+	// trex 의 fragment 기본값
+	defaultDuration uint32
+	defaultSize     uint32
+	defaultFlags    uint32
+}
+
+// LoadMP4는 MP4 나 fragmented MP4 의 첫 영상 track 을 읽는다.
+// avcC/hvcC 의 length-prefixed NAL 은 Annex-B 로 바꾸고 keyframe 에 SPS/PPS 를 붙인다.
+// VP8/VP9(vpcC), AV1 sample 은 그대로 쓴다. 화면 길이는 sample timestamp 로, 시작과 끝은 edit list 로 정한다.
+// B-frame 이 있어도 decode 순서로 보낸다. H.265 는 읽을 수 있지만 지금 쓰는 pion 에는 H.265 payloader 가 없다.
+func LoadMP4(fileName string) (*Clip, error) {
+	return loadMP4(fileName, "vide")
+}
+
+// LoadMP4Audio는 MP4 의 첫 Opus track 을 읽는다. sample 하나가 Opus packet 하나다.
+func LoadMP4Audio(fileName string) (*Clip, error) {
+	return loadMP4(fileName, "soun")
+}
+
+// loadMP4는 hdlr 의 handler_type 이 handler 인 첫 track 을 읽는다.
+func loadMP4(fileName string, handler string) (*Clip, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open media file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat media file: %w", err)
+	}
+	headers, err := readBoxHeaders(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	var moov *mp4Box
+	var moofs []boxHeader
+	for _, header := range headers {
+		switch header.typ {
+		case "moov":
+			box, err := header.read(file)
+			if err != nil {
+				return nil, err
+			}
+			moov = &box
+		case "moof":
+			moofs = append(moofs, header)
+		}
+	}
+	if moov == nil {
+		return nil, fmt.Errorf("mp4: no moov box in %s", fileName)
+	}
+
+	movieTimescale, err := parseTimescale(*moov, "mvhd")
+	if err != nil {
+		return nil, err
+	}
+	track, err := findTrack(*moov, handler)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s", err, fileName)
+	}
+	if mvex, ok := moov.child("mvex"); ok {
+		track.parseTrex(mvex)
+	}
+	for _, header := range moofs {
+		moof, err := header.read(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := track.parseMoof(moof, header.offset); err != nil {
+			return nil, err
+		}
+	}
+	if len(track.samples) == 0 {
+		return nil, fmt.Errorf("no samples in media file: %s", fileName)
+	}
+
+	delay, frames, err := track.frames(file, info.Size(), movieTimescale)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s", err, fileName)
+	}
+	return newClip(track.mimeType, delay, frames), nil
+}
+
+func parseTimescale(parent mp4Box, typ string) (uint32, error) {
+	box, ok := parent.child(typ)
+	if !ok {
+		return 0, fmt.Errorf("mp4: no %s box", typ)
+	}
+	r := newBoxReader(box)
+	version, _ := r.fullBox()
+	r.versioned(version) // creation_time
+	r.versioned(version) // modification_time
+	timescale := r.u32()
+	if r.err != nil || timescale == 0 {
+		return 0, fmt.Errorf("mp4: invalid %s box", typ)
+	}
+	return timescale, nil
+}
+
+func findTrack(moov mp4Box, handler string) (*mp4Track, error) {
+	var unsupported []string
+	for _, trak := range moov.allChildren("trak") {
+		hdlr, ok := trak.child("mdia", "hdlr")
+		if !ok {
+			continue
+		}
+		r := newBoxReader(hdlr)
+		r.fullBox()
+		r.skip(4) // pre_defined
+		if string(r.bytes(4)) != handler {
+			continue
+		}
+
+		stbl, ok := trak.child("mdia", "minf", "stbl")
+		if !ok {
+			continue
+		}
+		track := &mp4Track{}
+		entry, err := track.parseSampleEntry(stbl)
+		if err != nil {
+			unsupported = append(unsupported, entry)
+			continue
+		}
+		if err := track.parseTrackHeader(trak); err != nil {
+			return nil, err
+		}
+		if err := track.parseSampleTable(stbl); err != nil {
+			return nil, err
+		}
+		if elst, ok := trak.child("edts", "elst"); ok {
+			track.parseEditList(elst)
+		}
+		return track, nil
+	}
+	if len(unsupported) > 0 {
+		return nil, fmt.Errorf("mp4: unsupported %s sample entry %v", handler, unsupported)
+	}
+	return nil, fmt.Errorf("mp4: no %s track", handler)
+}
+
+func (t *mp4Track) parseTrackHeader(trak mp4Box) error {
+	tkhd, ok := trak.child("tkhd")
+	if !ok {
+		return errors.New("mp4: no tkhd box")
+	}
+	r := newBoxReader(tkhd)
+	version, _ := r.fullBox()
+	r.versioned(version) // creation_time
+	r.versioned(version) // modification_time
+	t.id = r.u32()
+	if r.err != nil {
+		return fmt.Errorf("mp4: invalid tkhd box: %w", r.err)
+	}
+
+	mdia, _ := trak.child("mdia")
+	timescale, err := parseTimescale(mdia, "mdhd")
+	if err != nil {
+		return err
+	}
+	t.timescale = timescale
+	return nil
+}
+
+// parseSampleEntry는 stsd 의 첫 sample entry 로 코덱을 정한다. 실패해도 entry 이름을 돌려준다.
+func (t *mp4Track) parseSampleEntry(stbl mp4Box) (string, error) {
+	stsd, ok := stbl.child("stsd")
+	if !ok {
+		return "", errors.New("mp4: no stsd box")
+	}
+	// version, flags, entry_count
+	entries, err := stsd.children(8)
+	if err != nil || len(entries) == 0 {
+		return "", errors.New("mp4: empty stsd box")
+	}
+	entry := entries[0]
+
+	// VisualSampleEntry 는 고정 필드 78 바이트, AudioSampleEntry 는 28 바이트 뒤에 avcC 같은 box 가 온다.
+	entrySize := 78
+	if entry.typ == "Opus" {
+		entrySize = 28
+	}
+	config := func(typ string) (mp4Box, error) {
+		children, err := entry.children(entrySize)
+		if err != nil {
+			return mp4Box{}, err
+		}
+		for _, c := range children {
+			if c.typ == typ {
+				return c, nil
+			}
+		}
+		return mp4Box{}, fmt.Errorf("mp4: %s without %s", entry.typ, typ)
+	}
+
+	switch entry.typ {
+	case "avc1", "avc3":
+		avcC, err := config("avcC")
+		if err != nil {
+			return entry.typ, err
+		}
+		t.mimeType = webrtc.MimeTypeH264
+		t.lengthSize, t.parameterSets, err = parseAVCC(avcC)
+		return entry.typ, err
+	case "hvc1", "hev1":
+		hvcC, err := config("hvcC")
+		if err != nil {
+			return entry.typ, err
+		}
+		t.mimeType = webrtc.MimeTypeH265
+		t.lengthSize, t.parameterSets, err = parseHVCC(hvcC)
+		return entry.typ, err
+	case "vp08", "vp09":
+		if _, err := config("vpcC"); err != nil {
+			return entry.typ, err
+		}
+		t.mimeType = webrtc.MimeTypeVP8
+		if entry.typ == "vp09" {
+			t.mimeType = webrtc.MimeTypeVP9
+		}
+		return entry.typ, nil
+	case "av01":
+		t.mimeType = webrtc.MimeTypeAV1
+		return entry.typ, nil
+	case "Opus":
+		if _, err := config("dOps"); err != nil {
+			return entry.typ, err
+		}
+		t.mimeType = webrtc.MimeTypeOpus
+		return entry.typ, nil
+	}
+	return entry.typ, fmt.Errorf("mp4: unsupported sample entry %s", entry.typ)
+}
+
+// parseAVCC는 AVCDecoderConfigurationRecord 를 읽는다. (ISO/IEC 14496-15 5.3.3.1)
+func parseAVCC(avcC mp4Box) (int, []byte, error) {
+	r := newBoxReader(avcC)
+	r.skip(4) // configurationVersion, profile, compatibility, level
+	lengthSize := int(r.u8()&0x03) + 1
+
+	var parameterSets []byte
+	appendNALs := func(count int) {
+		for i := 0; i < count && r.err == nil; i++ {
+			nal := r.bytes(int(r.u16()))
+			parameterSets = append(parameterSets, annexBStartCode...)
+			parameterSets = append(parameterSets, nal...)
+		}
+	}
+	appendNALs(int(r.u8() & 0x1f)) // SPS
+	appendNALs(int(r.u8()))        // PPS
+	if r.err != nil {
+		return 0, nil, fmt.Errorf("mp4: invalid avcC box: %w", r.err)
+	}
+	return lengthSize, parameterSets, nil
+}
+
+// parseHVCC는 HEVCDecoderConfigurationRecord 를 읽는다. (ISO/IEC 14496-15 8.3.3.1)
+func parseHVCC(hvcC mp4Box) (int, []byte, error) {
+	r := newBoxReader(hvcC)
+	r.skip(21)
+	lengthSize := int(r.u8()&0x03) + 1
+
+	var parameterSets []byte
+	arrays := int(r.u8())
+	for i := 0; i < arrays && r.err == nil; i++ {
+		r.u8() // array_completeness, NAL_unit_type
+		count := int(r.u16())
+		for j := 0; j < count && r.err == nil; j++ {
+			nal := r.bytes(int(r.u16()))
+			parameterSets = append(parameterSets, annexBStartCode...)
+			parameterSets = append(parameterSets, nal...)
+		}
+	}
+	if r.err != nil {
+		return 0, nil, fmt.Errorf("mp4: invalid hvcC box: %w", r.err)
+	}
+	return lengthSize, parameterSets, nil
+}
+
+// parseSampleTable은 moov 안의 sample 들을 읽는다. fragmented MP4 는 여기가 비어 있다.
+func (t *mp4Track) parseSampleTable(stbl mp4Box) error {
+	sizes, err := parseSampleSizes(stbl)
+	if err != nil {
+		return err
+	}
+	if len(sizes) == 0 {
+		return nil
+	}
+	samples := make([]mp4Sample, len(sizes))
+	for i, size := range sizes {
+		samples[i].size = size
+		// stss 가 없으면 모두 sync sample 이다.
+		samples[i].sync = true
+	}
+
+	if err := fillSampleOffsets(stbl, samples); err != nil {
+		return err
+	}
+
+	stts, ok := stbl.child("stts")
+	if !ok {
+		return errors.New("mp4: no stts box")
+	}
+	r := newBoxReader(stts)
+	r.fullBox()
+	entries := int(r.u32())
+	i := 0
+	dts := int64(0)
+	for e := 0; e < entries && r.err == nil; e++ {
+		count, delta := int(r.u32()), r.u32()
+		for k := 0; k < count && i < len(samples); k++ {
+			samples[i].dts = dts
+			samples[i].duration = delta
+			dts += int64(delta)
+			i++
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("mp4: invalid stts box: %w", r.err)
+	}
+
+	if ctts, ok := stbl.child("ctts"); ok {
+		r := newBoxReader(ctts)
+		r.fullBox()
+		entries := int(r.u32())
+		i := 0
+		for e := 0; e < entries && r.err == nil; e++ {
+			// version 0 은 부호가 없지만 음수를 그렇게 쓰는 muxer 가 있어서 둘 다 signed 로 읽는다.
+			count, cto := int(r.u32()), int32(r.u32())
+			for k := 0; k < count && i < len(samples); k++ {
+				samples[i].cto = cto
+				i++
+			}
+		}
+		if r.err != nil {
+			return fmt.Errorf("mp4: invalid ctts box: %w", r.err)
+		}
+	}
+
+	if stss, ok := stbl.child("stss"); ok {
+		for i := range samples {
+			samples[i].sync = false
+		}
+		r := newBoxReader(stss)
+		r.fullBox()
+		entries := int(r.u32())
+		for e := 0; e < entries && r.err == nil; e++ {
+			if number := int(r.u32()); number >= 1 && number <= len(samples) {
+				samples[number-1].sync = true
+			}
+		}
+		if r.err != nil {
+			return fmt.Errorf("mp4: invalid stss box: %w", r.err)
+		}
+	}
+
+	t.samples = samples
+	return nil
+}
+
+func parseSampleSizes(stbl mp4Box) ([]uint32, error) {
+	if stsz, ok := stbl.child("stsz"); ok {
+		r := newBoxReader(stsz)
+		r.fullBox()
+		sampleSize, count := r.u32(), int(r.u32())
+		if r.err != nil || (sampleSize == 0 && count > len(stsz.data)/4) {
+			return nil, errors.New("mp4: invalid stsz box")
+		}
+		if count > maxMP4Samples {
+			return nil, fmt.Errorf("mp4: too many samples %d", count)
+		}
+		sizes := make([]uint32, count)
+		for i := range sizes {
+			if sampleSize != 0 {
+				sizes[i] = sampleSize
+			} else {
+				sizes[i] = r.u32()
+			}
+		}
+		return sizes, r.err
+	}
+
+	if stz2, ok := stbl.child("stz2"); ok {
+		r := newBoxReader(stz2)
+		r.fullBox()
+		r.skip(3) // reserved
+		fieldSize, count := int(r.u8()), int(r.u32())
+		if r.err != nil || count > len(stz2.data)*2 {
+			return nil, errors.New("mp4: invalid stz2 box")
+		}
+		if count > maxMP4Samples {
+			return nil, fmt.Errorf("mp4: too many samples %d", count)
+		}
+		sizes := make([]uint32, count)
+		for i := range sizes {
+			switch fieldSize {
+			case 4:
+				if i%2 == 0 {
+					b := r.u8()
+					sizes[i] = uint32(b >> 4)
+					if i+1 < count {
+						sizes[i+1] = uint32(b & 0x0f)
+					}
+				}
+			case 8:
+				sizes[i] = uint32(r.u8())
+			case 16:
+				sizes[i] = uint32(r.u16())
+			default:
+				return nil, fmt.Errorf("mp4: invalid stz2 field size %d", fieldSize)
+			}
+		}
+		return sizes, r.err
+	}
+	return nil, errors.New("mp4: no stsz box")
+}
+
+// fillSampleOffsets는 stsc 와 stco/co64 로 sample 의 파일 위치를 정한다.
+func fillSampleOffsets(stbl mp4Box, samples []mp4Sample) error {
+	var chunkOffsets []int64
+	if stco, ok := stbl.child("stco"); ok {
+		r := newBoxReader(stco)
+		r.fullBox()
+		count := int(r.u32())
+		for i := 0; i < count && r.err == nil; i++ {
+			chunkOffsets = append(chunkOffsets, int64(r.u32()))
+		}
+		if r.err != nil {
+			return fmt.Errorf("mp4: invalid stco box: %w", r.err)
+		}
+	} else if co64, ok := stbl.child("co64"); ok {
+		r := newBoxReader(co64)
+		r.fullBox()
+		count := int(r.u32())
+		for i := 0; i < count && r.err == nil; i++ {
+			chunkOffsets = append(chunkOffsets, int64(r.u64()))
+		}
+		if r.err != nil {
+			return fmt.Errorf("mp4: invalid co64 box: %w", r.err)
+		}
+	} else {
+		return errors.New("mp4: no stco box")
+	}
+
+	stsc, ok := stbl.child("stsc")
+	if !ok {
+		return errors.New("mp4: no stsc box")
+	}
+	type stscEntry struct {
+		firstChunk      uint32
+		samplesPerChunk uint32
+	}
+	var entries []stscEntry
+	r := newBoxReader(stsc)
+	r.fullBox()
+	count := int(r.u32())
+	for i := 0; i < count && r.err == nil; i++ {
+		entries = append(entries, stscEntry{firstChunk: r.u32(), samplesPerChunk: r.u32()})
+		r.u32() // sample_description_index
+	}
+	if r.err != nil || len(entries) == 0 {
+		return errors.New("mp4: invalid stsc box")
+	}
+
+	i, e := 0, 0
+	for c, offset := range chunkOffsets {
+		chunk := uint32(c + 1)
+		for e+1 < len(entries) && entries[e+1].firstChunk <= chunk {
+			e++
+		}
+		for k := uint32(0); k < entries[e].samplesPerChunk && i < len(samples); k++ {
+			samples[i].offset = offset
+			offset += int64(samples[i].size)
+			i++
+		}
+	}
+	if i < len(samples) {
+		return fmt.Errorf("mp4: chunks cover %d of %d samples", i, len(samples))
+	}
+	return nil
+}
+
+func (t *mp4Track) parseEditList(elst mp4Box) {
+	r := newBoxReader(elst)
+	version, _ := r.fullBox()
+	count := int(r.u32())
+	for i := 0; i < count && r.err == nil; i++ {
+		edit := mp4Edit{segmentDuration: r.versioned(version)}
+		if version == 1 {
+			edit.mediaTime = int64(r.u64())
+		} else {
+			edit.mediaTime = int64(int32(r.u32()))
+		}
+		r.u32() // media_rate
+		if r.err == nil {
+			t.edits = append(t.edits, edit)
+		}
+	}
+}
+
+func (t *mp4Track) parseTrex(mvex mp4Box) {
+	for _, trex := range mvex.allChildren("trex") {
+		r := newBoxReader(trex)
+		r.fullBox()
+		if r.u32() != t.id {
+			continue
+		}
+		r.u32() // default_sample_description_index
+		t.defaultDuration = r.u32()
+		t.defaultSize = r.u32()
+		t.defaultFlags = r.u32()
+	}
+}
+
+const (
+	tfhdBaseDataOffset    = 0x000001
+	tfhdSampleDescription = 0x000002
+	tfhdDefaultDuration   = 0x000008
+	tfhdDefaultSize       = 0x000010
+	tfhdDefaultFlags      = 0x000020
+	trunDataOffset        = 0x000001
+	trunFirstSampleFlags  = 0x000004
+	trunSampleDuration    = 0x000100
+	trunSampleSize        = 0x000200
+	trunSampleFlags       = 0x000400
+	trunSampleCTO         = 0x000800
+	sampleIsNonSyncSample = 0x010000
+)
+
+// parseMoof는 fragment 하나의 sample 들을 더한다. (ISO/IEC 14496-12 8.8)
+func (t *mp4Track) parseMoof(moof mp4Box, moofOffset int64) error {
+	for _, traf := range moof.allChildren("traf") {
+		tfhd, ok := traf.child("tfhd")
+		if !ok {
+			return errors.New("mp4: traf without tfhd")
+		}
+		r := newBoxReader(tfhd)
+		_, flags := r.fullBox()
+		if r.u32() != t.id {
+			continue
+		}
+		// default-base-is-moof 가 아니어도 대부분 moof 시작이 기준이다.
+		base := moofOffset
+		if flags&tfhdBaseDataOffset != 0 {
+			base = int64(r.u64())
+		}
+		if flags&tfhdSampleDescription != 0 {
+			r.u32()
+		}
+		duration, size, sampleFlags := t.defaultDuration, t.defaultSize, t.defaultFlags
+		if flags&tfhdDefaultDuration != 0 {
+			duration = r.u32()
+		}
+		if flags&tfhdDefaultSize != 0 {
+			size = r.u32()
+		}
+		if flags&tfhdDefaultFlags != 0 {
+			sampleFlags = r.u32()
+		}
+		if r.err != nil {
+			return fmt.Errorf("mp4: invalid tfhd box: %w", r.err)
+		}
+
+		// tfdt 가 없으면 앞 fragment 에 이어진다.
+		dts := int64(0)
+		if n := len(t.samples); n > 0 {
+			dts = t.samples[n-1].dts + int64(t.samples[n-1].duration)
+		}
+		if tfdt, ok := traf.child("tfdt"); ok {
+			r := newBoxReader(tfdt)
+			version, _ := r.fullBox()
+			dts = int64(r.versioned(version))
+		}
+
+		offset := base
+		for _, trun := range traf.allChildren("trun") {
+			r := newBoxReader(trun)
+			_, flags := r.fullBox()
+			count := int(r.u32())
+			if len(t.samples)+count > maxMP4Samples {
+				return fmt.Errorf("mp4: too many samples %d", len(t.samples)+count)
+			}
+			if flags&trunDataOffset != 0 {
+				offset = base + int64(int32(r.u32()))
+			}
+			firstFlags := sampleFlags
+			if flags&trunFirstSampleFlags != 0 {
+				firstFlags = r.u32()
+			}
+			for i := 0; i < count && r.err == nil; i++ {
+				sample := mp4Sample{offset: offset, dts: dts, duration: duration, size: size}
+				f := sampleFlags
+				if i == 0 {
+					f = firstFlags
+				}
+				if flags&trunSampleDuration != 0 {
+					sample.duration = r.u32()
+				}
+				if flags&trunSampleSize != 0 {
+					sample.size = r.u32()
+				}
+				if flags&trunSampleFlags != 0 {
+					f = r.u32()
+				}
+				if flags&trunSampleCTO != 0 {
+					// version 0 은 unsigned 지만 ctts 처럼 signed 로 읽는다.
+					sample.cto = int32(r.u32())
+				}
+				sample.sync = f&sampleIsNonSyncSample == 0
+				t.samples = append(t.samples, sample)
+				offset += int64(sample.size)
+				dts += int64(sample.duration)
+			}
+			if r.err != nil {
+				return fmt.Errorf("mp4: invalid trun box: %w", r.err)
+			}
+		}
+	}
+	return nil
+}
+
+// mediaDuration은 timescale 단위 값을 시간으로 바꾼다. 곱하다 넘치지 않게 나눠서 계산한다.
+func mediaDuration(value int64, timescale uint32) time.Duration {
+	ts := int64(timescale)
+	return time.Duration(value/ts*int64(time.Second) + value%ts*int64(time.Second)/ts)
+}
+
+// frames는 edit list 를 적용해서 sample 을 화면으로 바꾼다.
+// 앞의 빈 편집은 delay 가 되고, 첫 편집의 media_time 부터 segment_duration 만큼 보낸다.
+// 시작은 디코딩할 수 있도록 그 앞의 sync sample 로 당긴다. 뒤의 편집은 무시한다.
+func (t *mp4Track) frames(r io.ReaderAt, fileSize int64, movieTimescale uint32) (time.Duration, []Frame, error) {
+	delay := time.Duration(0)
+	start, end := int64(math.MinInt64), int64(math.MaxInt64)
+	for _, edit := range t.edits {
+		if edit.mediaTime < 0 {
+			delay += mediaDuration(int64(edit.segmentDuration), movieTimescale)
+			continue
+		}
+		start = edit.mediaTime
+		if edit.segmentDuration > 0 {
+			end = start + int64(float64(edit.segmentDuration)*float64(t.timescale)/float64(movieTimescale))
+		}
+		break
+	}
+
+	first := -1
+	for i, sample := range t.samples {
+		if sample.dts+int64(sample.cto) >= start {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return 0, nil, errors.New("mp4: edit list starts after the last sample")
+	}
+	for first > 0 && !t.samples[first].sync {
+		first--
+	}
+	last := len(t.samples)
+	for i := first + 1; i < len(t.samples); i++ {
+		if t.samples[i].dts >= end {
+			last = i
+			break
+		}
+	}
+
+	base := t.samples[first].dts
+	frames := make([]Frame, 0, last-first)
+	previous := time.Duration(0)
+	for i := first; i < last; i++ {
+		sample := t.samples[i]
+		frameEnd := sample.dts + int64(sample.duration)
+		if i+1 < last {
+			frameEnd = t.samples[i+1].dts
+		} else if frameEnd > end {
+			frameEnd = end
+		}
+		duration := mediaDuration(frameEnd-base, t.timescale) - mediaDuration(sample.dts-base, t.timescale)
+		if duration <= 0 {
+			// 마지막 sample 의 duration 을 0 으로 쓰는 muxer 가 있다.
+			duration = previous
+		}
+		if duration <= 0 {
+			duration = time.Second / 30
+		}
+		previous = duration
+
+		if sample.offset < 0 || sample.offset+int64(sample.size) > fileSize {
+			return 0, nil, fmt.Errorf("mp4: sample %d is outside of the file", i)
+		}
+		data := make([]byte, sample.size)
+		if _, err := r.ReadAt(data, sample.offset); err != nil {
+			return 0, nil, fmt.Errorf("mp4: failed to read sample %d: %w", i, err)
+		}
+		frame := Frame{Duration: duration, Keyframe: sample.sync}
+		var err error
+		if frame.Data, err = t.convert(data); err != nil {
+			return 0, nil, fmt.Errorf("mp4: sample %d: %w", i, err)
+		}
+		if frame.Keyframe {
+			frame.ParameterSets = t.parameterSets
+		}
+		frames = append(frames, frame)
+	}
+	return delay, frames, nil
+}
+
+// convert는 length-prefixed NAL 들을 Annex-B 로 바꾼다.
+func (t *mp4Track) convert(data []byte) ([]byte, error) {
+	if t.lengthSize == 0 {
+		return data, nil
+	}
+	out := make([]byte, 0, len(data)+len(data)/64)
+	for len(data) > 0 {
+		if len(data) < t.lengthSize {
+			return nil, errors.New("truncated NAL length")
+		}
+		n := 0
+		for _, b := range data[:t.lengthSize] {
+			n = n<<8 | int(b)
+		}
+		data = data[t.lengthSize:]
+		if n > len(data) {
+			return nil, errors.New("NAL length exceeds sample size")
+		}
+		out = append(out, annexBStartCode...)
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+	return out, nil
+}
@@ -0,0 +1,438 @@
+package playout
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+func box(typ string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	return bytes.Join([][]byte{u32(uint32(8 + len(data))), []byte(typ), data}, nil)
+}
+
+func fullBox(typ string, version uint8, flags uint32, payload ...[]byte) []byte {
+	return box(typ, append([][]byte{u32(uint32(version)<<24 | flags)}, payload...)...)
+}
+
+// 테스트 영상은 90kHz timescale 에서 화면마다 3600(40ms) 이다.
+const (
+	testTimescale = 90000
+	testDelta     = 3600
+)
+
+var (
+	testSPS = []byte{0x67, 0x42, 0x00, 0x1f}
+	testPPS = []byte{0x68, 0xce, 0x3c, 0x80}
+)
+
+// testSample은 4 바이트 길이를 앞에 붙인 NAL 하나짜리 sample 이다. 7 바이트라서 stz2 4 비트에도 들어간다.
+func testSample(i int, sync bool) []byte {
+	nal := []byte{0x41, firstSlice, byte(i)}
+	if sync {
+		nal[0] = 0x65
+	}
+	return append(u32(uint32(len(nal))), nal...)
+}
+
+func avc1() []byte {
+	avcC := box("avcC", []byte{1, 66, 0, 31, 0xff, 0xe1}, u16(uint16(len(testSPS))), testSPS, []byte{1}, u16(uint16(len(testPPS))), testPPS)
+	// VisualSampleEntry 의 고정 필드 78 바이트
+	return box("avc1", make([]byte, 78), avcC)
+}
+
+func trak(edts []byte, stbl ...[]byte) []byte {
+	return box("trak",
+		fullBox("tkhd", 0, 3, u32(0), u32(0), u32(1), make([]byte, 68)),
+		edts,
+		box("mdia",
+			fullBox("mdhd", 0, 0, u32(0), u32(0), u32(testTimescale), u32(0), u32(0)),
+			fullBox("hdlr", 0, 0, u32(0), []byte("vide"), make([]byte, 13)),
+			box("minf", box("stbl", append([][]byte{fullBox("stsd", 0, 0, u32(1), avc1())}, stbl...)...)),
+		),
+	)
+}
+
+func moov(tracks ...[]byte) []byte {
+	// movie timescale 은 1000
+	return box("moov", append([][]byte{fullBox("mvhd", 0, 0, u32(0), u32(0), u32(1000), u32(0), make([]byte, 80))}, tracks...)...)
+}
+
+type progressiveOptions struct {
+	// 0 이면 stsz, 4/8/16 이면 그 field size 의 stz2
+	stz2  int
+	co64  bool
+	ctts  int32
+	edits []mp4Edit
+	// elst version
+	elst64 bool
+}
+
+// buildProgressiveMP4는 sync 가 0, 3 인 sample 5 개를 chunk 2 개(3, 2) 에 나눠 담는다.
+// chunk 사이에 쓰레기 바이트를 넣어서 chunk offset 을 잘못 읽으면 드러나게 한다.
+func buildProgressiveMP4(o progressiveOptions) []byte {
+	const count = 5
+	ftyp := box("ftyp", []byte("isom"), u32(0), []byte("isomavc1"))
+
+	var chunk1, chunk2 []byte
+	for i := 0; i < count; i++ {
+		if i < 3 {
+			chunk1 = append(chunk1, testSample(i, i%3 == 0)...)
+		} else {
+			chunk2 = append(chunk2, testSample(i, i%3 == 0)...)
+		}
+	}
+	garbage := []byte{0xde, 0xad, 0xbe, 0xef}
+	mdat := box("mdat", chunk1, garbage, chunk2)
+	offset1 := uint64(len(ftyp) + 8)
+	offset2 := offset1 + uint64(len(chunk1)+len(garbage))
+
+	var sizes []byte
+	switch o.stz2 {
+	case 0:
+		sizes = fullBox("stsz", 0, 0, u32(0), u32(count), u32(7), u32(7), u32(7), u32(7), u32(7))
+	case 4:
+		sizes = fullBox("stz2", 0, 0, []byte{0, 0, 0, 4}, u32(count), []byte{0x77, 0x77, 0x70})
+	case 8:
+		sizes = fullBox("stz2", 0, 0, []byte{0, 0, 0, 8}, u32(count), []byte{7, 7, 7, 7, 7})
+	case 16:
+		sizes = fullBox("stz2", 0, 0, []byte{0, 0, 0, 16}, u32(count), u16(7), u16(7), u16(7), u16(7), u16(7))
+	}
+	offsets := fullBox("stco", 0, 0, u32(2), u32(uint32(offset1)), u32(uint32(offset2)))
+	if o.co64 {
+		offsets = fullBox("co64", 0, 0, u32(2), u64(offset1), u64(offset2))
+	}
+	stbl := [][]byte{
+		fullBox("stts", 0, 0, u32(1), u32(count), u32(testDelta)),
+		fullBox("stss", 0, 0, u32(2), u32(1), u32(4)),
+		sizes,
+		fullBox("stsc", 0, 0, u32(2), u32(1), u32(3), u32(1), u32(2), u32(2), u32(1)),
+		offsets,
+	}
+	if o.ctts != 0 {
+		stbl = append(stbl, fullBox("ctts", 0, 0, u32(1), u32(count), u32(uint32(o.ctts))))
+	}
+
+	var edts []byte
+	if len(o.edits) > 0 {
+		var entries [][]byte
+		for _, edit := range o.edits {
+			if o.elst64 {
+				entries = append(entries, u64(edit.segmentDuration), u64(uint64(edit.mediaTime)), u32(1<<16))
+			} else {
+				entries = append(entries, u32(uint32(edit.segmentDuration)), u32(uint32(edit.mediaTime)), u32(1<<16))
+			}
+		}
+		version := uint8(0)
+		if o.elst64 {
+			version = 1
+		}
+		edts = box("edts", fullBox("elst", version, 0, append([][]byte{u32(uint32(len(o.edits)))}, entries...)...))
+	}
+
+	return bytes.Join([][]byte{ftyp, mdat, moov(trak(edts, stbl...))}, nil)
+}
+
+// buildFragmentedMP4는 sample 3 개와 2 개짜리 fragment 두 개다. 두 fragment 모두 첫 sample 만 sync 다.
+// 첫 fragment 는 trun 에 sample 마다 길이와 크기를 적고, 두 번째는 tfhd 기본값을 쓰고 tfdt 가 없다.
+func buildFragmentedMP4(firstDecodeTime uint64) []byte {
+	ftyp := box("ftyp", []byte("iso6"), u32(0), []byte("iso6avc1"))
+	emptyStbl := [][]byte{
+		fullBox("stts", 0, 0, u32(0)),
+		fullBox("stsc", 0, 0, u32(0)),
+		fullBox("stsz", 0, 0, u32(0), u32(0)),
+		fullBox("stco", 0, 0, u32(0)),
+	}
+	// trex: track 1, 기본은 non-sync 화면
+	mvex := box("mvex", fullBox("trex", 0, 0, u32(1), u32(1), u32(testDelta), u32(0), u32(sampleIsNonSyncSample)))
+	header := bytes.Join([][]byte{ftyp, box("moov", fullBox("mvhd", 0, 0, u32(0), u32(0), u32(1000), u32(0), make([]byte, 80)), trak(nil, emptyStbl...), mvex)}, nil)
+
+	fragment := func(sequence uint32, first int, count int, dataOffset uint32) []byte {
+		var samples [][]byte
+		for i := first; i < first+count; i++ {
+			samples = append(samples, testSample(i, i == first))
+		}
+		var traf []byte
+		if sequence == 1 {
+			var entries [][]byte
+			for range samples {
+				entries = append(entries, u32(testDelta), u32(7))
+			}
+			traf = box("traf",
+				fullBox("tfhd", 0, 0x020000, u32(1)),
+				fullBox("tfdt", 1, 0, u64(firstDecodeTime)),
+				fullBox("trun", 0, trunDataOffset|trunFirstSampleFlags|trunSampleDuration|trunSampleSize,
+					append([][]byte{u32(uint32(count)), u32(dataOffset), u32(0)}, entries...)...),
+			)
+		} else {
+			traf = box("traf",
+				fullBox("tfhd", 0, tfhdDefaultDuration|tfhdDefaultSize, u32(1), u32(testDelta), u32(7)),
+				fullBox("trun", 0, trunDataOffset|trunFirstSampleFlags, u32(uint32(count)), u32(dataOffset), u32(0)),
+			)
+		}
+		moof := box("moof", fullBox("mfhd", 0, 0, u32(sequence)), traf)
+		return append(moof, box("mdat", samples...)...)
+	}
+	// data_offset 은 moof 시작에서 mdat 내용까지다. 값을 바꿔도 moof 길이는 같다.
+	first := fragment(1, 0, 3, 0)
+	first = fragment(1, 0, 3, uint32(len(first)-3*7))
+	second := fragment(2, 3, 2, 0)
+	second = fragment(2, 3, 2, uint32(len(second)-2*7))
+	return bytes.Join([][]byte{header, first, second}, nil)
+}
+
+func writeTestFile(t testing.TB, data []byte) string {
+	t.Helper()
+	fileName := filepath.Join(t.TempDir(), "test.mp4")
+	if err := os.WriteFile(fileName, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+// checkMP4Frames는 clip 이 sample first 부터 차례로 40ms 화면이고, sync 인 sample 에만 SPS/PPS 를 붙였는지 본다.
+func checkMP4Frames(t *testing.T, clip *Clip, first int, keyframes ...int) {
+	t.Helper()
+	for n, frame := range clip.Frames {
+		i := first + n
+		sync := false
+		for _, k := range keyframes {
+			sync = sync || k == i
+		}
+		want := Frame{Data: annexB(testSample(i, sync)[4:]), Duration: 40 * time.Millisecond, Keyframe: sync}
+		if sync {
+			want.ParameterSets = annexB(testSPS, testPPS)
+		}
+		if !bytes.Equal(frame.Data, want.Data) || frame.Duration != want.Duration || frame.Keyframe != want.Keyframe || !bytes.Equal(frame.ParameterSets, want.ParameterSets) {
+			t.Errorf("frame %d = %+v, want %+v", n, frame, want)
+		}
+	}
+}
+
+func TestLoadMP4(t *testing.T) {
+	tests := []struct {
+		name    string
+		options progressiveOptions
+		delay   time.Duration
+		first   int
+		frames  int
+	}{
+		{name: "stsz", frames: 5},
+		{name: "stz2 4 bits", options: progressiveOptions{stz2: 4}, frames: 5},
+		{name: "stz2 8 bits", options: progressiveOptions{stz2: 8}, frames: 5},
+		{name: "stz2 16 bits", options: progressiveOptions{stz2: 16}, frames: 5},
+		{name: "co64", options: progressiveOptions{co64: true}, frames: 5},
+		// 빈 편집 500ms 뒤 sample 1 부터 120ms. sample 1 은 sync 가 아니라서 0 부터 보내고, 14400(sample 4) 에서 끝난다.
+		{name: "edit list", options: progressiveOptions{edits: []mp4Edit{{segmentDuration: 500, mediaTime: -1}, {segmentDuration: 120, mediaTime: testDelta}}}, delay: 500 * time.Millisecond, frames: 4},
+		{name: "edit list version 1", options: progressiveOptions{elst64: true, edits: []mp4Edit{{segmentDuration: 500, mediaTime: -1}, {segmentDuration: 120, mediaTime: testDelta}}}, delay: 500 * time.Millisecond, frames: 4},
+		// B-frame 때문에 pts 가 2 화면 밀려 있으면 edit list 의 media_time 은 pts 로 센다.
+		{name: "ctts", options: progressiveOptions{ctts: 2 * testDelta, edits: []mp4Edit{{mediaTime: 5 * testDelta}}}, first: 3, frames: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clip, err := LoadMP4(writeTestFile(t, buildProgressiveMP4(tt.options)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if clip.MimeType != "video/H264" {
+				t.Fatalf("mime type = %s", clip.MimeType)
+			}
+			if len(clip.Frames) != tt.frames {
+				t.Fatalf("%d frames, want %d", len(clip.Frames), tt.frames)
+			}
+			if clip.Offset(0) != tt.delay {
+				t.Fatalf("first frame at %v, want %v", clip.Offset(0), tt.delay)
+			}
+			checkMP4Frames(t, clip, tt.first, 0, 3)
+		})
+	}
+}
+
+func TestLoadMP4EditListAfterLastSample(t *testing.T) {
+	_, err := LoadMP4(writeTestFile(t, buildProgressiveMP4(progressiveOptions{edits: []mp4Edit{{mediaTime: 10 * testDelta}}})))
+	if err == nil {
+		t.Fatal("LoadMP4() succeeded with an edit after the last sample")
+	}
+}
+
+func TestLoadFragmentedMP4(t *testing.T) {
+	for _, decodeTime := range []uint64{0, 1 << 33} {
+		clip, err := LoadMP4(writeTestFile(t, buildFragmentedMP4(decodeTime)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(clip.Frames) != 5 || clip.Duration() != 200*time.Millisecond {
+			t.Fatalf("tfdt %d: %d frames, %v, want 5 frames, 200ms", decodeTime, len(clip.Frames), clip.Duration())
+		}
+		checkMP4Frames(t, clip, 0, 0, 3)
+	}
+}
+
+// patch는 data 에서 typ box 의 내용 at 번째 바이트부터 values 를 덮어쓴다.
+func patch(data []byte, typ string, at int, values ...uint32) []byte {
+	data = bytes.Clone(data)
+	i := bytes.LastIndex(data, []byte(typ)) + 4 + at
+	for _, v := range values {
+		binary.BigEndian.PutUint32(data[i:], v)
+		i += 4
+	}
+	return data
+}
+
+func TestLoadMP4Malformed(t *testing.T) {
+	progressive := buildProgressiveMP4(progressiveOptions{})
+	fragmented := buildFragmentedMP4(0)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		// 메모리를 다 쓰기 전에 멈춰야 한다.
+		{name: "constant stsz count", data: patch(progressive, "stsz", 4, 7, 0x0fffffff)},
+		{name: "trun count", data: patch(fragmented, "trun", 4, 0x0fffffff)},
+		{name: "sample size", data: patch(progressive, "stsz", 12, 0xfffffff0)},
+		{name: "chunk offset", data: patch(progressive, "stco", 8, 0x7ffffff0)},
+		{name: "stsc without chunks", data: patch(progressive, "stco", 4, 0)},
+		{name: "avcC", data: patch(progressive, "avcC", 6, 0xffff0000)},
+		{name: "no moov", data: progressive[:bytes.Index(progressive, []byte("moov"))-4]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadMP4(writeTestFile(t, tt.data)); err == nil {
+				t.Fatal("LoadMP4() succeeded")
+			}
+		})
+	}
+}
+
+func TestLoadMP4NoTrack(t *testing.T) {
+	if _, err := LoadMP4Audio(writeTestFile(t, buildProgressiveMP4(progressiveOptions{}))); err == nil {
+		t.Fatal("LoadMP4Audio() found an audio track in a video only file")
+	}
+}
+
+func TestReadBoxHeaders(t *testing.T) {
+	data := buildFragmentedMP4(0)
+	headers, err := readBoxHeaders(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, header := range headers {
+		types = append(types, header.typ)
+	}
+	if got := strings.Join(types, " "); got != "ftyp moov moof mdat moof mdat" {
+		t.Fatalf("boxes = %v", types)
+	}
+
+	// 64 비트 크기와 파일 끝까지인 box
+	large := append(append(u32(1), []byte("free")...), u64(20)...)
+	large = append(large, 0, 0, 0, 0)
+	large = append(large, append(u32(0), []byte("mdat")...)...)
+	large = append(large, 1, 2, 3)
+	headers, err = readBoxHeaders(bytes.NewReader(large), int64(len(large)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 2 || headers[0].headerSize != 16 || headers[0].size != 20 || headers[1].size != 11 {
+		t.Fatalf("headers = %+v", headers)
+	}
+
+	for name, data := range map[string][]byte{
+		"smaller than the header": append(u32(4), []byte("free")...),
+		"past the end":            append(u32(100), []byte("free")...),
+		"short large size":        append(u32(1), []byte("free")...),
+	} {
+		if _, err := readBoxHeaders(bytes.NewReader(data), int64(len(data))); err == nil {
+			t.Errorf("%s: readBoxHeaders() succeeded", name)
+		}
+	}
+}
+
+func TestChildren(t *testing.T) {
+	parent := mp4Box{typ: "moov", data: bytes.Join([][]byte{box("mvhd", []byte{1}), box("trak", box("tkhd")), box("trak")}, nil)}
+	if traks := parent.allChildren("trak"); len(traks) != 2 || len(traks[0].data) != 8 {
+		t.Fatalf("allChildren() = %v", traks)
+	}
+	if tkhd, ok := parent.child("trak", "tkhd"); !ok || tkhd.typ != "tkhd" {
+		t.Fatal("child() did not find trak/tkhd")
+	}
+	if _, ok := parent.child("trak", "mdia"); ok {
+		t.Fatal("child() found a missing box")
+	}
+
+	for name, data := range map[string][]byte{
+		"too large":   append(u32(9), []byte("free")...),
+		"too small":   append(u32(7), []byte("free")...),
+		"short large": append(u32(1), []byte("free")...),
+	} {
+		if _, err := (mp4Box{data: data}).children(0); err == nil {
+			t.Errorf("%s: children() succeeded", name)
+		}
+	}
+	if _, err := (mp4Box{data: []byte{1, 2}}).children(4); err == nil {
+		t.Error("children() skipped past the end")
+	}
+}
+
+// walk는 box 를 container 처럼 모두 나눠 본다.
+func walk(b mp4Box, depth int) {
+	if depth == 0 {
+		return
+	}
+	for _, skip := range []int{0, 8} {
+		children, err := b.children(skip)
+		if err != nil {
+			continue
+		}
+		for _, c := range children {
+			walk(c, depth-1)
+		}
+	}
+}
+
+func FuzzReadBoxHeaders(f *testing.F) {
+	f.Add(buildProgressiveMP4(progressiveOptions{ctts: testDelta, edits: []mp4Edit{{segmentDuration: 500, mediaTime: -1}}}))
+	f.Add(buildProgressiveMP4(progressiveOptions{stz2: 4, co64: true}))
+	f.Add(buildFragmentedMP4(0))
+	f.Add(append(u32(1), []byte("moov")...))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		headers, err := readBoxHeaders(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return
+		}
+		for _, header := range headers {
+			if header.size < header.headerSize || header.offset+header.size > int64(len(data)) {
+				t.Fatalf("header %+v outside of %d bytes", header, len(data))
+			}
+			box, err := header.read(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			walk(box, 8)
+		}
+	})
+}
+
+// FuzzLoadMP4는 이상한 파일이 resource server 를 멈추지 않고 오류가 되는지 본다.
+func FuzzLoadMP4(f *testing.F) {
+	f.Add(buildProgressiveMP4(progressiveOptions{ctts: testDelta, edits: []mp4Edit{{segmentDuration: 500, mediaTime: -1}}}))
+	f.Add(buildProgressiveMP4(progressiveOptions{stz2: 4, co64: true}))
+	f.Add(buildFragmentedMP4(0))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fileName := writeTestFile(t, data)
+		LoadMP4(fileName)
+		LoadMP4Audio(fileName)
+	})
+}
@@ -0,0 +1,205 @@
+package playout
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var errShortBox = errors.New("mp4: unexpected end of box")
+
+// mp4Box는 ISO BMFF box 하나다. data 는 header 를 뺀 내용이다.
+type mp4Box struct {
+	typ  string
+	data []byte
+}
+
+// boxHeader는 파일 안의 box 위치다. mdat 처럼 큰 box 는 내용을 읽지 않고 위치만 본다.
+type boxHeader struct {
+	typ        string
+	offset     int64
+	headerSize int64
+	size       int64
+}
+
+// readBoxHeaders는 파일의 최상위 box 들을 읽는다.
+func readBoxHeaders(r io.ReaderAt, fileSize int64) ([]boxHeader, error) {
+	var headers []boxHeader
+	buf := make([]byte, 16)
+	for offset := int64(0); offset+8 <= fileSize; {
+		if _, err := r.ReadAt(buf[:8], offset); err != nil {
+			return nil, fmt.Errorf("mp4: failed to read box header at %d: %w", offset, err)
+		}
+		header := boxHeader{
+			typ:        string(buf[4:8]),
+			offset:     offset,
+			headerSize: 8,
+			size:       int64(binary.BigEndian.Uint32(buf)),
+		}
+		switch header.size {
+		case 0:
+			// 파일 끝까지
+			header.size = fileSize - offset
+		case 1:
+			if _, err := r.ReadAt(buf[8:16], offset+8); err != nil {
+				return nil, fmt.Errorf("mp4: failed to read large box size at %d: %w", offset, err)
+			}
+			header.size = int64(binary.BigEndian.Uint64(buf[8:16]))
+			header.headerSize = 16
+		}
+		if header.size < header.headerSize || offset+header.size > fileSize {
+			return nil, fmt.Errorf("mp4: invalid %s box size %d at %d", header.typ, header.size, offset)
+		}
+		headers = append(headers, header)
+		offset += header.size
+	}
+	return headers, nil
+}
+
+func (h boxHeader) read(r io.ReaderAt) (mp4Box, error) {
+	data := make([]byte, h.size-h.headerSize)
+	if len(data) == 0 {
+		// 파일 끝의 빈 box 를 ReadAt 하면 EOF 를 돌려주는 reader 가 있다.
+		return mp4Box{typ: h.typ}, nil
+	}
+	if _, err := r.ReadAt(data, h.offset+h.headerSize); err != nil {
+		return mp4Box{}, fmt.Errorf("mp4: failed to read %s box: %w", h.typ, err)
+	}
+	return mp4Box{typ: h.typ, data: data}, nil
+}
+
+// children은 container box 의 내용을 box 들로 나눈다. skip 은 자식 box 앞의 고정 필드 길이다.
+func (b mp4Box) children(skip int) ([]mp4Box, error) {
+	if len(b.data) < skip {
+		return nil, errShortBox
+	}
+	var boxes []mp4Box
+	data := b.data[skip:]
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, errShortBox
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return nil, fmt.Errorf("mp4: invalid %s box size %d", typ, size)
+		}
+		boxes = append(boxes, mp4Box{typ: typ, data: data[headerSize:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+// child는 경로를 따라 처음 나오는 box 를 찾는다. 예: child("mdia", "minf", "stbl")
+func (b mp4Box) child(path ...string) (mp4Box, bool) {
+	current := b
+	for _, typ := range path {
+		children, err := current.children(0)
+		if err != nil {
+			return mp4Box{}, false
+		}
+		found := false
+		for _, c := range children {
+			if c.typ == typ {
+				current = c
+				found = true
+				break
+			}
+		}
+		if !found {
+			return mp4Box{}, false
+		}
+	}
+	return current, true
+}
+
+// allChildren은 바로 아래의 typ box 를 모두 찾는다.
+func (b mp4Box) allChildren(typ string) []mp4Box {
+	children, err := b.children(0)
+	if err != nil {
+		return nil
+	}
+	var found []mp4Box
+	for _, c := range children {
+		if c.typ == typ {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// boxReader는 box 내용을 big endian 으로 읽는다. 넘치면 0 을 돌려주고 err 를 남긴다.
+type boxReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func newBoxReader(b mp4Box) *boxReader {
+	return &boxReader{data: b.data}
+}
+
+func (r *boxReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || r.pos+n > len(r.data) {
+		r.err = errShortBox
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *boxReader) skip(n int) {
+	r.bytes(n)
+}
+
+func (r *boxReader) u8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *boxReader) u16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *boxReader) u32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *boxReader) u64() uint64 {
+	if b := r.bytes(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// fullBox는 FullBox 의 version 과 flags 를 읽는다.
+func (r *boxReader) fullBox() (version uint8, flags uint32) {
+	v := r.u32()
+	return uint8(v >> 24), v & 0xffffff
+}
+
+// versioned는 version 1 이면 64 비트, 아니면 32 비트 값을 읽는다.
+func (r *boxReader) versioned(version uint8) uint64 {
+	if version == 1 {
+		return r.u64()
+	}
+	return uint64(r.u32())
+}
@@ -0,0 +1,115 @@
+package playout
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// LoadOgg는 Ogg Opus 파일을 Opus packet 단위로 읽는다. (RFC 7845)
+// 한 page 에 packet 이 여러 개 있거나 packet 이 page 를 넘어가도 packet 하나가 한 sample 이 된다.
+// packet 길이는 granule position 대신 TOC byte 로 정한다. 첫 logical stream 만 읽는다.
+func LoadOgg(fileName string) (*Clip, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audio file: %w", err)
+	}
+
+	var frames []Frame
+	var packet []byte
+	var serial uint32
+	headers := 0
+	for offset := 0; offset < len(data); {
+		// capture_pattern, version, header_type, granule_position, serial, sequence, CRC, page_segments
+		const pageHeaderSize = 27
+		if offset+pageHeaderSize > len(data) || !bytes.Equal(data[offset:offset+4], []byte("OggS")) {
+			return nil, fmt.Errorf("ogg: invalid page at %d in %s", offset, fileName)
+		}
+		header := data[offset : offset+pageHeaderSize]
+		pageSerial := binary.LittleEndian.Uint32(header[14:18])
+		segments := int(header[26])
+		if offset+pageHeaderSize+segments > len(data) {
+			return nil, fmt.Errorf("ogg: truncated page at %d in %s", offset, fileName)
+		}
+		table := data[offset+pageHeaderSize : offset+pageHeaderSize+segments]
+		body := offset + pageHeaderSize + segments
+		if offset == 0 {
+			serial = pageSerial
+		}
+
+		for _, size := range table {
+			if body+int(size) > len(data) {
+				return nil, fmt.Errorf("ogg: truncated page at %d in %s", offset, fileName)
+			}
+			if pageSerial == serial {
+				packet = append(packet, data[body:body+int(size)]...)
+			}
+			body += int(size)
+			// 255 보다 작은 segment 가 packet 의 끝이다.
+			if size == 255 || pageSerial != serial {
+				continue
+			}
+
+			// OpusHead, OpusTags 다음부터 audio packet 이다.
+			switch {
+			case headers == 0:
+				if !bytes.HasPrefix(packet, []byte("OpusHead")) {
+					return nil, fmt.Errorf("ogg: %s is not an Opus stream", fileName)
+				}
+				headers++
+			case headers == 1:
+				headers++
+			default:
+				duration, err := opusPacketDuration(packet)
+				if err != nil {
+					return nil, fmt.Errorf("ogg: packet %d in %s: %w", len(frames), fileName, err)
+				}
+				frames = append(frames, Frame{Data: packet, Duration: duration, Keyframe: true})
+			}
+			packet = nil
+		}
+		offset = body
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no packets in audio file: %s", fileName)
+	}
+	return newClip(webrtc.MimeTypeOpus, 0, frames), nil
+}
+
+// opusPacketDuration은 Opus packet 의 TOC byte 로 길이를 구한다. (RFC 6716 3.1)
+func opusPacketDuration(packet []byte) (time.Duration, error) {
+	if len(packet) == 0 {
+		return 0, errors.New("empty Opus packet")
+	}
+	config := packet[0] >> 3
+	var frameSize time.Duration
+	switch {
+	case config < 12: // SILK
+		frameSize = []time.Duration{10, 20, 40, 60}[config%4] * time.Millisecond
+	case config < 16: // Hybrid
+		frameSize = []time.Duration{10, 20}[config%2] * time.Millisecond
+	default: // CELT
+		frameSize = []time.Duration{2500, 5000, 10000, 20000}[config%4] * time.Microsecond
+	}
+
+	frames := 1
+	switch packet[0] & 0x03 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(packet) < 2 {
+			return 0, errors.New("Opus packet without frame count")
+		}
+		frames = int(packet[1] & 0x3f)
+		// frame 개수 M 은 0 이면 안 된다. (RFC 6716 3.2.5)
+		if frames == 0 {
+			return 0, errors.New("Opus packet with no frames")
+		}
+	}
+	return frameSize * time.Duration(frames), nil
+}
@@ -0,0 +1,131 @@
+package playout
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// oggPage는 lacing 값 그대로 page 하나를 만든다. LoadOgg 는 CRC 를 보지 않아서 0 으로 둔다.
+func oggPage(serial uint32, lacing []byte, body ...[]byte) []byte {
+	header := make([]byte, 27)
+	copy(header, "OggS")
+	binary.LittleEndian.PutUint32(header[14:], serial)
+	header[26] = byte(len(lacing))
+	return bytes.Join(append([][]byte{header, lacing}, body...), nil)
+}
+
+// oggPackets는 packet 들을 한 page 에 담는다.
+func oggPackets(serial uint32, packets ...[]byte) []byte {
+	var lacing []byte
+	for _, packet := range packets {
+		lacing = append(lacing, bytes.Repeat([]byte{255}, len(packet)/255)...)
+		lacing = append(lacing, byte(len(packet)%255))
+	}
+	return oggPage(serial, lacing, packets...)
+}
+
+var (
+	opusHead = append([]byte("OpusHead"), 1, 2, 0, 0, 0x80, 0xbb, 0, 0, 0, 0, 0)
+	opusTags = append([]byte("OpusTags"), make([]byte, 8)...)
+)
+
+// buildOgg는 header page 두 장 뒤에 packets 를 한 page 에 담는다.
+func buildOgg(packets ...[]byte) []byte {
+	return bytes.Join([][]byte{oggPackets(1, opusHead), oggPackets(1, opusTags), oggPackets(1, packets...)}, nil)
+}
+
+func TestLoadOgg(t *testing.T) {
+	// 20ms CELT packet 이 page 두 장에 걸쳐 있다.
+	long := append([]byte{0x98}, bytes.Repeat([]byte{0xaa}, 299)...)
+	data := bytes.Join([][]byte{
+		oggPackets(1, opusHead),
+		// 다른 logical stream 은 건너뛴다.
+		oggPackets(2, []byte("OpusHead")),
+		oggPackets(1, opusTags),
+		oggPackets(1, []byte{0x98, 1}, []byte{0x09, 2}),
+		oggPackets(2, []byte{0x98, 3}),
+		oggPage(1, []byte{255}, long[:255]),
+		oggPage(1, []byte{45}, long[255:]),
+	}, nil)
+
+	clip, err := LoadOgg(writeTestFile(t, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clip.MimeType != "audio/opus" {
+		t.Fatalf("mime type = %s", clip.MimeType)
+	}
+	want := []Frame{
+		{Data: []byte{0x98, 1}, Duration: 20 * time.Millisecond, Keyframe: true},
+		{Data: []byte{0x09, 2}, Duration: 40 * time.Millisecond, Keyframe: true},
+		{Data: long, Duration: 20 * time.Millisecond, Keyframe: true},
+	}
+	if len(clip.Frames) != len(want) {
+		t.Fatalf("%d frames, want %d", len(clip.Frames), len(want))
+	}
+	for i, frame := range clip.Frames {
+		if !bytes.Equal(frame.Data, want[i].Data) || frame.Duration != want[i].Duration || !frame.Keyframe {
+			t.Errorf("frame %d = %+v, want %+v", i, frame, want[i])
+		}
+	}
+	if clip.Duration() != 80*time.Millisecond {
+		t.Fatalf("duration = %v, want 80ms", clip.Duration())
+	}
+}
+
+func TestLoadOggMalformed(t *testing.T) {
+	valid := buildOgg([]byte{0x98})
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "not ogg", data: []byte("RIFF0000WAVEfmt ")},
+		{name: "truncated header", data: valid[:20]},
+		{name: "truncated body", data: valid[:len(valid)-1]},
+		{name: "not opus", data: oggPackets(1, []byte("\x01vorbis"))},
+		{name: "no packets", data: buildOgg()},
+		// frame 이 없는 packet 으로 길이 0 인 clip 을 만들면 안 된다.
+		{name: "no frames", data: buildOgg([]byte{0x9b, 0})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadOgg(writeTestFile(t, tt.data)); err == nil {
+				t.Fatal("LoadOgg() succeeded")
+			}
+		})
+	}
+}
+
+func TestOpusPacketDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		packet  []byte
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "SILK 10ms", packet: []byte{0x00}, want: 10 * time.Millisecond},
+		{name: "SILK 60ms", packet: []byte{0x18}, want: 60 * time.Millisecond},
+		{name: "Hybrid two frames", packet: []byte{0x6a}, want: 40 * time.Millisecond},
+		{name: "CELT 2.5ms", packet: []byte{0x80}, want: 2500 * time.Microsecond},
+		{name: "CELT two frames", packet: []byte{0x99}, want: 40 * time.Millisecond},
+		{name: "CELT three frames", packet: []byte{0x83, 0x83}, want: 7500 * time.Microsecond},
+		{name: "empty", packet: nil, wantErr: true},
+		{name: "code 3 without count", packet: []byte{0x83}, wantErr: true},
+		{name: "code 3 with no frames", packet: []byte{0x83, 0x80}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := opusPacketDuration(tt.packet)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("opusPacketDuration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("opusPacketDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
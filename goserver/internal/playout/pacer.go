@@ -0,0 +1,122 @@
+package playout
+
+import (
+	"context"
+	"time"
+)
+
+// Pacer는 재생 위치를 monotonic 시계로 잰다.
+// 화면마다 sleep 한 시간을 더하지 않고 시작 시각에서의 절대 위치로 기다려서 오차가 쌓이지 않는다.
+type Pacer struct {
+	start    time.Time
+	position time.Duration
+}
+
+// NewPacer는 지금을 재생 위치 position 으로 삼는다.
+// 여러 서버가 같은 장면을 보내려면 공통 기준 시각에서 지난 시간을 넘긴다.
+func NewPacer(position time.Duration) *Pacer {
+	return &Pacer{start: time.Now(), position: position}
+}
+
+func (p *Pacer) Position() time.Duration {
+	return p.position + time.Since(p.start)
+}
+
+// Wait는 재생 위치가 position 이 될 때까지 기다린다.
+func (p *Pacer) Wait(ctx context.Context, position time.Duration) error {
+	wait := time.Until(p.start.Add(position - p.position))
+	if wait <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type PlayOptions struct {
+	// 끝나면 처음부터 다시 재생한다.
+	Loop bool
+	// 반복 길이. 0 이면 clip 길이다. 영상과 소리를 같은 길이로 반복해서 서로 어긋나지 않게 한다.
+	// clip 이 더 길면 뒤는 자르고, 더 짧으면 다음 반복까지 보내지 않는다.
+	Period time.Duration
+	// 이보다 늦어지면 밀린 화면을 버리고 현재 위치로 건너뛴다. 0 이면 건너뛰지 않는다.
+	MaxLag time.Duration
+	// MaxLag 로 건너뛴 뒤 다음 화면을 쓰기 전에 부른다. 영상은 건너뛴 화면을 참조하는 화면이
+	// 깨지지 않도록 여기서 writer 에 keyframe 을 요청한다. nil 이면 부르지 않는다.
+	OnSkip func()
+}
+
+// Play는 pacer 의 현재 위치에 맞는 화면부터 시각에 맞춰 write 를 부른다.
+// ctx 가 끝나면 ctx.Err() 를, Loop 가 아니면 마지막 화면 뒤에 nil 을 돌려준다.
+// 같은 pacer 로 여러 clip 을 동시에 재생하면 같은 시계를 따른다.
+func Play(ctx context.Context, clip *Clip, pacer *Pacer, options PlayOptions, write func(i int) error) error {
+	period := clip.Duration()
+	if options.Loop && options.Period > 0 {
+		period = options.Period
+	}
+	end := min(period, clip.Duration())
+
+	var loopStart time.Duration
+	var i int
+	// seek는 now 에 보여야 하는 화면으로 옮긴다. 더 보낼 화면이 없으면 false 다.
+	seek := func(now time.Duration) bool {
+		if options.Loop {
+			loopStart = loopStartAt(now, period)
+		}
+		if now < loopStart {
+			// 반복하지 않으면 0 이전에는 첫 화면을 기다린다.
+			i = 0
+			return true
+		}
+		if now-loopStart >= end {
+			if !options.Loop {
+				return false
+			}
+			loopStart += period
+			i = 0
+			return true
+		}
+		i = max(clip.IndexAt(now-loopStart), 0)
+		return true
+	}
+	if !seek(pacer.Position()) {
+		return nil
+	}
+
+	for {
+		due := loopStart + clip.Offset(i)
+		if err := pacer.Wait(ctx, due); err != nil {
+			return err
+		}
+
+		// 이미 지나간 화면만 버린다. 건너뛴 뒤의 화면은 아직 보이는 중이라 다시 걸리지 않는다.
+		if now := pacer.Position(); options.MaxLag > 0 && now-due > options.MaxLag && now >= due+clip.Frames[i].Duration {
+			if !seek(now) {
+				return nil
+			}
+			if options.OnSkip != nil {
+				options.OnSkip()
+			}
+			continue
+		}
+
+		if err := write(i); err != nil {
+			return err
+		}
+
+		i++
+		if i == len(clip.Frames) || clip.Offset(i) >= period {
+			if !options.Loop {
+				return nil
+			}
+			i = 0
+			loopStart += period
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package playout
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+var errEnough = errors.New("enough")
+
+// play는 write 로 받은 화면 번호를 limit 개까지 모은다. limit 이 0 이면 Play 가 끝날 때까지 모은다.
+func play(t *testing.T, clip *Clip, pacer *Pacer, options PlayOptions, limit int) []int {
+	t.Helper()
+	var indices []int
+	err := Play(context.Background(), clip, pacer, options, func(i int) error {
+		indices = append(indices, i)
+		if len(indices) == limit {
+			return errEnough
+		}
+		return nil
+	})
+	if limit > 0 && !errors.Is(err, errEnough) {
+		t.Fatalf("Play() = %v, want the write error", err)
+	}
+	if limit == 0 && err != nil {
+		t.Fatalf("Play() = %v", err)
+	}
+	return indices
+}
+
+func TestPlay(t *testing.T) {
+	const ms = time.Millisecond
+	tests := []struct {
+		name     string
+		position time.Duration
+		options  PlayOptions
+		limit    int
+		want     []int
+	}{
+		{name: "once", want: []int{0, 1, 2, 3, 4}},
+		{name: "loop", options: PlayOptions{Loop: true}, limit: 12, want: []int{0, 1, 2, 3, 4, 0, 1, 2, 3, 4, 0, 1}},
+		{name: "shorter period", options: PlayOptions{Loop: true, Period: 3 * ms}, limit: 7, want: []int{0, 1, 2, 0, 1, 2, 0}},
+		{name: "longer period", options: PlayOptions{Loop: true, Period: 7 * ms}, limit: 7, want: []int{0, 1, 2, 3, 4, 0, 1}},
+		{name: "period without loop", options: PlayOptions{Period: 3 * ms}, want: []int{0, 1, 2, 3, 4}},
+		{name: "middle", position: 2500 * time.Microsecond, want: []int{2, 3, 4}},
+		{name: "after the end", position: 6 * ms},
+		{name: "loop after the end", position: 6 * ms, options: PlayOptions{Loop: true}, limit: 5, want: []int{1, 2, 3, 4, 0}},
+		{name: "negative", position: -3 * ms, want: []int{0, 1, 2, 3, 4}},
+		{name: "negative loop", position: -3 * ms, options: PlayOptions{Loop: true}, limit: 5, want: []int{2, 3, 4, 0, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clip := testClip(0, 5, ms)
+			if got := play(t, clip, NewPacer(tt.position), tt.options, tt.limit); !slices.Equal(got, tt.want) {
+				t.Fatalf("written %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlayWaitsForPeriod(t *testing.T) {
+	const ms = time.Millisecond
+	clip := testClip(0, 2, ms)
+	pacer := NewPacer(0)
+	var positions []time.Duration
+	Play(context.Background(), clip, pacer, PlayOptions{Loop: true, Period: 10 * ms}, func(i int) error {
+		positions = append(positions, pacer.Position())
+		if len(positions) == 3 {
+			return errEnough
+		}
+		return nil
+	})
+	// clip 이 끝나도 다음 반복은 period 뒤에 시작한다.
+	if positions[2] < 10*ms {
+		t.Fatalf("second loop started at %v, want after 10ms", positions[2])
+	}
+}
+
+func TestPlayMaxLag(t *testing.T) {
+	const ms = time.Millisecond
+	clip := testClip(0, 20, 2*ms)
+	skips := 0
+	skipped := false
+	var indices []int
+	options := PlayOptions{
+		MaxLag: 5 * ms,
+		OnSkip: func() {
+			skips++
+			skipped = true
+		},
+	}
+	err := Play(context.Background(), clip, NewPacer(0), options, func(i int) error {
+		if len(indices) > 0 && i != indices[len(indices)-1]+1 && !skipped {
+			t.Errorf("jumped to frame %d without OnSkip", i)
+		}
+		skipped = false
+		indices = append(indices, i)
+		if i == 2 {
+			time.Sleep(20 * ms)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 24ms 쯤에서 다시 시작하므로 밀린 화면은 버린다. 느린 기계에서는 더 건너뛸 수 있다.
+	if skips == 0 {
+		t.Fatal("OnSkip was not called")
+	}
+	if len(indices) >= 20-4 || !slices.IsSorted(indices) || indices[len(indices)-1] != 19 {
+		t.Fatalf("written %v, want frames in order to 19 without the ones during the sleep", indices)
+	}
+}
+
+func TestPlayCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	clip := testClip(0, 5, time.Hour)
+	err := Play(ctx, clip, NewPacer(0), PlayOptions{Loop: true}, func(i int) error {
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Play() = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,111 @@
+// Package playout은 영상 파일을 화면(access unit) 단위로 읽고 시각에 맞춰 보낸다.
+//
+// H.264 는 SPS 의 VUI timing 으로, IVF 는 프레임마다 적힌 timestamp 로, MP4 는 sample timestamp 와 edit list 로 화면 길이를 정한다.
+// Opus 소리도 packet 하나를 화면 하나처럼 Clip 으로 읽고, 영상과 같은 Pacer 로 보내서 입 모양과 맞춘다.
+// SPS/PPS/SEI 는 따로 화면 자리를 차지하지 않고 뒤따르는 slice 와 같은 화면으로 묶인다.
+// Pacer 는 시작할 때의 monotonic 시각에서 절대 위치로 기다리므로 오래 돌려도 밀리지 않는다.
+package playout
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// Frame은 한 번의 WriteSample 로 보낼 한 화면이다.
+type Frame struct {
+	Data     []byte
+	Duration time.Duration
+	Keyframe bool
+	// keyframe 화면에 SPS/PPS 가 없으면 그 앞에서 마지막으로 나온 것. 따로 보낼 때 앞에 붙인다.
+	ParameterSets []byte
+}
+
+// Clip은 메모리에 읽어 둔 영상이다.
+type Clip struct {
+	MimeType string
+	Frames   []Frame
+	// offsets[i] 는 i 번째 화면이 시작하는 위치다.
+	offsets  []time.Duration
+	duration time.Duration
+}
+
+// newClip은 delay 뒤에 첫 화면이 나오는 clip 을 만든다. delay 동안은 보낼 화면이 없다.
+func newClip(mimeType string, delay time.Duration, frames []Frame) *Clip {
+	clip := &Clip{MimeType: mimeType, Frames: frames, offsets: make([]time.Duration, len(frames)), duration: delay}
+	for i, frame := range frames {
+		clip.offsets[i] = clip.duration
+		clip.duration += frame.Duration
+	}
+	return clip
+}
+
+func (c *Clip) Duration() time.Duration {
+	return c.duration
+}
+
+// Offset은 i 번째 화면이 시작하는 위치다.
+func (c *Clip) Offset(i int) time.Duration {
+	return c.offsets[i]
+}
+
+// loopStartAt은 period 길이로 반복할 때 position 이 들어 있는 반복의 시작 위치다. 0 이전은 내림한다.
+func loopStartAt(position, period time.Duration) time.Duration {
+	start := position - position%period
+	if start > position {
+		start -= period
+	}
+	return start
+}
+
+// IndexAt은 처음부터 반복해서 재생할 때 position 에 보여야 하는 화면 번호다.
+// 첫 화면 전의 delay 중이면 -1 이다.
+func (c *Clip) IndexAt(position time.Duration) int {
+	inLoop := position - loopStartAt(position, c.duration)
+	return sort.Search(len(c.offsets), func(i int) bool { return c.offsets[i] > inLoop }) - 1
+}
+
+// keyframeAt은 i 이전(포함) 의 가장 가까운 keyframe 이다. 처음 앞은 끝에서부터 찾는다. 없으면 -1 이다.
+func (c *Clip) keyframeAt(i int) int {
+	n := len(c.Frames)
+	for k := 0; k < n; k++ {
+		index := (i - k + n) % n
+		if c.Frames[index].Keyframe {
+			return index
+		}
+	}
+	return -1
+}
+
+// untilKeyframe은 i 부터 다음 keyframe 앞까지의 길이다. 끝을 지나면 처음부터 이어서 센다.
+func (c *Clip) untilKeyframe(i int) time.Duration {
+	n := len(c.Frames)
+	duration := c.Frames[i].Duration
+	for k := 1; k < n; k++ {
+		frame := c.Frames[(i+k)%n]
+		if frame.Keyframe {
+			break
+		}
+		duration += frame.Duration
+	}
+	return duration
+}
+
+// KeyframeSample은 keyframe k 를 혼자 디코딩할 수 있게 SPS/PPS 를 붙인 sample 이다.
+func (c *Clip) KeyframeSample(k int) media.Sample {
+	frame := c.Frames[k]
+	if len(frame.ParameterSets) == 0 {
+		return c.Sample(k)
+	}
+	data := make([]byte, 0, len(frame.ParameterSets)+len(frame.Data))
+	data = append(data, frame.ParameterSets...)
+	data = append(data, frame.Data...)
+	return media.Sample{Data: data, Duration: frame.Duration}
+}
+
+// Sample은 i 번째 화면을 track 에 쓸 sample 로 만든다.
+// Duration 으로 RTP timestamp 가 늘어나므로 실제 화면 길이를 넣어야 한다.
+func (c *Clip) Sample(i int) media.Sample {
+	return media.Sample{Data: c.Frames[i].Data, Duration: c.Frames[i].Duration}
+}
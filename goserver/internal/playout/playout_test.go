@@ -0,0 +1,83 @@
+package playout
+
+import (
+	"testing"
+	"time"
+)
+
+// testClip은 길이가 모두 duration 인 화면 n 개다. keyframes 에 든 화면만 keyframe 이다.
+func testClip(delay time.Duration, n int, duration time.Duration, keyframes ...int) *Clip {
+	frames := make([]Frame, n)
+	for i := range frames {
+		frames[i] = Frame{Data: []byte{byte(i)}, Duration: duration}
+	}
+	for _, k := range keyframes {
+		frames[k].Keyframe = true
+	}
+	return newClip("video/H264", delay, frames)
+}
+
+func TestLoopStartAt(t *testing.T) {
+	const ms = time.Millisecond
+	tests := []struct {
+		position time.Duration
+		want     time.Duration
+	}{
+		{position: 0, want: 0},
+		{position: 4 * ms, want: 0},
+		{position: 5 * ms, want: 5 * ms},
+		{position: 7 * ms, want: 5 * ms},
+		{position: -1 * ms, want: -5 * ms},
+		{position: -5 * ms, want: -5 * ms},
+		{position: -6 * ms, want: -10 * ms},
+	}
+
+	for _, tt := range tests {
+		if got := loopStartAt(tt.position, 5*ms); got != tt.want {
+			t.Errorf("loopStartAt(%v, 5ms) = %v, want %v", tt.position, got, tt.want)
+		}
+	}
+}
+
+func TestIndexAt(t *testing.T) {
+	const ms = time.Millisecond
+	// 2ms 쉬고 1ms 화면 3개, 5ms 마다 반복
+	clip := testClip(2*ms, 3, ms)
+	tests := []struct {
+		position time.Duration
+		want     int
+	}{
+		{position: 0, want: -1},
+		{position: 1 * ms, want: -1},
+		{position: 2 * ms, want: 0},
+		{position: 4500 * time.Microsecond, want: 2},
+		{position: 5 * ms, want: -1},
+		{position: 7 * ms, want: 0},
+		{position: -1 * ms, want: 2},
+		{position: -3 * ms, want: 0},
+		{position: -4 * ms, want: -1},
+	}
+
+	for _, tt := range tests {
+		if got := clip.IndexAt(tt.position); got != tt.want {
+			t.Errorf("IndexAt(%v) = %d, want %d", tt.position, got, tt.want)
+		}
+	}
+}
+
+func TestKeyframeAt(t *testing.T) {
+	clip := testClip(0, 5, time.Millisecond, 0, 3)
+	for i, want := range []int{0, 0, 0, 3, 3} {
+		if got := clip.keyframeAt(i); got != want {
+			t.Errorf("keyframeAt(%d) = %d, want %d", i, got, want)
+		}
+	}
+
+	// 처음 앞은 끝의 keyframe 이다.
+	if got := testClip(0, 3, time.Millisecond, 2).keyframeAt(0); got != 2 {
+		t.Errorf("keyframeAt(0) = %d, want 2", got)
+	}
+	if got := testClip(0, 3, time.Millisecond).keyframeAt(1); got != -1 {
+		t.Errorf("keyframeAt() without keyframes = %d, want -1", got)
+	}
+}
@@ -0,0 +1,185 @@
+package playout
+
+import (
+	"errors"
+	"time"
+)
+
+var errShortSPS = errors.New("sps: unexpected end of data")
+
+// bitReader는 emulation prevention byte 를 뺀 RBSP 를 비트 단위로 읽는다.
+type bitReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func newRBSPReader(nal []byte) *bitReader {
+	rbsp := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, b := range nal {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+	return &bitReader{data: rbsp}
+}
+
+func (r *bitReader) bits(n int) uint32 {
+	var value uint32
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.data)*8 {
+			r.err = errShortSPS
+			return 0
+		}
+		bit := r.data[r.pos/8] >> (7 - r.pos%8) & 1
+		value = value<<1 | uint32(bit)
+		r.pos++
+	}
+	return value
+}
+
+func (r *bitReader) flag() bool {
+	return r.bits(1) == 1
+}
+
+// ue는 Exp-Golomb 부호 없는 값이다.
+func (r *bitReader) ue() uint32 {
+	leadingZeros := 0
+	for !r.flag() {
+		if r.err != nil || leadingZeros > 31 {
+			r.err = errShortSPS
+			return 0
+		}
+		leadingZeros++
+	}
+	return 1<<leadingZeros - 1 + r.bits(leadingZeros)
+}
+
+func (r *bitReader) se() int32 {
+	v := r.ue()
+	if v%2 == 1 {
+		return int32(v/2 + 1)
+	}
+	return -int32(v / 2)
+}
+
+func (r *bitReader) skipScalingList(size int) {
+	last, next := int32(8), int32(8)
+	for j := 0; j < size; j++ {
+		if next != 0 {
+			next = (last + r.se() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+// spsFrameDuration은 SPS 의 VUI timing_info 로 화면 길이를 구한다. (H.264 7.3.2.1.1, E.1.1)
+// timing 정보가 없으면 0 이다.
+func spsFrameDuration(nal []byte) (time.Duration, error) {
+	r := newRBSPReader(nal)
+	r.bits(8) // NAL header
+
+	profileIdc := r.bits(8)
+	r.bits(16) // constraint flags, level_idc
+	r.ue()     // seq_parameter_set_id
+
+	switch profileIdc {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormatIdc := r.ue()
+		if chromaFormatIdc == 3 {
+			r.flag() // separate_colour_plane_flag
+		}
+		r.ue()   // bit_depth_luma_minus8
+		r.ue()   // bit_depth_chroma_minus8
+		r.flag() // qpprime_y_zero_transform_bypass_flag
+		if r.flag() {
+			lists := 8
+			if chromaFormatIdc == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if r.flag() {
+					if i < 6 {
+						r.skipScalingList(16)
+					} else {
+						r.skipScalingList(64)
+					}
+				}
+			}
+		}
+	}
+
+	r.ue() // log2_max_frame_num_minus4
+	switch r.ue() {
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.flag() // delta_pic_order_always_zero_flag
+		r.se()   // offset_for_non_ref_pic
+		r.se()   // offset_for_top_to_bottom_field
+		cycle := r.ue()
+		for i := uint32(0); i < cycle && r.err == nil; i++ {
+			r.se()
+		}
+	}
+	r.ue()   // max_num_ref_frames
+	r.flag() // gaps_in_frame_num_value_allowed_flag
+	r.ue()   // pic_width_in_mbs_minus1
+	r.ue()   // pic_height_in_map_units_minus1
+	if !r.flag() {
+		r.flag() // mb_adaptive_frame_field_flag
+	}
+	r.flag() // direct_8x8_inference_flag
+	if r.flag() {
+		r.ue() // frame_crop_left_offset
+		r.ue()
+		r.ue()
+		r.ue()
+	}
+	if !r.flag() {
+		return 0, r.err
+	}
+
+	// VUI
+	if r.flag() {
+		if r.bits(8) == 255 { // Extended_SAR
+			r.bits(32)
+		}
+	}
+	if r.flag() {
+		r.flag() // overscan_appropriate_flag
+	}
+	if r.flag() {
+		r.bits(4) // video_format, video_full_range_flag
+		if r.flag() {
+			r.bits(24)
+		}
+	}
+	if r.flag() {
+		r.ue() // chroma_sample_loc_type_top_field
+		r.ue()
+	}
+	if !r.flag() {
+		return 0, r.err
+	}
+	numUnitsInTick := r.bits(32)
+	timeScale := r.bits(32)
+	if r.err != nil {
+		return 0, r.err
+	}
+	if numUnitsInTick == 0 || timeScale == 0 {
+		return 0, nil
+	}
+	// 한 화면은 field 두 개라서 tick 두 번이다.
+	return time.Duration(2 * int64(numUnitsInTick) * int64(time.Second) / int64(timeScale)), nil
+}
@@ -0,0 +1,222 @@
+package playout
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// bitWriter는 테스트용 SPS 를 비트 단위로 쓴다.
+type bitWriter struct {
+	data []byte
+	n    int
+}
+
+func (w *bitWriter) bits(n int, value uint32) {
+	for i := n - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		w.data[len(w.data)-1] |= byte(value>>i&1) << (7 - w.n%8)
+		w.n++
+	}
+}
+
+func (w *bitWriter) flag(b bool) {
+	if b {
+		w.bits(1, 1)
+	} else {
+		w.bits(1, 0)
+	}
+}
+
+func (w *bitWriter) ue(value uint32) {
+	value++
+	length := 0
+	for v := value; v > 1; v >>= 1 {
+		length++
+	}
+	w.bits(length, 0)
+	w.bits(length+1, value)
+}
+
+func (w *bitWriter) se(value int32) {
+	if value > 0 {
+		w.ue(uint32(2*value - 1))
+	} else {
+		w.ue(uint32(-2 * value))
+	}
+}
+
+// nal은 rbsp_trailing_bits 를 붙이고 emulation prevention byte 를 넣는다.
+func (w *bitWriter) nal() []byte {
+	w.bits(1, 1)
+	for w.n%8 != 0 {
+		w.bits(1, 0)
+	}
+	var nal []byte
+	zeros := 0
+	for _, b := range w.data {
+		if zeros >= 2 && b <= 3 {
+			nal = append(nal, 3)
+			zeros = 0
+		}
+		nal = append(nal, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return nal
+}
+
+type spsOptions struct {
+	profile         uint32
+	chromaFormatIdc uint32
+	scalingLists    bool
+	picOrderCntType uint32
+	noVUI           bool
+	noTiming        bool
+	numUnitsInTick  uint32
+	timeScale       uint32
+}
+
+func buildSPS(o spsOptions) []byte {
+	w := &bitWriter{}
+	w.bits(8, 0x67)
+	w.bits(8, o.profile)
+	w.bits(16, 0x001f) // constraint flags, level 3.1
+	w.ue(0)
+	if o.profile == 100 {
+		w.ue(o.chromaFormatIdc)
+		if o.chromaFormatIdc == 3 {
+			w.flag(false)
+		}
+		w.ue(0)
+		w.ue(0)
+		w.flag(false)
+		w.flag(o.scalingLists)
+		if o.scalingLists {
+			lists := 8
+			if o.chromaFormatIdc == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				// 첫 4x4, 첫 8x8 목록만 보낸다. delta -8 이면 나머지는 기본값이라 더 읽지 않는다.
+				present := i == 0 || i == 6
+				w.flag(present)
+				if present {
+					w.se(-8)
+				}
+			}
+		}
+	}
+	w.ue(0) // log2_max_frame_num_minus4
+	w.ue(o.picOrderCntType)
+	switch o.picOrderCntType {
+	case 0:
+		w.ue(2)
+	case 1:
+		w.flag(false)
+		w.se(-1)
+		w.se(2)
+		w.ue(2)
+		w.se(3)
+		w.se(-4)
+	}
+	w.ue(1) // max_num_ref_frames
+	w.flag(false)
+	w.ue(79) // 1280
+	w.ue(44) // 720
+	w.flag(true)
+	w.flag(true)
+	w.flag(true) // frame_cropping_flag
+	w.ue(0)
+	w.ue(0)
+	w.ue(0)
+	w.ue(4)
+	w.flag(!o.noVUI)
+	if o.noVUI {
+		return w.nal()
+	}
+
+	w.flag(true) // aspect_ratio_info_present_flag
+	w.bits(8, 255)
+	w.bits(32, 0x00010001)
+	w.flag(true) // overscan_info_present_flag
+	w.flag(false)
+	w.flag(true) // video_signal_type_present_flag
+	w.bits(4, 0b1010)
+	w.flag(true)
+	w.bits(24, 0x010101)
+	w.flag(true) // chroma_loc_info_present_flag
+	w.ue(0)
+	w.ue(0)
+	w.flag(!o.noTiming)
+	if !o.noTiming {
+		w.bits(32, o.numUnitsInTick)
+		w.bits(32, o.timeScale)
+		w.flag(true)
+	}
+	w.flag(false) // nal_hrd_parameters_present_flag
+	return w.nal()
+}
+
+func TestSPSFrameDuration(t *testing.T) {
+	tests := []struct {
+		name string
+		sps  spsOptions
+		want time.Duration
+	}{
+		{name: "baseline 30fps", sps: spsOptions{profile: 66, numUnitsInTick: 1, timeScale: 60}, want: 33333333},
+		{name: "main 29.97fps", sps: spsOptions{profile: 77, numUnitsInTick: 1001, timeScale: 60000}, want: 33366666},
+		{name: "high 25fps", sps: spsOptions{profile: 100, chromaFormatIdc: 1, numUnitsInTick: 1, timeScale: 50}, want: 40 * time.Millisecond},
+		{name: "high scaling lists", sps: spsOptions{profile: 100, chromaFormatIdc: 1, scalingLists: true, numUnitsInTick: 1, timeScale: 120}, want: 16666666},
+		{name: "high 4:4:4 scaling lists", sps: spsOptions{profile: 100, chromaFormatIdc: 3, scalingLists: true, numUnitsInTick: 1, timeScale: 48}, want: 41666666},
+		{name: "pic order count type 1", sps: spsOptions{profile: 66, picOrderCntType: 1, numUnitsInTick: 1, timeScale: 60}, want: 33333333},
+		{name: "pic order count type 2", sps: spsOptions{profile: 66, picOrderCntType: 2, numUnitsInTick: 1, timeScale: 60}, want: 33333333},
+		{name: "no vui", sps: spsOptions{profile: 66, noVUI: true}},
+		{name: "no timing", sps: spsOptions{profile: 66, noTiming: true}},
+		{name: "zero time scale", sps: spsOptions{profile: 66, numUnitsInTick: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := spsFrameDuration(buildSPS(tt.sps))
+			if err != nil {
+				t.Fatalf("spsFrameDuration() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("spsFrameDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSPSFrameDurationEmulationPrevention(t *testing.T) {
+	// num_units_in_tick 1 은 00 00 00 01 이라서 emulation prevention byte 가 들어간다.
+	sps := buildSPS(spsOptions{profile: 66, numUnitsInTick: 1, timeScale: 60})
+	found := false
+	for i := 2; i < len(sps); i++ {
+		if sps[i-2] == 0 && sps[i-1] == 0 && sps[i] == 3 {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("test SPS has no emulation prevention byte")
+	}
+	if got, err := spsFrameDuration(sps); err != nil || got != 33333333 {
+		t.Fatalf("spsFrameDuration() = %v, %v", got, err)
+	}
+}
+
+func TestSPSFrameDurationTruncated(t *testing.T) {
+	sps := buildSPS(spsOptions{profile: 100, chromaFormatIdc: 1, scalingLists: true, numUnitsInTick: 1, timeScale: 60})
+	// timing_info 중간에서 자른다.
+	for _, n := range []int{0, 1, 4, len(sps) - 6} {
+		if _, err := spsFrameDuration(sps[:n]); !errors.Is(err, errShortSPS) {
+			t.Errorf("spsFrameDuration(%d bytes) error = %v, want %v", n, err, errShortSPS)
+		}
+	}
+}
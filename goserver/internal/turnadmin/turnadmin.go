@@ -0,0 +1,149 @@
+// Package turnadmin은 turnserver/stunserver 의 상태를 보는 관리용 HTTP 핸들러다.
+//
+//	GET /metrics      Prometheus text format
+//	GET /allocations  살아있는 allocation 목록 (JSON)
+//	GET /healthz      살아있으면 200
+//
+// 인증이 없으므로 localhost 나 내부망 주소에만 열어야 한다.
+package turnadmin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"server.firehunter.juhyung.dev/internal/turnguard"
+)
+
+type AllocationData struct {
+	Username    string    `json:"username"`
+	ClientAddr  string    `json:"clientAddr"`
+	RelayAddr   string    `json:"relayAddr"`
+	CreatedAt   time.Time `json:"createdAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	Permissions int       `json:"permissions"`
+}
+
+type HealthData struct {
+	Status        string  `json:"status"`
+	UptimeSeconds float64 `json:"uptimeSeconds"`
+	Allocations   int     `json:"allocations"`
+}
+
+// NewHandler는 quota 와 limiter 가 감싼 listener 의 통계를 보여주는 핸들러를 만든다.
+// limiter 는 nil 이어도 된다.
+func NewHandler(quota *turnguard.Quota, limiter *turnguard.RateLimiter) http.Handler {
+	startedAt := time.Now()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, quota, limiter)
+	})
+	mux.HandleFunc("GET /allocations", func(w http.ResponseWriter, r *http.Request) {
+		allocations := quota.Allocations()
+		sort.Slice(allocations, func(i, j int) bool {
+			return allocations[i].CreatedAt.Before(allocations[j].CreatedAt)
+		})
+
+		data := make([]AllocationData, 0, len(allocations))
+		for _, allocation := range allocations {
+			data = append(data, AllocationData{
+				Username:    allocation.Username,
+				ClientAddr:  addrString(allocation.ClientAddr),
+				RelayAddr:   addrString(allocation.RelayAddr),
+				CreatedAt:   allocation.CreatedAt,
+				ExpiresAt:   allocation.ExpiresAt,
+				Permissions: allocation.Permissions,
+			})
+		}
+		writeJSON(w, data)
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, HealthData{
+			Status:        "ok",
+			UptimeSeconds: time.Since(startedAt).Seconds(),
+			Allocations:   len(quota.Allocations()),
+		})
+	})
+	return mux
+}
+
+// ListenAndServe는 addr 이 비어 있으면 아무것도 하지 않는다.
+func ListenAndServe(addr string, quota *turnguard.Quota, limiter *turnguard.RateLimiter) {
+	if addr == "" {
+		return
+	}
+
+	fmt.Println("Admin listening on", addr)
+	go func() {
+		if err := http.ListenAndServe(addr, NewHandler(quota, limiter)); err != nil {
+			fmt.Printf("admin server stopped: %v\n", err)
+		}
+	}()
+}
+
+func writeMetrics(w io.Writer, quota *turnguard.Quota, limiter *turnguard.RateLimiter) {
+	stats := quota.Stats()
+	rejected := quota.RejectedCounters()
+
+	writeMetric(w, "firehunter_stun_binding_requests_total", "counter", "STUN binding requests received.", stats.BindingRequests)
+	writeMetric(w, "firehunter_turn_allocations", "gauge", "Live TURN allocations.", len(quota.Allocations()))
+	writeMetric(w, "firehunter_turn_allocations_created_total", "counter", "TURN allocations created.", stats.AllocationsCreated)
+	writeMetric(w, "firehunter_turn_allocations_expired_total", "counter", "TURN allocations expired or deleted.", stats.AllocationsExpired)
+	writeMetric(w, "firehunter_turn_auth_failures_total", "counter", "Authenticated TURN requests rejected with 401 or 400.", stats.AuthFailures)
+
+	fmt.Fprintln(w, "# HELP firehunter_turn_quota_rejections_total TURN requests rejected by quota.")
+	fmt.Fprintln(w, "# TYPE firehunter_turn_quota_rejections_total counter")
+	fmt.Fprintf(w, "firehunter_turn_quota_rejections_total{reason=\"allocations\"} %d\n", rejected.Allocations)
+	fmt.Fprintf(w, "firehunter_turn_quota_rejections_total{reason=\"permissions\"} %d\n", rejected.Permissions)
+	fmt.Fprintf(w, "firehunter_turn_quota_rejections_total{reason=\"lifetime\"} %d\n", rejected.Lifetime)
+	writeMetric(w, "firehunter_turn_dropped_bytes_total", "counter", "Relayed bytes dropped by bandwidth or lifetime limits.", rejected.DroppedBytes)
+
+	users := make([]string, 0, len(stats.RelayedBytes))
+	for user := range stats.RelayedBytes {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	fmt.Fprintln(w, "# HELP firehunter_turn_relayed_bytes_total Bytes relayed over UDP per user.")
+	fmt.Fprintln(w, "# TYPE firehunter_turn_relayed_bytes_total counter")
+	for _, user := range users {
+		fmt.Fprintf(w, "firehunter_turn_relayed_bytes_total{user=\"%s\"} %d\n", labelEscaper.Replace(user), stats.RelayedBytes[user])
+	}
+
+	if limiter == nil {
+		return
+	}
+	counters := limiter.Counters()
+	fmt.Fprintln(w, "# HELP firehunter_stun_dropped_packets_total Packets dropped by the per-source rate limiter.")
+	fmt.Fprintln(w, "# TYPE firehunter_stun_dropped_packets_total counter")
+	fmt.Fprintf(w, "firehunter_stun_dropped_packets_total{reason=\"rate\"} %d\n", counters.DroppedRequests)
+	fmt.Fprintf(w, "firehunter_stun_dropped_packets_total{reason=\"banned\"} %d\n", counters.DroppedBanned)
+	writeMetric(w, "firehunter_stun_bans_total", "counter", "Sources temporarily banned.", counters.Bans)
+	writeMetric(w, "firehunter_stun_active_bans", "gauge", "Sources banned now.", counters.ActiveBans)
+}
+
+func writeMetric[T uint64 | int](w io.Writer, name, kind, help string, value T) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Println("Error encoding admin response: ", err)
+	}
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
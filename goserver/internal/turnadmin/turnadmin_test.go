@@ -0,0 +1,170 @@
+package turnadmin
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/stun/v2"
+
+	"server.firehunter.juhyung.dev/internal/turnguard"
+)
+
+// fakePacketConn은 넣어 둔 패킷 하나를 읽고 쓴 패킷은 버린다.
+type fakePacketConn struct {
+	net.PacketConn
+	inbound []byte
+	addr    net.Addr
+}
+
+func (c *fakePacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	if c.inbound == nil {
+		return 0, nil, io.EOF
+	}
+	n := copy(p, c.inbound)
+	c.inbound = nil
+	return n, c.addr, nil
+}
+
+func (c *fakePacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return len(p), nil
+}
+
+func build(t *testing.T, setters ...stun.Setter) *stun.Message {
+	t.Helper()
+	m, err := stun.Build(append([]stun.Setter{stun.TransactionID}, setters...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+type xorAddr struct {
+	attr stun.AttrType
+	addr *net.UDPAddr
+}
+
+func (a xorAddr) AddTo(m *stun.Message) error {
+	return stun.XORMappedAddress{IP: a.addr.IP, Port: a.addr.Port}.AddToAs(m, a.attr)
+}
+
+// exchange는 client 의 요청과 pion 의 성공 응답을 quota 가 감싼 listener 로 흘린다.
+func exchange(t *testing.T, conn net.PacketConn, fake *fakePacketConn, client net.Addr, method stun.Method, request []stun.Setter, response ...stun.Setter) {
+	t.Helper()
+	m := build(t, append([]stun.Setter{stun.NewType(method, stun.ClassRequest)}, request...)...)
+	fake.inbound, fake.addr = m.Raw, client
+	if _, _, err := conn.ReadFrom(make([]byte, 1500)); err != nil {
+		t.Fatalf("request was rejected: %v", err)
+	}
+	reply := build(t, append([]stun.Setter{stun.NewTransactionIDSetter(m.TransactionID), stun.NewType(method, stun.ClassSuccessResponse)}, response...)...)
+	if _, err := conn.WriteTo(reply.Raw, client); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func get(t *testing.T, handler http.Handler, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d", path, recorder.Code)
+	}
+	return recorder
+}
+
+func TestAllocations(t *testing.T) {
+	quota := turnguard.NewQuota(turnguard.Limits{})
+	t.Cleanup(quota.Close)
+	handler := NewHandler(quota, nil)
+
+	// allocation 이 없어도 null 이 아니라 빈 배열이다.
+	if body := strings.TrimSpace(get(t, handler, "/allocations").Body.String()); body != "[]" {
+		t.Fatalf("GET /allocations = %s, want []", body)
+	}
+
+	fake := &fakePacketConn{}
+	conn := quota.Wrap(fake)
+	alice := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}
+	bob := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 5000}
+	relay := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 49152}
+	lifetime := stun.RawAttribute{Type: stun.AttrLifetime, Value: []byte{0, 0, 0x02, 0x58}}
+
+	exchange(t, conn, fake, alice, stun.MethodAllocate, []stun.Setter{stun.NewUsername("1700000000:alice")}, lifetime, xorAddr{stun.AttrXORRelayedAddress, relay})
+	// CreatedAt 순서가 갈리도록 한다.
+	time.Sleep(time.Millisecond)
+	// relay 주소가 없는 응답이면 relayAddr 은 빈 문자열이다.
+	exchange(t, conn, fake, bob, stun.MethodAllocate, []stun.Setter{stun.NewUsername("bob")}, lifetime)
+	peers := []stun.Setter{
+		stun.NewUsername("1700000000:alice"),
+		xorAddr{stun.AttrXORPeerAddress, &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 7000}},
+		xorAddr{stun.AttrXORPeerAddress, &net.UDPAddr{IP: net.IPv4(203, 0, 113, 8), Port: 8000}},
+	}
+	exchange(t, conn, fake, alice, stun.MethodCreatePermission, peers)
+
+	recorder := get(t, handler, "/allocations")
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("Content-Type = %s", contentType)
+	}
+	var data []AllocationData
+	if err := json.Unmarshal(recorder.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2 {
+		t.Fatalf("%d allocations, want 2: %s", len(data), recorder.Body)
+	}
+	first, second := data[0], data[1]
+	if first.Username != "1700000000:alice" || first.ClientAddr != alice.String() || first.RelayAddr != relay.String() || first.Permissions != 2 {
+		t.Fatalf("first allocation = %+v", first)
+	}
+	if second.Username != "bob" || second.ClientAddr != bob.String() || second.RelayAddr != "" || second.Permissions != 0 {
+		t.Fatalf("second allocation = %+v", second)
+	}
+	if !first.CreatedAt.Before(second.CreatedAt) {
+		t.Fatalf("allocations are not sorted by creation: %v, %v", first.CreatedAt, second.CreatedAt)
+	}
+	if got := first.ExpiresAt.Sub(first.CreatedAt); got != 10*time.Minute {
+		t.Fatalf("lifetime = %v, want 10m", got)
+	}
+
+	// 필드 이름은 camelCase 다.
+	var raw []map[string]any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &raw); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"username", "clientAddr", "relayAddr", "createdAt", "expiresAt", "permissions"} {
+		if _, ok := raw[0][key]; !ok {
+			t.Fatalf("allocation has no %q: %s", key, recorder.Body)
+		}
+	}
+
+	var health HealthData
+	if err := json.Unmarshal(get(t, handler, "/healthz").Body.Bytes(), &health); err != nil {
+		t.Fatal(err)
+	}
+	if health.Status != "ok" || health.Allocations != 2 {
+		t.Fatalf("health = %+v", health)
+	}
+
+	metrics := get(t, handler, "/metrics").Body.String()
+	for _, want := range []string{"firehunter_turn_allocations 2\n", "firehunter_turn_allocations_created_total 2\n"} {
+		if !strings.Contains(metrics, want) {
+			t.Fatalf("metrics has no %q:\n%s", want, metrics)
+		}
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	quota := turnguard.NewQuota(turnguard.Limits{})
+	t.Cleanup(quota.Close)
+
+	recorder := httptest.NewRecorder()
+	NewHandler(quota, nil).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/allocations", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST /allocations = %d, want %d", recorder.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,63 @@
+// Package turncred는 TURN REST API 방식의 임시 TURN 계정과 ICE 서버 목록을 만든다.
+// https://datatracker.ietf.org/doc/html/draft-uberti-behave-turn-rest-00
+//
+// username 은 "만료시각:사용자" 이고 password 는 공유 비밀키로 만든 HMAC 이라서
+// TURN 서버는 같은 비밀키만 알면 DB 없이 검증할 수 있다. (turn.LongTermTURNRESTAuthHandler)
+package turncred
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pion/turn/v3"
+)
+
+// ICEServer는 브라우저의 RTCIceServer, pion 의 webrtc.ICEServer 와 같은 JSON 모양이다.
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+type Config struct {
+	// 비어 있으면 TURN 서버는 목록에 넣지 않는다.
+	Secret   string
+	TTL      time.Duration
+	STUNURLs []string
+	TURNURLs []string
+}
+
+func SplitURLs(urls string) []string {
+	var result []string
+	for _, url := range strings.Split(urls, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			result = append(result, url)
+		}
+	}
+	return result
+}
+
+// ICEServers는 user 에게 줄 ICE 서버 목록을 만든다. TURN 계정은 TTL 뒤에 만료된다.
+func (c Config) ICEServers(user string) ([]ICEServer, error) {
+	var servers []ICEServer
+	if len(c.STUNURLs) > 0 {
+		servers = append(servers, ICEServer{URLs: c.STUNURLs})
+	}
+
+	if c.Secret == "" || len(c.TURNURLs) == 0 {
+		return servers, nil
+	}
+
+	username, password, err := turn.GenerateLongTermTURNRESTCredentials(c.Secret, user, c.TTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TURN credentials: %w", err)
+	}
+	servers = append(servers, ICEServer{
+		URLs:       c.TURNURLs,
+		Username:   username,
+		Credential: password,
+	})
+
+	return servers, nil
+}
@@ -0,0 +1,130 @@
+package turncred
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/turn/v3"
+)
+
+func TestSplitURLs(t *testing.T) {
+	tests := []struct {
+		urls string
+		want []string
+	}{
+		{urls: "", want: nil},
+		{urls: "stun:a:3478", want: []string{"stun:a:3478"}},
+		{urls: " turn:a:3478?transport=udp , ,turns:a:5349 ", want: []string{"turn:a:3478?transport=udp", "turns:a:5349"}},
+	}
+
+	for _, tt := range tests {
+		if got := SplitURLs(tt.urls); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitURLs(%q) = %q, want %q", tt.urls, got, tt.want)
+		}
+	}
+}
+
+func TestICEServers(t *testing.T) {
+	stun := []string{"stun:a:3478"}
+	turnURLs := []string{"turn:a:3478"}
+	tests := []struct {
+		name   string
+		config Config
+		want   int
+	}{
+		{name: "stun only", config: Config{STUNURLs: stun, Secret: "secret"}, want: 1},
+		// 비밀키가 없으면 TURN 서버는 빠진다.
+		{name: "no secret", config: Config{STUNURLs: stun, TURNURLs: turnURLs}, want: 1},
+		{name: "turn only", config: Config{TURNURLs: turnURLs, Secret: "secret", TTL: time.Hour}, want: 1},
+		{name: "both", config: Config{STUNURLs: stun, TURNURLs: turnURLs, Secret: "secret", TTL: time.Hour}, want: 2},
+		{name: "none", config: Config{}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servers, err := tt.config.ICEServers("alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(servers) != tt.want {
+				t.Fatalf("%d servers, want %d: %+v", len(servers), tt.want, servers)
+			}
+			for _, server := range servers {
+				isTURN := reflect.DeepEqual(server.URLs, turnURLs)
+				if isTURN != (server.Username != "") || isTURN != (server.Credential != "") {
+					t.Fatalf("server %+v: only TURN servers have credentials", server)
+				}
+			}
+		})
+	}
+}
+
+func turnServer(t *testing.T, servers []ICEServer) ICEServer {
+	t.Helper()
+	server := servers[len(servers)-1]
+	if server.Username == "" {
+		t.Fatalf("no TURN server in %+v", servers)
+	}
+	return server
+}
+
+func TestICEServersCredential(t *testing.T) {
+	config := Config{Secret: "secret", TTL: time.Hour, TURNURLs: []string{"turn:a:3478"}}
+	before := time.Now()
+	servers, err := config.ICEServers("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := turnServer(t, servers)
+
+	// username 은 "만료시각:사용자" 다.
+	expiry, user, ok := strings.Cut(server.Username, ":")
+	if !ok || user != "alice" {
+		t.Fatalf("username = %q, want <expiry>:alice", server.Username)
+	}
+	seconds, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		t.Fatalf("username = %q: %v", server.Username, err)
+	}
+	if at := time.Unix(seconds, 0); at.Before(before.Add(time.Hour).Truncate(time.Second)) || at.After(time.Now().Add(time.Hour)) {
+		t.Fatalf("expiry = %v, want an hour from %v", at, before)
+	}
+
+	// password 는 username 의 HMAC-SHA1 이다.
+	mac := hmac.New(sha1.New, []byte("secret"))
+	mac.Write([]byte(server.Username))
+	if want := base64.StdEncoding.EncodeToString(mac.Sum(nil)); server.Credential != want {
+		t.Fatalf("credential = %q, want %q", server.Credential, want)
+	}
+
+	// 같은 비밀키를 쓰는 TURN 서버가 받아들인다.
+	key, ok := turn.LongTermTURNRESTAuthHandler("secret", nil)(server.Username, "firehunter", nil)
+	if !ok {
+		t.Fatal("TURN server rejected the credential")
+	}
+	if want := turn.GenerateAuthKey(server.Username, "firehunter", server.Credential); string(key) != string(want) {
+		t.Fatal("TURN server derived a different key")
+	}
+	// 다른 비밀키로는 key 가 달라서 MESSAGE-INTEGRITY 가 맞지 않는다.
+	if other, _ := turn.LongTermTURNRESTAuthHandler("other", nil)(server.Username, "firehunter", nil); string(other) == string(key) {
+		t.Fatal("a different secret gave the same key")
+	}
+}
+
+func TestICEServersExpired(t *testing.T) {
+	config := Config{Secret: "secret", TTL: -time.Minute, TURNURLs: []string{"turn:a:3478"}}
+	servers, err := config.ICEServers("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := turnServer(t, servers)
+	if _, ok := turn.LongTermTURNRESTAuthHandler("secret", nil)(server.Username, "firehunter", nil); ok {
+		t.Fatal("TURN server accepted an expired credential")
+	}
+}
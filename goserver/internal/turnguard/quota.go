@@ -0,0 +1,621 @@
+// Package turnguard는 pion/turn 에 넘기는 net.PacketConn 을 감싸서
+// 지나가는 STUN/TURN 메시지를 보고 제한을 건다.
+//
+// pion/turn v3 에는 allocation 이벤트를 받을 방법이 없어서
+// Allocate/Refresh/CreatePermission/ChannelBind 요청과 응답을 직접 보고 allocation 을 추적한다.
+// TCP/TLS listener 는 WrapListener 로 감싸면 연결마다 STUN/ChannelData frame 을 나눠서 같은 제한을 건다.
+// RateLimiter 는 출발지 IP 별 요청 수를 제한한다.
+package turnguard
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3"
+)
+
+type Limits struct {
+	// 0 이면 제한 없음. 임시 계정은 "만료시각:" 을 뺀 사용자로 센다.
+	MaxAllocationsPerUser int
+	MaxAllocationsPerIP   int
+	MaxPermissionsPerUser int
+	// 사용자별로 relay 하는 데이터의 초당 바이트 수 (client 방향과 peer 방향 합)
+	BandwidthPerUser int
+	// allocation 을 만든 뒤 이 시간이 지나면 Refresh 를 거절하고 데이터를 버린다.
+	MaxAllocationLifetime time.Duration
+}
+
+// Allocation은 Quota 가 본 allocation 이다.
+type Allocation struct {
+	Username   string
+	ClientAddr net.Addr
+	RelayAddr  net.Addr
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	// Allocations() 로 복사할 때 채워진다.
+	Permissions int
+	// peer IP 마다 permission 만료 시각
+	permissions map[string]time.Time
+}
+
+type pendingRequest struct {
+	method     stun.Method
+	username   string
+	clientAddr net.Addr
+	peers      []string
+	expiresAt  time.Time
+}
+
+// Quota는 사용자/IP 별 allocation, permission 수와 대역폭을 제한한다.
+// 여러 listener 를 Wrap 해도 제한은 합쳐서 계산한다.
+type Quota struct {
+	limits Limits
+
+	mu          sync.Mutex
+	pending     map[[stun.TransactionIDSize]byte]*pendingRequest
+	allocations map[string]*Allocation
+	buckets     map[string]*tokenBucket
+
+	// 제한에 걸린 횟수
+	rejected Counters
+	stats    Stats
+
+	closed chan struct{}
+	once   sync.Once
+}
+
+type Counters struct {
+	Allocations  uint64
+	Permissions  uint64
+	Lifetime     uint64
+	DroppedBytes uint64
+}
+
+var (
+	// RFC 8656 permission/channel 은 5분, 응답이 오지 않는 요청은 금방 버린다.
+	permissionLifetime = 5 * time.Minute
+	pendingLifetime    = 30 * time.Second
+	sweepInterval      = 10 * time.Second
+)
+
+func NewQuota(limits Limits) *Quota {
+	c := &Quota{
+		limits:      limits,
+		pending:     make(map[[stun.TransactionIDSize]byte]*pendingRequest),
+		allocations: make(map[string]*Allocation),
+		buckets:     make(map[string]*tokenBucket),
+		stats:       Stats{RelayedBytes: make(map[string]uint64)},
+		closed:      make(chan struct{}),
+	}
+	go c.sweepLoop()
+	return c
+}
+
+func (c *Quota) Close() {
+	c.once.Do(func() { close(c.closed) })
+}
+
+// QuotaConn은 pion/turn 에 넘기는 listener 다.
+type QuotaConn struct {
+	net.PacketConn
+	quota *Quota
+}
+
+func (c *Quota) Wrap(conn net.PacketConn) *QuotaConn {
+	return &QuotaConn{PacketConn: conn, quota: c}
+}
+
+// QuotaListener는 pion/turn 에 넘기는 TCP/TLS listener 다.
+type QuotaListener struct {
+	net.Listener
+	quota *Quota
+}
+
+func (c *Quota) WrapListener(listener net.Listener) *QuotaListener {
+	return &QuotaListener{Listener: listener, quota: c}
+}
+
+// Accept는 받은 연결을 frame 단위로 검사하도록 감싼다.
+func (l *QuotaListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &quotaStreamConn{
+		Conn:   conn,
+		quota:  l.quota,
+		frames: turn.NewSTUNConn(conn),
+		buf:    make([]byte, maxFrameSize),
+	}, nil
+}
+
+// STUN 메시지는 header 20 바이트, ChannelData 는 header 4 바이트와 padding 이 붙는다.
+const maxFrameSize = 0xFFFF + 20
+
+// quotaStreamConn은 stream 을 frame 으로 나눠 UDP 와 같은 검사를 하고, 통과한 frame 만 pion 이 읽게 한다.
+// pion 은 Write 한 번에 frame 하나를 쓰므로 버리는 frame 이 있어도 stream 이 깨지지 않는다.
+type quotaStreamConn struct {
+	net.Conn
+	quota  *Quota
+	frames *turn.STUNConn
+	buf    []byte
+	// pion 이 아직 읽지 않은 통과한 frame
+	pending []byte
+}
+
+func (c *quotaStreamConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		n, addr, err := c.frames.ReadFrom(c.buf)
+		if err != nil {
+			return 0, err
+		}
+		if c.quota.inspectInbound(c.frames, c.buf[:n], addr) {
+			c.pending = c.buf[:n]
+		}
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *quotaStreamConn) Write(p []byte) (int, error) {
+	if !c.quota.inspectOutbound(p, c.RemoteAddr()) {
+		return len(p), nil
+	}
+	return c.Conn.Write(p)
+}
+
+// Allocations는 지금 살아있는 allocation 의 복사본을 돌려준다.
+func (c *Quota) Allocations() []Allocation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	allocations := make([]Allocation, 0, len(c.allocations))
+	for _, allocation := range c.allocations {
+		snapshot := *allocation
+		snapshot.Permissions = len(allocation.permissions)
+		snapshot.permissions = nil
+		allocations = append(allocations, snapshot)
+	}
+	return allocations
+}
+
+// RejectedCounters는 지금까지 제한에 걸린 횟수를 돌려준다.
+func (c *Quota) RejectedCounters() Counters {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.rejected
+}
+
+// ReadFrom은 제한에 걸린 요청에 직접 에러 응답을 보내고 pion 에는 넘기지 않는다.
+func (c *QuotaConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil {
+			return n, addr, err
+		}
+
+		if c.quota.inspectInbound(c.PacketConn, p[:n], addr) {
+			return n, addr, nil
+		}
+	}
+}
+
+func (c *QuotaConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if !c.quota.inspectOutbound(p, addr) {
+		// UDP 라서 조용히 버려도 된다.
+		return len(p), nil
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+// isChannelData는 첫 두 비트가 01 인지 본다. (RFC 8656 12.4)
+func isChannelData(p []byte) bool {
+	return len(p) >= 4 && p[0]&0xC0 == 0x40
+}
+
+func (c *Quota) inspectInbound(conn net.PacketConn, p []byte, addr net.Addr) bool {
+	if isChannelData(p) {
+		return c.allowData(addr, len(p))
+	}
+	if !stun.IsMessage(p) {
+		return true
+	}
+
+	m := &stun.Message{Raw: append([]byte{}, p...)}
+	if err := m.Decode(); err != nil {
+		return true
+	}
+
+	if m.Type.Class == stun.ClassIndication && m.Type.Method == stun.MethodSend {
+		return c.allowData(addr, len(p))
+	}
+	if m.Type.Class != stun.ClassRequest {
+		return true
+	}
+	if m.Type.Method == stun.MethodBinding {
+		c.countBindingRequest()
+		return true
+	}
+
+	var username stun.Username
+	if err := username.GetFrom(m); err != nil {
+		// 인증 전 요청은 pion 이 401 로 답한다.
+		return true
+	}
+
+	switch m.Type.Method {
+	case stun.MethodAllocate:
+		if code, reason := c.checkAllocate(username.String(), addr); code != 0 {
+			c.reject(conn, m, addr, code, reason)
+			return false
+		}
+		c.addPending(m, &pendingRequest{method: m.Type.Method, username: username.String(), clientAddr: addr})
+
+	case stun.MethodRefresh:
+		if code, reason := c.checkRefresh(m, addr); code != 0 {
+			c.reject(conn, m, addr, code, reason)
+			return false
+		}
+		c.addPending(m, &pendingRequest{method: m.Type.Method, username: username.String(), clientAddr: addr})
+
+	case stun.MethodCreatePermission, stun.MethodChannelBind:
+		peers := peerIPs(m)
+		if code, reason := c.checkPermissions(username.String(), addr, peers); code != 0 {
+			c.reject(conn, m, addr, code, reason)
+			return false
+		}
+		c.addPending(m, &pendingRequest{method: m.Type.Method, username: username.String(), clientAddr: addr, peers: peers})
+	}
+
+	return true
+}
+
+func (c *Quota) inspectOutbound(p []byte, addr net.Addr) bool {
+	if isChannelData(p) {
+		return c.allowData(addr, len(p))
+	}
+	if !stun.IsMessage(p) {
+		return true
+	}
+
+	m := &stun.Message{Raw: append([]byte{}, p...)}
+	if err := m.Decode(); err != nil {
+		return true
+	}
+
+	if m.Type.Class == stun.ClassIndication && m.Type.Method == stun.MethodData {
+		return c.allowData(addr, len(p))
+	}
+	if m.Type.Class == stun.ClassSuccessResponse || m.Type.Class == stun.ClassErrorResponse {
+		c.completePending(m)
+	}
+
+	return true
+}
+
+func (c *Quota) addPending(m *stun.Message, request *pendingRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	request.expiresAt = time.Now().Add(pendingLifetime)
+	c.pending[m.TransactionID] = request
+}
+
+// completePending은 pion 이 보낸 응답을 보고 allocation 상태를 갱신한다.
+func (c *Quota) completePending(m *stun.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	request, ok := c.pending[m.TransactionID]
+	if !ok {
+		return
+	}
+	delete(c.pending, m.TransactionID)
+	if m.Type.Class != stun.ClassSuccessResponse {
+		var code stun.ErrorCodeAttribute
+		// username 이 있는 요청에 대한 401/400 은 인증 실패다.
+		// pion 은 사용자가 없거나 MESSAGE-INTEGRITY 가 틀리면 400 으로 답한다.
+		if err := code.GetFrom(m); err == nil && (code.Code == stun.CodeUnauthorized || code.Code == stun.CodeBadRequest) {
+			c.stats.AuthFailures++
+		}
+		return
+	}
+
+	key := request.clientAddr.String()
+	now := time.Now()
+	switch request.method {
+	case stun.MethodAllocate:
+		var relayed stun.XORMappedAddress
+		allocation := &Allocation{
+			Username:    request.username,
+			ClientAddr:  request.clientAddr,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(lifetime(m)),
+			permissions: make(map[string]time.Time),
+		}
+		if err := relayed.GetFromAs(m, stun.AttrXORRelayedAddress); err == nil {
+			allocation.RelayAddr = &net.UDPAddr{IP: relayed.IP, Port: relayed.Port}
+		}
+		if _, ok := c.allocations[key]; !ok {
+			c.stats.AllocationsCreated++
+		}
+		c.allocations[key] = allocation
+
+	case stun.MethodRefresh:
+		allocation, ok := c.allocations[key]
+		if !ok {
+			return
+		}
+		if lifetime := lifetime(m); lifetime > 0 {
+			allocation.ExpiresAt = now.Add(lifetime)
+		} else {
+			delete(c.allocations, key)
+			c.stats.AllocationsExpired++
+		}
+
+	case stun.MethodCreatePermission, stun.MethodChannelBind:
+		allocation, ok := c.allocations[key]
+		if !ok {
+			return
+		}
+		for _, peer := range request.peers {
+			allocation.permissions[peer] = now.Add(permissionLifetime)
+		}
+	}
+}
+
+func (c *Quota) checkAllocate(username string, addr net.Addr) (stun.ErrorCode, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.allocations[addr.String()]; ok {
+		// 재전송이거나 이미 있는 allocation, pion 이 처리한다.
+		return 0, ""
+	}
+
+	ip := addrIP(addr)
+	user := metricsUser(username)
+	perUser, perIP := 0, 0
+	for _, allocation := range c.allocations {
+		if metricsUser(allocation.Username) == user {
+			perUser++
+		}
+		if addrIP(allocation.ClientAddr) == ip {
+			perIP++
+		}
+	}
+
+	if c.limits.MaxAllocationsPerUser > 0 && perUser >= c.limits.MaxAllocationsPerUser {
+		c.rejected.Allocations++
+		return stun.CodeAllocQuotaReached, fmt.Sprintf("user %s has %d allocations", user, perUser)
+	}
+	if c.limits.MaxAllocationsPerIP > 0 && perIP >= c.limits.MaxAllocationsPerIP {
+		c.rejected.Allocations++
+		return stun.CodeAllocQuotaReached, fmt.Sprintf("ip %s has %d allocations", ip, perIP)
+	}
+	return 0, ""
+}
+
+func (c *Quota) checkRefresh(m *stun.Message, addr net.Addr) (stun.ErrorCode, string) {
+	if c.limits.MaxAllocationLifetime == 0 || lifetime(m) == 0 {
+		return 0, ""
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	allocation, ok := c.allocations[addr.String()]
+	if !ok || time.Since(allocation.CreatedAt) < c.limits.MaxAllocationLifetime {
+		return 0, ""
+	}
+
+	c.rejected.Lifetime++
+	return stun.CodeForbidden, fmt.Sprintf("allocation of %s is older than %v", allocation.Username, c.limits.MaxAllocationLifetime)
+}
+
+func (c *Quota) checkPermissions(username string, addr net.Addr, peers []string) (stun.ErrorCode, string) {
+	if c.limits.MaxPermissionsPerUser == 0 {
+		return 0, ""
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	allocation, ok := c.allocations[addr.String()]
+	if !ok {
+		return 0, ""
+	}
+
+	added := 0
+	for _, peer := range peers {
+		if _, ok := allocation.permissions[peer]; !ok {
+			added++
+		}
+	}
+	if added == 0 {
+		return 0, ""
+	}
+
+	user := metricsUser(username)
+	count := 0
+	for _, other := range c.allocations {
+		if metricsUser(other.Username) == user {
+			count += len(other.permissions)
+		}
+	}
+	if count+added > c.limits.MaxPermissionsPerUser {
+		c.rejected.Permissions++
+		return stun.CodeInsufficientCapacity, fmt.Sprintf("user %s has %d permissions", user, count)
+	}
+	return 0, ""
+}
+
+// allowData는 allocation 의 사용자 대역폭과 수명을 확인한다.
+func (c *Quota) allowData(addr net.Addr, size int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	allocation, ok := c.allocations[addr.String()]
+	if !ok {
+		return true
+	}
+
+	if c.limits.MaxAllocationLifetime > 0 && time.Since(allocation.CreatedAt) > c.limits.MaxAllocationLifetime {
+		c.rejected.DroppedBytes += uint64(size)
+		return false
+	}
+
+	user := metricsUser(allocation.Username)
+	if c.limits.BandwidthPerUser == 0 {
+		c.stats.RelayedBytes[user] += uint64(size)
+		return true
+	}
+	bucket, ok := c.buckets[user]
+	if !ok {
+		// 1초 분량까지 몰아서 보낼 수 있다.
+		bucket = newTokenBucket(float64(c.limits.BandwidthPerUser), float64(c.limits.BandwidthPerUser))
+		c.buckets[user] = bucket
+	}
+	if !bucket.take(float64(size), time.Now()) {
+		c.rejected.DroppedBytes += uint64(size)
+		return false
+	}
+	c.stats.RelayedBytes[user] += uint64(size)
+	return true
+}
+
+func (c *Quota) reject(conn net.PacketConn, m *stun.Message, addr net.Addr, code stun.ErrorCode, reason string) {
+	fmt.Printf("turnguard: rejected %s from %v: %d %s\n", m.Type, addr, code, reason)
+
+	response, err := stun.Build(
+		stun.NewTransactionIDSetter(m.TransactionID),
+		stun.NewType(m.Type.Method, stun.ClassErrorResponse),
+		code,
+		stun.Fingerprint,
+	)
+	if err != nil {
+		fmt.Printf("turnguard: failed to build error response: %v\n", err)
+		return
+	}
+	if _, err := conn.WriteTo(response.Raw, addr); err != nil {
+		fmt.Printf("turnguard: failed to write error response: %v\n", err)
+	}
+}
+
+func (c *Quota) sweepLoop() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case now := <-ticker.C:
+			c.sweep(now)
+		}
+	}
+}
+
+func (c *Quota) sweep(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, request := range c.pending {
+		if now.After(request.expiresAt) {
+			delete(c.pending, id)
+		}
+	}
+
+	users := make(map[string]bool)
+	for key, allocation := range c.allocations {
+		if now.After(allocation.ExpiresAt) {
+			delete(c.allocations, key)
+			c.stats.AllocationsExpired++
+			continue
+		}
+		users[metricsUser(allocation.Username)] = true
+		for peer, expiresAt := range allocation.permissions {
+			if now.After(expiresAt) {
+				delete(allocation.permissions, peer)
+			}
+		}
+	}
+
+	for username := range c.buckets {
+		if !users[username] {
+			delete(c.buckets, username)
+		}
+	}
+}
+
+func lifetime(m *stun.Message) time.Duration {
+	value, err := m.Get(stun.AttrLifetime)
+	if err != nil || len(value) != 4 {
+		// RFC 8656 기본값
+		return 10 * time.Minute
+	}
+	return time.Duration(binary.BigEndian.Uint32(value)) * time.Second
+}
+
+// peerIPs는 XOR-PEER-ADDRESS 를 모두 읽는다. CreatePermission 에는 여러 개가 올 수 있다.
+func peerIPs(m *stun.Message) []string {
+	var peers []string
+	for _, attr := range m.Attributes {
+		if attr.Type != stun.AttrXORPeerAddress {
+			continue
+		}
+
+		single := &stun.Message{TransactionID: m.TransactionID}
+		single.WriteHeader()
+		single.Add(stun.AttrXORPeerAddress, attr.Value)
+
+		var peer stun.XORMappedAddress
+		if err := peer.GetFromAs(single, stun.AttrXORPeerAddress); err == nil {
+			peers = append(peers, peer.IP.String())
+		}
+	}
+	return peers
+}
+
+func addrIP(addr net.Addr) string {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+type tokenBucket struct {
+	tokens float64
+	rate   float64
+	burst  float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{tokens: burst, rate: rate, burst: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) take(n float64, now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
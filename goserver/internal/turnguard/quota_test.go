@@ -0,0 +1,457 @@
+package turnguard
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3"
+)
+
+var (
+	clientA = &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}
+	clientB = &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 5000}
+	// clientA 와 같은 공유기 뒤의 다른 태블릿
+	clientA2 = &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5001}
+	relayed  = &net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 49152}
+)
+
+type packet struct {
+	data []byte
+	addr net.Addr
+}
+
+// fakePacketConn은 넣어 둔 패킷을 순서대로 읽고, 쓴 패킷을 모아 둔다. 더 읽을 게 없으면 io.EOF 다.
+type fakePacketConn struct {
+	net.PacketConn
+	inbound []packet
+	written []packet
+}
+
+func (c *fakePacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	if len(c.inbound) == 0 {
+		return 0, nil, io.EOF
+	}
+	next := c.inbound[0]
+	c.inbound = c.inbound[1:]
+	return copy(p, next.data), next.addr, nil
+}
+
+func (c *fakePacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.written = append(c.written, packet{data: append([]byte{}, p...), addr: addr})
+	return len(p), nil
+}
+
+type harness struct {
+	t     *testing.T
+	quota *Quota
+	fake  *fakePacketConn
+	conn  *QuotaConn
+}
+
+func newHarness(t *testing.T, limits Limits) *harness {
+	quota := NewQuota(limits)
+	t.Cleanup(quota.Close)
+	fake := &fakePacketConn{}
+	return &harness{t: t, quota: quota, fake: fake, conn: quota.Wrap(fake)}
+}
+
+// receive는 client 가 보낸 패킷을 listener 로 흘리고 pion 이 읽었는지 돌려준다.
+func (h *harness) receive(p []byte, addr net.Addr) bool {
+	h.t.Helper()
+	h.fake.inbound = append(h.fake.inbound, packet{data: p, addr: addr})
+	buf := make([]byte, 1500)
+	n, _, err := h.conn.ReadFrom(buf)
+	if err == io.EOF {
+		return false
+	}
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if string(buf[:n]) != string(p) {
+		h.t.Fatalf("ReadFrom() returned a different packet")
+	}
+	return true
+}
+
+// send는 pion 이 보내는 패킷을 listener 로 흘리고 실제로 나갔는지 돌려준다.
+func (h *harness) send(p []byte, addr net.Addr) bool {
+	h.t.Helper()
+	written := len(h.fake.written)
+	if _, err := h.conn.WriteTo(p, addr); err != nil {
+		h.t.Fatal(err)
+	}
+	return len(h.fake.written) > written
+}
+
+// lastError는 turnguard 가 마지막으로 보낸 에러 응답의 코드다.
+func (h *harness) lastError() stun.ErrorCode {
+	h.t.Helper()
+	if len(h.fake.written) == 0 {
+		h.t.Fatal("no error response")
+	}
+	m := &stun.Message{Raw: h.fake.written[len(h.fake.written)-1].data}
+	if err := m.Decode(); err != nil {
+		h.t.Fatal(err)
+	}
+	var code stun.ErrorCodeAttribute
+	if err := code.GetFrom(m); err != nil {
+		h.t.Fatalf("last packet is not an error response: %v", m)
+	}
+	return code.Code
+}
+
+// allocate는 Allocate 요청과 pion 의 성공 응답을 흘린다. 요청이 거절되면 false 다.
+func (h *harness) allocate(username string, addr net.Addr) bool {
+	h.t.Helper()
+	request := buildMessage(h.t, stun.NewType(stun.MethodAllocate, stun.ClassRequest), stun.NewUsername(username))
+	if !h.receive(request.Raw, addr) {
+		return false
+	}
+	response := reply(h.t, request, stun.ClassSuccessResponse, lifetimeAttr(10*time.Minute), xorAddr{stun.AttrXORRelayedAddress, relayed})
+	if !h.send(response.Raw, addr) {
+		h.t.Fatal("success response was dropped")
+	}
+	return true
+}
+
+type xorAddr struct {
+	attr stun.AttrType
+	addr *net.UDPAddr
+}
+
+func (a xorAddr) AddTo(m *stun.Message) error {
+	return stun.XORMappedAddress{IP: a.addr.IP, Port: a.addr.Port}.AddToAs(m, a.attr)
+}
+
+func lifetimeAttr(lifetime time.Duration) stun.RawAttribute {
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, uint32(lifetime.Seconds()))
+	return stun.RawAttribute{Type: stun.AttrLifetime, Value: value}
+}
+
+func peer(port int) xorAddr {
+	return xorAddr{stun.AttrXORPeerAddress, &net.UDPAddr{IP: net.IPv4(203, 0, 113, byte(port)), Port: port}}
+}
+
+func buildMessage(t *testing.T, setters ...stun.Setter) *stun.Message {
+	t.Helper()
+	m, err := stun.Build(append([]stun.Setter{stun.TransactionID}, setters...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func reply(t *testing.T, request *stun.Message, class stun.MessageClass, setters ...stun.Setter) *stun.Message {
+	t.Helper()
+	return buildMessage(t, append([]stun.Setter{
+		stun.NewTransactionIDSetter(request.TransactionID),
+		stun.NewType(request.Type.Method, class),
+	}, setters...)...)
+}
+
+func channelData(size int) []byte {
+	p := make([]byte, size)
+	binary.BigEndian.PutUint16(p[0:2], 0x4000)
+	binary.BigEndian.PutUint16(p[2:4], uint16(size-4))
+	return p
+}
+
+func TestQuotaAllocations(t *testing.T) {
+	h := newHarness(t, Limits{MaxAllocationsPerUser: 1, MaxAllocationsPerIP: 2})
+
+	if !h.allocate("alice", clientA) {
+		t.Fatal("first allocation of alice was rejected")
+	}
+	if !h.allocate("alice", clientA) {
+		t.Fatal("retransmitted Allocate of an existing allocation was rejected")
+	}
+	if h.allocate("alice", clientB) {
+		t.Fatal("second allocation of alice was accepted")
+	}
+	if code := h.lastError(); code != stun.CodeAllocQuotaReached {
+		t.Fatalf("error code = %d, want %d", code, stun.CodeAllocQuotaReached)
+	}
+	if !h.allocate("bob", clientA2) {
+		t.Fatal("allocation of bob was rejected")
+	}
+	// 같은 IP 에서 세 번째 allocation
+	if h.allocate("carol", &net.UDPAddr{IP: clientA.IP, Port: 5002}) {
+		t.Fatal("third allocation from one IP was accepted")
+	}
+
+	if got := len(h.quota.Allocations()); got != 2 {
+		t.Fatalf("allocations = %d, want 2", got)
+	}
+	if got := h.quota.RejectedCounters().Allocations; got != 2 {
+		t.Fatalf("rejected allocations = %d, want 2", got)
+	}
+}
+
+func TestQuotaFailedAllocateIsNotCounted(t *testing.T) {
+	h := newHarness(t, Limits{MaxAllocationsPerUser: 1})
+
+	request := buildMessage(t, stun.NewType(stun.MethodAllocate, stun.ClassRequest), stun.NewUsername("alice"))
+	if !h.receive(request.Raw, clientA) {
+		t.Fatal("Allocate was rejected")
+	}
+	h.send(reply(t, request, stun.ClassErrorResponse, stun.CodeBadRequest).Raw, clientA)
+
+	if !h.allocate("alice", clientB) {
+		t.Fatal("allocation after a failed Allocate was rejected")
+	}
+	if got := h.quota.Stats().AuthFailures; got != 1 {
+		t.Fatalf("auth failures = %d, want 1", got)
+	}
+}
+
+func TestQuotaRefresh(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxLife    time.Duration
+		lifetime   time.Duration
+		wantPassed bool
+	}{
+		{name: "unlimited", lifetime: 10 * time.Minute, wantPassed: true},
+		{name: "young allocation", maxLife: time.Hour, lifetime: 10 * time.Minute, wantPassed: true},
+		{name: "old allocation", maxLife: time.Millisecond, lifetime: 10 * time.Minute},
+		{name: "old allocation deleting itself", maxLife: time.Millisecond, lifetime: 0, wantPassed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, Limits{MaxAllocationLifetime: tt.maxLife})
+			h.allocate("alice", clientA)
+			time.Sleep(2 * time.Millisecond)
+
+			request := buildMessage(t, stun.NewType(stun.MethodRefresh, stun.ClassRequest), stun.NewUsername("alice"), lifetimeAttr(tt.lifetime))
+			if got := h.receive(request.Raw, clientA); got != tt.wantPassed {
+				t.Fatalf("Refresh passed = %v, want %v", got, tt.wantPassed)
+			}
+			if !tt.wantPassed {
+				if code := h.lastError(); code != stun.CodeForbidden {
+					t.Fatalf("error code = %d, want %d", code, stun.CodeForbidden)
+				}
+				return
+			}
+
+			h.send(reply(t, request, stun.ClassSuccessResponse, lifetimeAttr(tt.lifetime)).Raw, clientA)
+			wantAllocations := 1
+			if tt.lifetime == 0 {
+				wantAllocations = 0
+			}
+			if got := len(h.quota.Allocations()); got != wantAllocations {
+				t.Fatalf("allocations = %d, want %d", got, wantAllocations)
+			}
+		})
+	}
+}
+
+func TestQuotaOldAllocationDropsData(t *testing.T) {
+	h := newHarness(t, Limits{MaxAllocationLifetime: time.Millisecond})
+	h.allocate("alice", clientA)
+	time.Sleep(2 * time.Millisecond)
+
+	if h.receive(channelData(100), clientA) {
+		t.Fatal("ChannelData of an old allocation passed")
+	}
+	if h.send(channelData(100), clientA) {
+		t.Fatal("ChannelData to an old allocation was sent")
+	}
+	if got := h.quota.RejectedCounters().DroppedBytes; got != 200 {
+		t.Fatalf("dropped bytes = %d, want 200", got)
+	}
+}
+
+func TestQuotaPermissions(t *testing.T) {
+	h := newHarness(t, Limits{MaxPermissionsPerUser: 2})
+	h.allocate("alice", clientA)
+
+	createPermission := func(method stun.Method, peers ...stun.Setter) bool {
+		t.Helper()
+		request := buildMessage(t, append([]stun.Setter{stun.NewType(method, stun.ClassRequest), stun.NewUsername("alice")}, peers...)...)
+		if !h.receive(request.Raw, clientA) {
+			return false
+		}
+		h.send(reply(t, request, stun.ClassSuccessResponse).Raw, clientA)
+		return true
+	}
+
+	if !createPermission(stun.MethodCreatePermission, peer(1), peer(2)) {
+		t.Fatal("CreatePermission with two peers was rejected")
+	}
+	if !createPermission(stun.MethodCreatePermission, peer(1)) {
+		t.Fatal("refreshing an existing permission was rejected")
+	}
+	if !createPermission(stun.MethodChannelBind, peer(2)) {
+		t.Fatal("ChannelBind to a permitted peer was rejected")
+	}
+	if createPermission(stun.MethodCreatePermission, peer(3)) {
+		t.Fatal("third permission was accepted")
+	}
+	if code := h.lastError(); code != stun.CodeInsufficientCapacity {
+		t.Fatalf("error code = %d, want %d", code, stun.CodeInsufficientCapacity)
+	}
+	if createPermission(stun.MethodChannelBind, peer(3)) {
+		t.Fatal("ChannelBind to a third peer was accepted")
+	}
+
+	if got := h.quota.Allocations()[0].Permissions; got != 2 {
+		t.Fatalf("permissions = %d, want 2", got)
+	}
+	if got := h.quota.RejectedCounters().Permissions; got != 2 {
+		t.Fatalf("rejected permissions = %d, want 2", got)
+	}
+}
+
+func TestQuotaBandwidth(t *testing.T) {
+	// 1초 분량 1000 바이트까지 몰아서 보낼 수 있다. 1ms 에 1 바이트씩 채워지므로
+	// 넘치는 packet 은 테스트가 1초 가까이 멈춰도 넘치게 크게 만든다.
+	h := newHarness(t, Limits{BandwidthPerUser: 1000})
+	h.allocate("alice", clientA)
+
+	if !h.receive(channelData(900), clientA) {
+		t.Fatal("first ChannelData was dropped")
+	}
+	if h.send(channelData(1000), clientA) {
+		t.Fatal("ChannelData over the bandwidth was sent")
+	}
+	if !h.receive(channelData(50), clientA) {
+		t.Fatal("ChannelData within the bandwidth was dropped")
+	}
+
+	send := buildMessage(t, stun.NewType(stun.MethodSend, stun.ClassIndication), peer(1), stun.RawAttribute{Type: stun.AttrData, Value: make([]byte, 1200)})
+	if h.receive(send.Raw, clientA) {
+		t.Fatal("Send indication over the bandwidth passed")
+	}
+	data := buildMessage(t, stun.NewType(stun.MethodData, stun.ClassIndication), peer(1), stun.RawAttribute{Type: stun.AttrData, Value: make([]byte, 1200)})
+	if h.send(data.Raw, clientA) {
+		t.Fatal("Data indication over the bandwidth was sent")
+	}
+	// allocation 이 없는 주소는 제한하지 않는다.
+	if !h.receive(channelData(1400), clientB) {
+		t.Fatal("ChannelData without an allocation was dropped")
+	}
+
+	if got, want := h.quota.RejectedCounters().DroppedBytes, uint64(1000+len(send.Raw)+len(data.Raw)); got != want {
+		t.Fatalf("dropped bytes = %d, want %d", got, want)
+	}
+	if got := h.quota.Stats().RelayedBytes["alice"]; got != 950 {
+		t.Fatalf("relayed bytes = %d, want 950", got)
+	}
+}
+
+func TestQuotaTemporaryCredentials(t *testing.T) {
+	// /api/ice-servers 가 발급할 때마다 만료시각이 다른 "만료시각:사용자" 계정이 나온다.
+	h := newHarness(t, Limits{MaxAllocationsPerUser: 2, MaxPermissionsPerUser: 2, BandwidthPerUser: 1000})
+	first, second, third := "1700000000:alice", "1700000600:alice", "1700001200:alice"
+
+	if !h.allocate(first, clientA) || !h.allocate(second, clientB) {
+		t.Fatal("allocations within the cap were rejected")
+	}
+	if h.allocate(third, clientA2) {
+		t.Fatal("third allocation of alice with a new credential was accepted")
+	}
+
+	createPermission := func(username string, addr net.Addr, port int) bool {
+		t.Helper()
+		request := buildMessage(t, stun.NewType(stun.MethodCreatePermission, stun.ClassRequest), stun.NewUsername(username), peer(port))
+		if !h.receive(request.Raw, addr) {
+			return false
+		}
+		h.send(reply(t, request, stun.ClassSuccessResponse).Raw, addr)
+		return true
+	}
+	if !createPermission(first, clientA, 1) || !createPermission(second, clientB, 2) {
+		t.Fatal("permissions within the cap were rejected")
+	}
+	if createPermission(second, clientB, 3) {
+		t.Fatal("third permission of alice with another credential was accepted")
+	}
+
+	// 두 계정이 bucket 하나를 나눠 쓴다.
+	if !h.receive(channelData(900), clientA) {
+		t.Fatal("first ChannelData was dropped")
+	}
+	if h.receive(channelData(1000), clientB) {
+		t.Fatal("ChannelData over the shared bandwidth passed")
+	}
+	if got := h.quota.Stats().RelayedBytes["alice"]; got != 900 {
+		t.Fatalf("relayed bytes = %d, want 900", got)
+	}
+}
+
+func TestQuotaListener(t *testing.T) {
+	quota := NewQuota(Limits{MaxAllocationsPerUser: 1})
+	defer quota.Close()
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped := quota.WrapListener(listener)
+	defer wrapped.Close()
+
+	accept := func() (*turn.STUNConn, *turn.STUNConn) {
+		t.Helper()
+		client, err := net.Dial("tcp4", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		server, err := wrapped.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { server.Close() })
+		deadline := time.Now().Add(5 * time.Second)
+		client.SetDeadline(deadline)
+		server.SetDeadline(deadline)
+		// pion 과 같이 stream 을 STUNConn 으로 읽는다.
+		return turn.NewSTUNConn(client), turn.NewSTUNConn(server)
+	}
+	read := func(conn *turn.STUNConn) *stun.Message {
+		t.Helper()
+		buf := make([]byte, 1500)
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := &stun.Message{Raw: buf[:n]}
+		if err := m.Decode(); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	client1, server1 := accept()
+	request := buildMessage(t, stun.NewType(stun.MethodAllocate, stun.ClassRequest), stun.NewUsername("alice"))
+	// frame 이 나뉘어 와도 하나로 읽는다.
+	client1.WriteTo(request.Raw[:10], nil)
+	client1.WriteTo(request.Raw[10:], nil)
+	if got := read(server1); got.TransactionID != request.TransactionID {
+		t.Fatalf("read %v, want Allocate", got)
+	}
+	server1.WriteTo(reply(t, request, stun.ClassSuccessResponse, lifetimeAttr(10*time.Minute)).Raw, nil)
+	if got := read(client1); got.Type.Class != stun.ClassSuccessResponse {
+		t.Fatalf("client read %v, want success response", got)
+	}
+
+	client2, server2 := accept()
+	rejected := buildMessage(t, stun.NewType(stun.MethodAllocate, stun.ClassRequest), stun.NewUsername("alice"))
+	binding := buildMessage(t, stun.BindingRequest)
+	// 거절된 frame 바로 뒤에 붙어 온 frame 은 그대로 읽는다.
+	client2.WriteTo(append(append([]byte{}, rejected.Raw...), binding.Raw...), nil)
+	if got := read(server2); got.TransactionID != binding.TransactionID {
+		t.Fatalf("read %v, want Binding request", got)
+	}
+	response := read(client2)
+	var code stun.ErrorCodeAttribute
+	if err := code.GetFrom(response); err != nil || code.Code != stun.CodeAllocQuotaReached {
+		t.Fatalf("client read %v, want %d error response", response, stun.CodeAllocQuotaReached)
+	}
+}
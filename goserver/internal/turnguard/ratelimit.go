@@ -0,0 +1,186 @@
+package turnguard
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/stun/v2"
+)
+
+type RateLimits struct {
+	// 출발지 IP 별로 초당 받을 STUN 요청 수. 0 이면 제한 없음
+	// 행사장 공유기 뒤의 태블릿은 IP 하나를 같이 쓰므로 넉넉하게 잡는다.
+	RequestsPerSecond float64
+	Burst             float64
+	// sweep 주기 안에 이만큼 버려지면 BanDuration 동안 그 IP 의 STUN 요청을 모두 버린다.
+	// 이미 있는 allocation 의 ChannelData 와 indication 은 계속 통과시킨다.
+	BanThreshold int
+	BanDuration  time.Duration
+}
+
+// NewRateLimits는 초당 요청 수에서 나머지 값을 정한다.
+// 잠깐 몰리는 건 두 배까지 받고, sweep 주기 동안 계속 넘으면 차단한다.
+func NewRateLimits(requestsPerSecond float64, banDuration time.Duration) RateLimits {
+	return RateLimits{
+		RequestsPerSecond: requestsPerSecond,
+		Burst:             2 * requestsPerSecond,
+		BanThreshold:      int(requestsPerSecond * sweepInterval.Seconds() / 2),
+		BanDuration:       banDuration,
+	}
+}
+
+type RateLimitCounters struct {
+	// 요청 한도를 넘어 버린 패킷
+	DroppedRequests uint64
+	// 차단된 IP 에서 와서 버린 요청
+	DroppedBanned uint64
+	Bans          uint64
+	ActiveBans    int
+}
+
+type source struct {
+	bucket      *tokenBucket
+	strikes     int
+	bannedUntil time.Time
+	lastSeen    time.Time
+}
+
+// RateLimiter는 출발지 IP 별로 STUN 요청 수를 제한해서
+// 서버가 반사 공격(amplification) 에 쓰이거나 요청 폭주로 멈추지 않게 한다.
+// ChannelData 와 indication 은 세지 않아서 relay 하는 영상에는 영향이 없다.
+type RateLimiter struct {
+	limits RateLimits
+
+	mu       sync.Mutex
+	sources  map[string]*source
+	counters RateLimitCounters
+
+	closed chan struct{}
+	once   sync.Once
+}
+
+func NewRateLimiter(limits RateLimits) *RateLimiter {
+	l := &RateLimiter{
+		limits:  limits,
+		sources: make(map[string]*source),
+		closed:  make(chan struct{}),
+	}
+	go l.sweepLoop()
+	return l
+}
+
+func (l *RateLimiter) Close() {
+	l.once.Do(func() { close(l.closed) })
+}
+
+// RateLimitedConn은 한도를 넘은 패킷을 읽지 않고 버리는 listener 다.
+type RateLimitedConn struct {
+	net.PacketConn
+	limiter *RateLimiter
+}
+
+func (l *RateLimiter) Wrap(conn net.PacketConn) *RateLimitedConn {
+	return &RateLimitedConn{PacketConn: conn, limiter: l}
+}
+
+func (c *RateLimitedConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil {
+			return n, addr, err
+		}
+		if c.limiter.allow(p[:n], addr, time.Now()) {
+			return n, addr, nil
+		}
+	}
+}
+
+// Counters는 지금까지 버린 패킷 수와 차단 횟수를 돌려준다.
+func (l *RateLimiter) Counters() RateLimitCounters {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	counters := l.counters
+	now := time.Now()
+	for _, s := range l.sources {
+		if now.Before(s.bannedUntil) {
+			counters.ActiveBans++
+		}
+	}
+	return counters
+}
+
+func isRequest(p []byte) bool {
+	if !stun.IsMessage(p) {
+		return false
+	}
+	var t stun.MessageType
+	// 헤더 앞 두 바이트가 message type 이다.
+	t.ReadValue(uint16(p[0])<<8 | uint16(p[1]))
+	return t.Class == stun.ClassRequest
+}
+
+// allow는 요청만 센다. 같은 공유기 뒤의 다른 태블릿이 relay 하던 영상은 차단되어도 끊기지 않는다.
+func (l *RateLimiter) allow(p []byte, addr net.Addr, now time.Time) bool {
+	if l.limits.RequestsPerSecond == 0 || !isRequest(p) {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ip := addrIP(addr)
+	s, ok := l.sources[ip]
+	if ok && now.Before(s.bannedUntil) {
+		l.counters.DroppedBanned++
+		return false
+	}
+
+	if !ok {
+		s = &source{bucket: newTokenBucket(l.limits.RequestsPerSecond, l.limits.Burst)}
+		l.sources[ip] = s
+	}
+	s.lastSeen = now
+	if s.bucket.take(1, now) {
+		return true
+	}
+
+	l.counters.DroppedRequests++
+	s.strikes++
+	if l.limits.BanThreshold > 0 && s.strikes >= l.limits.BanThreshold {
+		s.bannedUntil = now.Add(l.limits.BanDuration)
+		s.strikes = 0
+		l.counters.Bans++
+		fmt.Printf("turnguard: banned %s for %v after too many requests\n", ip, l.limits.BanDuration)
+	}
+	return false
+}
+
+func (l *RateLimiter) sweepLoop() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.closed:
+			return
+		case now := <-ticker.C:
+			l.sweep(now)
+		}
+	}
+}
+
+// sweep은 strike 를 주기마다 초기화하고 조용해진 IP 를 잊는다.
+func (l *RateLimiter) sweep(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for ip, s := range l.sources {
+		s.strikes = 0
+		if now.After(s.bannedUntil) && now.Sub(s.lastSeen) > sweepInterval {
+			delete(l.sources, ip)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package turnguard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/stun/v2"
+)
+
+func newTestRateLimiter(t *testing.T) *RateLimiter {
+	// 초당 10개, 20개까지 몰아서, sweep 주기 안에 5번 넘으면 1분 차단
+	limiter := NewRateLimiter(RateLimits{RequestsPerSecond: 10, Burst: 20, BanThreshold: 5, BanDuration: time.Minute})
+	t.Cleanup(limiter.Close)
+	return limiter
+}
+
+// start는 bucket 을 만든 시각보다 늦은 시각이다. bucket 은 time.Now() 부터 채워진다.
+func start() time.Time {
+	return time.Now().Add(time.Second)
+}
+
+func TestRateLimiterStrikesAndBan(t *testing.T) {
+	limiter := newTestRateLimiter(t)
+	request := buildMessage(t, stun.BindingRequest).Raw
+	now := start()
+
+	for i := 0; i < 20; i++ {
+		if !limiter.allow(request, clientA, now) {
+			t.Fatalf("request %d within the burst was dropped", i)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		if limiter.allow(request, clientA, now) {
+			t.Fatalf("request %d over the burst passed", i)
+		}
+	}
+	if got := limiter.Counters(); got.DroppedRequests != 4 || got.Bans != 0 {
+		t.Fatalf("counters = %+v, want 4 dropped and no ban", got)
+	}
+
+	// 100ms 에 하나씩 채워진다.
+	now = now.Add(100 * time.Millisecond)
+	if !limiter.allow(request, clientA, now) {
+		t.Fatal("request after a refill was dropped")
+	}
+	if limiter.allow(request, clientA, now) {
+		t.Fatal("second request after one refill passed")
+	}
+	if got := limiter.Counters(); got.Bans != 1 || got.ActiveBans != 1 {
+		t.Fatalf("counters = %+v, want a ban after 5 strikes", got)
+	}
+
+	// 차단되면 bucket 이 다시 차도 요청을 버린다.
+	now = now.Add(59 * time.Second)
+	if limiter.allow(request, clientA, now) {
+		t.Fatal("request from a banned IP passed")
+	}
+	if limiter.allow(request, clientA2, now) {
+		t.Fatal("request from another port of a banned IP passed")
+	}
+	if !limiter.allow(request, clientB, now) {
+		t.Fatal("request from another IP was dropped")
+	}
+	if got := limiter.Counters().DroppedBanned; got != 2 {
+		t.Fatalf("dropped banned = %d, want 2", got)
+	}
+
+	now = now.Add(time.Second)
+	if !limiter.allow(request, clientA, now) {
+		t.Fatal("request after the ban was dropped")
+	}
+}
+
+func TestRateLimiterBannedIPKeepsRelaying(t *testing.T) {
+	limiter := newTestRateLimiter(t)
+	request := buildMessage(t, stun.BindingRequest).Raw
+	now := start()
+	for i := 0; i < 25; i++ {
+		limiter.allow(request, clientA, now)
+	}
+	if got := limiter.Counters().Bans; got != 1 {
+		t.Fatalf("bans = %d, want 1", got)
+	}
+
+	send := buildMessage(t, stun.NewType(stun.MethodSend, stun.ClassIndication), peer(1), stun.RawAttribute{Type: stun.AttrData, Value: []byte("frame")}).Raw
+	response := buildMessage(t, stun.NewType(stun.MethodBinding, stun.ClassSuccessResponse)).Raw
+	for name, p := range map[string][]byte{"ChannelData": channelData(100), "Send indication": send, "response": response} {
+		if !limiter.allow(p, clientA, now) {
+			t.Errorf("%s from a banned IP was dropped", name)
+		}
+	}
+	refresh := buildMessage(t, stun.NewType(stun.MethodRefresh, stun.ClassRequest)).Raw
+	if limiter.allow(refresh, clientA, now) {
+		t.Error("Refresh request from a banned IP passed")
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	limiter := newTestRateLimiter(t)
+	request := buildMessage(t, stun.BindingRequest).Raw
+	now := start()
+
+	for i := 0; i < 24; i++ {
+		limiter.allow(request, clientA, now)
+	}
+	// strike 는 sweep 주기마다 처음부터 센다.
+	limiter.sweep(now)
+	for i := 0; i < 4; i++ {
+		limiter.allow(request, clientA, now)
+	}
+	if got := limiter.Counters(); got.DroppedRequests != 8 || got.Bans != 0 {
+		t.Fatalf("counters = %+v, want 8 dropped and no ban", got)
+	}
+
+	limiter.sweep(now.Add(sweepInterval))
+	if got := len(limiter.sources); got != 1 {
+		t.Fatalf("sources = %d, want 1 right after the last request", got)
+	}
+	limiter.sweep(now.Add(sweepInterval + time.Second))
+	if got := len(limiter.sources); got != 0 {
+		t.Fatalf("sources = %d, want a quiet IP forgotten", got)
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	limiter := NewRateLimiter(NewRateLimits(0, time.Minute))
+	defer limiter.Close()
+	request := buildMessage(t, stun.BindingRequest).Raw
+	now := start()
+
+	for i := 0; i < 1000; i++ {
+		if !limiter.allow(request, clientA, now) {
+			t.Fatal("request was dropped without a limit")
+		}
+	}
+}
+
+func TestNewRateLimits(t *testing.T) {
+	limits := NewRateLimits(50, time.Minute)
+	// sweep 주기 10초 동안 절반 넘게 버려지면 차단
+	if limits.Burst != 100 || limits.BanThreshold != 250 || limits.BanDuration != time.Minute {
+		t.Fatalf("NewRateLimits() = %+v", limits)
+	}
+}
@@ -0,0 +1,44 @@
+package turnguard
+
+import (
+	"maps"
+	"strings"
+)
+
+// Stats는 listener 를 지나간 메시지로 센 누적 통계다.
+type Stats struct {
+	BindingRequests    uint64
+	AllocationsCreated uint64
+	// 만료되거나 lifetime 0 으로 Refresh 해서 없어진 allocation 수
+	AllocationsExpired uint64
+	// username 을 보낸 요청에 401/400 으로 답한 수
+	AuthFailures uint64
+	// 사용자별로 relay 한 바이트 수 (client 방향과 peer 방향 합)
+	RelayedBytes map[string]uint64
+}
+
+// Stats는 지금까지의 통계 복사본을 돌려준다.
+func (c *Quota) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.RelayedBytes = maps.Clone(c.stats.RelayedBytes)
+	return stats
+}
+
+func (c *Quota) countBindingRequest() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.BindingRequests++
+}
+
+// metricsUser는 임시 계정 "만료시각:사용자" 에서 사용자만 남긴다.
+// 그대로 쓰면 계정을 발급할 때마다 metric label 이 늘어나고 사용자별 quota 도 새로 받는다.
+func metricsUser(username string) string {
+	if i := strings.Index(username, ":"); i >= 0 {
+		return username[i+1:]
+	}
+	return username
+}
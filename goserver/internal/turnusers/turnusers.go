@@ -0,0 +1,188 @@
+// Package turnusers는 TURN 서버의 고정 사용자 목록을 파일에 저장하고 읽는다.
+// 비밀번호는 저장하지 않고 realm 별 auth key (turn.GenerateAuthKey) 만 저장한다.
+package turnusers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pion/turn/v3"
+)
+
+var (
+	ErrNotFound = errors.New("user not found")
+)
+
+type User struct {
+	Username string `json:"username"`
+	Realm    string `json:"realm"`
+	// hex(md5(username:realm:password))
+	Key      string `json:"key"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+type File struct {
+	Users []User `json:"users"`
+}
+
+func userID(username, realm string) string {
+	return username + "@" + realm
+}
+
+// Load는 사용자 파일을 읽는다. 파일이 없으면 os.ErrNotExist 를 감싼 error 를 돌려준다.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users file: %w", err)
+	}
+
+	var file File
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse users file: %w", err)
+	}
+	for _, user := range file.Users {
+		if _, err := hex.DecodeString(user.Key); err != nil {
+			return nil, fmt.Errorf("invalid key of user %s: %w", userID(user.Username, user.Realm), err)
+		}
+	}
+
+	return &file, nil
+}
+
+// Save는 임시 파일에 쓴 뒤 rename 해서 읽는 쪽이 반쯤 쓰인 파일을 보지 않게 한다.
+func (f *File) Save(path string) error {
+	sort.Slice(f.Users, func(i, j int) bool {
+		return userID(f.Users[i].Username, f.Users[i].Realm) < userID(f.Users[j].Username, f.Users[j].Realm)
+	})
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal users file: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".turnusers-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write users file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write users file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+		return fmt.Errorf("failed to chmod users file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace users file: %w", err)
+	}
+
+	return nil
+}
+
+func (f *File) find(username, realm string) int {
+	for i, user := range f.Users {
+		if user.Username == username && user.Realm == realm {
+			return i
+		}
+	}
+	return -1
+}
+
+// Set은 사용자를 추가하거나 비밀번호를 바꾼다. 바뀐 사용자는 다시 활성화된다.
+func (f *File) Set(username, realm, password string) {
+	user := User{
+		Username: username,
+		Realm:    realm,
+		Key:      hex.EncodeToString(turn.GenerateAuthKey(username, realm, password)),
+	}
+
+	if i := f.find(username, realm); i >= 0 {
+		f.Users[i] = user
+		return
+	}
+	f.Users = append(f.Users, user)
+}
+
+func (f *File) Remove(username, realm string) error {
+	i := f.find(username, realm)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, userID(username, realm))
+	}
+	f.Users = append(f.Users[:i], f.Users[i+1:]...)
+	return nil
+}
+
+func (f *File) SetDisabled(username, realm string, disabled bool) error {
+	i := f.find(username, realm)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, userID(username, realm))
+	}
+	f.Users[i].Disabled = disabled
+	return nil
+}
+
+// Store는 TURN 서버가 인증할 때 쓰는 메모리 상의 사용자 목록이다.
+// Reload 로 바꿔 끼워도 이미 만들어진 allocation 에는 영향이 없다.
+type Store struct {
+	path string
+	keys map[string][]byte
+	mu   sync.RWMutex
+}
+
+func NewStore(path string) (*Store, error) {
+	store := &Store{path: path}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Reload는 파일을 다시 읽는다. 실패하면 이전 목록을 그대로 쓴다.
+func (s *Store) Reload() error {
+	file, err := Load(s.path)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string][]byte, len(file.Users))
+	for _, user := range file.Users {
+		if user.Disabled {
+			continue
+		}
+		// Load 에서 검사했으므로 실패하지 않음
+		key, _ := hex.DecodeString(user.Key)
+		keys[userID(user.Username, user.Realm)] = key
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+
+	return nil
+}
+
+// AuthKey는 활성화된 사용자의 key 를 돌려준다.
+func (s *Store) AuthKey(username, realm string) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[userID(username, realm)]
+	return key, ok
+}
+
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.keys)
+}
@@ -0,0 +1,169 @@
+package turnusers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pion/turn/v3"
+)
+
+func TestFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	var file File
+	file.Set("bob", "firehunter", "hunter2")
+	file.Set("alice", "firehunter", "old")
+	// 비밀번호를 바꾸면 다시 활성화된다.
+	if err := file.SetDisabled("alice", "firehunter", true); err != nil {
+		t.Fatal(err)
+	}
+	file.Set("alice", "firehunter", "secret")
+	file.Set("alice", "other", "secret")
+	if err := file.SetDisabled("bob", "firehunter", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("mode = %v, want 0600", info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) || bytes.Contains(data, []byte("hunter2")) {
+		t.Fatalf("users file has a password:\n%s", data)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Save 는 username@realm 순으로 정렬한다.
+	want := []struct {
+		username, realm, password string
+		disabled                  bool
+	}{
+		{"alice", "firehunter", "secret", false},
+		{"alice", "other", "secret", false},
+		{"bob", "firehunter", "hunter2", true},
+	}
+	if len(loaded.Users) != len(want) {
+		t.Fatalf("%d users, want %d: %+v", len(loaded.Users), len(want), loaded.Users)
+	}
+	for i, w := range want {
+		user := loaded.Users[i]
+		key := turn.GenerateAuthKey(w.username, w.realm, w.password)
+		if user.Username != w.username || user.Realm != w.realm || user.Disabled != w.disabled || user.Key != hex.EncodeToString(key) {
+			t.Errorf("user %d = %+v, want %+v", i, user, w)
+		}
+	}
+}
+
+func TestFileNotFound(t *testing.T) {
+	var file File
+	file.Set("alice", "firehunter", "secret")
+	if err := file.Remove("alice", "other"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Remove() error = %v, want ErrNotFound", err)
+	}
+	if err := file.SetDisabled("bob", "firehunter", true); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("SetDisabled() error = %v, want ErrNotFound", err)
+	}
+	if err := file.Remove("alice", "firehunter"); err != nil || len(file.Users) != 0 {
+		t.Fatalf("Remove() error = %v, users = %+v", err, file.Users)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Load(filepath.Join(dir, "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Load() of a missing file error = %v, want os.ErrNotExist", err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "bad json", content: `{"users":`},
+		{name: "bad key", content: `{"users":[{"username":"alice","realm":"firehunter","key":"zz"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Load(path); err == nil {
+				t.Fatal("Load() succeeded")
+			}
+		})
+	}
+}
+
+func TestStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	var file File
+	file.Set("alice", "firehunter", "secret")
+	file.Set("bob", "firehunter", "hunter2")
+	if err := file.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", store.Len())
+	}
+	key, ok := store.AuthKey("alice", "firehunter")
+	if !ok || !bytes.Equal(key, turn.GenerateAuthKey("alice", "firehunter", "secret")) {
+		t.Fatalf("AuthKey() = %x, %v", key, ok)
+	}
+	if _, ok := store.AuthKey("alice", "other"); ok {
+		t.Fatal("AuthKey() found alice in another realm")
+	}
+
+	// 비활성화하고 다시 읽으면 인증하지 않는다.
+	if err := file.SetDisabled("bob", "firehunter", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.AuthKey("bob", "firehunter"); !ok {
+		t.Fatal("store changed before Reload()")
+	}
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.AuthKey("bob", "firehunter"); ok || store.Len() != 1 {
+		t.Fatalf("disabled user is still allowed, Len() = %d", store.Len())
+	}
+
+	// 깨진 파일로 Reload 가 실패하면 이전 목록을 그대로 쓴다.
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err == nil {
+		t.Fatal("Reload() of a broken file succeeded")
+	}
+	if _, ok := store.AuthKey("alice", "firehunter"); !ok || store.Len() != 1 {
+		t.Fatalf("failed Reload() changed the store, Len() = %d", store.Len())
+	}
+}
+
+func TestNewStoreMissingFile(t *testing.T) {
+	if _, err := NewStore(filepath.Join(t.TempDir(), "users.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("NewStore() error = %v, want os.ErrNotExist", err)
+	}
+}
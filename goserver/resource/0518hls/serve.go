@@ -12,11 +12,14 @@ import (
 	"flag"
 	"log"
 	"net/http"
+
+	"server.firehunter.juhyung.dev/internal/moviecatalog"
 )
 
 func main() {
 	port := flag.String("p", "8000", "port to serve on")
 	directory := flag.String("d", ".", "the directory of static file to host")
+	catalogPath := flag.String("catalog", "", "movie catalog file served at /api/movies. empty disables it")
 	flag.Parse()
 
 	
@@ -24,6 +27,16 @@ func main() {
 	corsEnabledFileServer := addCORS(fileServer)
 
 	http.Handle("/", corsEnabledFileServer)
+	if *catalogPath != "" {
+		catalog, err := moviecatalog.Load(*catalogPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mux := http.NewServeMux()
+		catalog.RegisterHandler(mux)
+		http.Handle("/api/movies", addCORS(mux))
+		http.Handle("/api/movies/", addCORS(mux))
+	}
 
 	log.Printf("Serving %s on HTTP port: %s\n", *directory, *port)
 	log.Fatal(http.ListenAndServe(":"+*port, nil))
@@ -0,0 +1,57 @@
+import { useEffect, useState } from "preact/hooks";
+
+// goserver/resource/movies.json 과 같은 모양. 서버의 GET /api/movies 로 받는다.
+export type Movie = {
+  id: string;
+  title: string;
+  codec: string;
+  durationSec: number;
+  fps: number;
+  projection: "equirectangular" | "flat";
+  renditions: {
+    ivf?: string;
+    h264?: string;
+    hls?: string;
+    mp4?: string;
+    audio?: string;
+    // 화질이 높은 것부터. 리소스 서버가 peer 마다 골라서 보낸다.
+    simulcast?: Layer[];
+  };
+};
+
+export type Layer = {
+  name: string;
+  height?: number;
+  bitrate?: number;
+  file: string;
+};
+
+// 파일 서버는 resource 디렉터리를 /videos/ 로 연다. rendition 경로는 그 안의 경로다.
+export function renditionUrl(fileServerUrl: string, path: string) {
+  return `${fileServerUrl}/videos/${path}`;
+}
+
+// 받기 전에는 null, 실패하면 Error. apiBaseUrl 이 null 이면 받지 않는다.
+export function useMovies(apiBaseUrl: string | null): Movie[] | null | Error {
+  const [movies, setMovies] = useState<Movie[] | null | Error>(null);
+
+  useEffect(() => {
+    if (apiBaseUrl == null) {
+      return;
+    }
+    fetch(`${apiBaseUrl}/api/movies`)
+      .then(res => {
+        if (!res.ok) {
+          throw new Error(`GET /api/movies ${res.status}`);
+        }
+        return res.json();
+      })
+      .then(catalog => setMovies(catalog.movies))
+      .catch(e => {
+        console.error("failed to load movies", e);
+        setMovies(e instanceof Error ? e : new Error(String(e)));
+      });
+  }, [apiBaseUrl]);
+
+  return movies;
+}
@@ -3,8 +3,10 @@ import { useEffect, useRef, useState } from "preact/hooks";
 // @ts-ignore
 import { Entity, Scene } from "aframe-react";
 import Hls from "hls.js";
+import { renditionUrl, useMovies } from "../../movies";
 
-const sampleVideoUrl = "https://192-168-17-2.i.juhyung.dev:8443/videos/0518hls/0518sample.m3u8";
+// 노트북의 파일 서버 (localhttps). 영화 목록과 HLS 파일을 준다.
+const fileServerUrl = "https://192-168-17-2.i.juhyung.dev:8443";
 
 export function SeventhMovieHLS({ ...props }) {
   // console.log("Movie", props);
@@ -13,6 +15,9 @@ export function SeventhMovieHLS({ ...props }) {
   const videoRef = useRef<HTMLVideoElement>(null);
   const [enableSync, setEnableSync] = useState(true);
   const [fov, setFov] = useState(80);
+  const movies = useMovies(fileServerUrl);
+  const movie = Array.isArray(movies) ? movies.find(movie => movie.id === props.id) : undefined;
+  const sampleVideoUrl = movie?.renditions.hls != null ? renditionUrl(fileServerUrl, movie.renditions.hls) : undefined;
 
   // if (Hls.isSupported()) {
   if (!Hls.isSupported()) {
@@ -26,14 +31,18 @@ export function SeventhMovieHLS({ ...props }) {
       console.log("videoRef is null");
       return;
     }
+    if (sampleVideoUrl == null) {
+      return;
+    }
     if (videoRef.current.canPlayType('application/vnd.apple.mpegurl')) {
       videoRef.current.src = sampleVideoUrl;
     } else {
       const hls = new Hls();
       hls.loadSource(sampleVideoUrl);
       hls.attachMedia(videoRef.current);
+      return () => hls.destroy();
     }
-  }, [videoRef]);
+  }, [videoRef, sampleVideoUrl]);
 
   useEffect(() => {
     const interval = setInterval(() => {
@@ -73,6 +82,17 @@ export function SeventhMovieHLS({ ...props }) {
   }, [target]);
 
 
+  if (movies instanceof Error) {
+    return <div>
+      <h1>영화 목록을 받지 못했습니다: {movies.message}</h1>
+    </div>
+  }
+  if (Array.isArray(movies) && sampleVideoUrl == null) {
+    return <div>
+      <h1>Movie {props.id} 의 HLS 파일이 없습니다.</h1>
+    </div>
+  }
+
   return <div>
     <h1>Movie {props.id}</h1>
     <button onClick={() => route('/')}>Home으로 돌아가기</button>
@@ -2,8 +2,10 @@ import { route } from "preact-router";
 import { useEffect, useRef, useState } from "preact/hooks";
 // @ts-ignore
 import { Entity, Scene } from "aframe-react";
+import { useMovies } from "../../movies";
 
-const pc = new RTCPeerConnection({
+// 새 세션을 받으면 리소스 서버도 새 PeerConnection 을 만들므로 여기서도 바꾼다.
+let pc = new RTCPeerConnection({
   iceServers: [
     {
       urls: "stun:stun.i.juhyung.dev:3478",
@@ -19,12 +21,49 @@ const pc = new RTCPeerConnection({
 
 const signalServerUrl = 'wss://signal-firehunter.i.juhyung.dev/client/ws';
 // const signalServerUrl = 'ws://localhost:8124/client/ws';
+// 영화 목록도 시그널링 서버에서 받는다.
+const signalApiUrl = signalServerUrl.replace(/^ws/, 'http').replace(/\/client\/ws$/, '');
+
+// 와이파이가 잠깐 끊겨도 같은 세션으로 다시 연결하기 위해 저장
+// 세션은 영화 하나에 묶이므로 영화마다 따로 저장한다.
+function sessionTokenKey(movieId: string) {
+  return `firehunter-session-token:${movieId}`;
+}
+
+// 연결하지 못하면 1초부터 두 배씩 늘려 30초까지 기다렸다가 다시 시도한다.
+const reconnectBaseDelay = 1000;
+const reconnectMaxDelay = 30_000;
+let reconnectAttempts = 0;
+
+// 운영자가 태블릿마다 ?token=... 주소로 한 번 열어주면 저장해 두고 계속 쓴다.
+const tabletTokenKey = 'firehunter-tablet-token';
+
+function tabletToken() {
+  const fromUrl = new URLSearchParams(location.search).get('token');
+  if (fromUrl != null) {
+    localStorage.setItem(tabletTokenKey, fromUrl);
+    return fromUrl;
+  }
+  return localStorage.getItem(tabletTokenKey) ?? '';
+}
+
+function signalServerUrlFor(movieId: string) {
+  const token = `token=${encodeURIComponent(tabletToken())}`;
+  const movie = `movieId=${encodeURIComponent(movieId)}`;
+  const sessionToken = sessionStorage.getItem(sessionTokenKey(movieId));
+  if (sessionToken != null) {
+    return `${signalServerUrl}?${token}&${movie}&sessionToken=${encodeURIComponent(sessionToken)}`;
+  }
+  return `${signalServerUrl}?${token}&${movie}`;
+}
 
 
 export function SixthMovie({ ...props }) {
   console.log("SixthMovie", props);
   const [wsOpen, setWsOpen] = useState(false);
   const [ws, setWs] = useState<WebSocket | null>(null);
+  // hook 은 아래의 return 들보다 먼저 불러서 render 마다 같은 수를 부른다.
+  const movies = useMovies(signalApiUrl);
 
   useEffect(() => {
     if (wsOpen === true) {
@@ -34,37 +73,29 @@ export function SixthMovie({ ...props }) {
       return
     }
     console.log("initialize websocket")
-    const ws_ = new WebSocket(signalServerUrl);
+    const ws_ = new WebSocket(signalServerUrlFor(props.id));
     setWs(ws_);
     ws_.onopen = () => {
       console.log('ws.onopen');
+      reconnectAttempts = 0;
       setWsOpen(true);
     }
 
+    // 토큰은 지우지 않는다. 만료된 토큰이면 시그널링 서버가 새 세션을 준다.
     ws_.onclose = () => {
-      console.log('ws.onclose');
+      const delay = Math.min(reconnectBaseDelay * 2 ** reconnectAttempts, reconnectMaxDelay);
+      reconnectAttempts++;
+      console.log('ws.onclose, reconnecting in', delay);
       setWsOpen(false);
+      setTimeout(() => setWs(null), delay);
     }
   }, [wsOpen, ws]);
 
-  if (wsOpen === false) {
-    return <div>
-      <h1>여섯번째 시도</h1>
-      <p>서버와 연결 중입니다.</p>
-    </div>
-  }
-
-
-  if (!["1", "2", "3", "4", "5"].includes(props.id)) {
-    return <div>
-      <h1>여섯번째 시도</h1>
-      <p>잘못된 주소입니다. 주소에서 movie 뒤의 숫자는 1,2,3,4,5 중 하나여야 합니다.</p>
-      <p>현재 movie 뒤의 숫자: {props.id}</p>
-      </div>
-  }
-
   const videoRef = useRef<HTMLVideoElement>(null);
   const [fov, setFov] = useState(80);
+  // 리소스 서버가 이 peer 에 대해 추정한 대역폭과 그에 따라 고른 layer
+  const [bandwidth, setBandwidth] = useState<{ bitrate: number, layer: string, fecOverhead: number } | null>(null);
+  const layers = (Array.isArray(movies) ? movies : []).find(movie => movie.id === props.id)?.renditions.simulcast ?? [];
 
   useEffect(() => {
     if (ws == null) {
@@ -72,6 +103,20 @@ export function SixthMovie({ ...props }) {
     }
     console.log("ThirdHome useEffect");
 
+    // 세션을 이어 붙인 경우 기존 PeerConnection 을 ICE restart 로 살린다.
+    const sendOffer = (resumed: boolean) => {
+      pc.createOffer({ iceRestart: resumed && pc.remoteDescription != null })
+      .then(d => {
+        pc.setLocalDescription((d))
+        console.log("send offer")
+        ws.send(JSON.stringify({
+          type: 'offer',
+          data: d
+        }))
+      })
+      .catch(e => console.error(e));
+    };
+
     ws.onmessage = e => {
       let msg = JSON.parse(e.data)
       if (msg == null) {
@@ -79,6 +124,21 @@ export function SixthMovie({ ...props }) {
         return;
       }
 
+      if (msg.type === 'session') {
+        console.log("session", msg.data.clientId, "resumed", msg.data.resumed);
+        sessionStorage.setItem(sessionTokenKey(props.id), msg.data.token);
+        if (!msg.data.resumed && pc.localDescription != null) {
+          pc.close();
+          pc = new RTCPeerConnection();
+        }
+        bindPeerConnection();
+        // 시그널링 서버가 준 임시 TURN 계정을 포함한 ICE 서버 목록
+        // TURN 서버 없이 후보를 모으지 않도록 적용한 뒤에 offer 를 보낸다.
+        pc.setConfiguration({ iceServers: msg.data.iceServers });
+        sendOffer(msg.data.resumed);
+        return;
+      }
+
       if (msg.type === 'offer') {
         console.error("offer type should not be received");
         return;
@@ -95,62 +155,94 @@ export function SixthMovie({ ...props }) {
         pc.addIceCandidate(msg.data);
         return;
       }
-    };
 
-    pc.ontrack = (event) => {
-      console.log('ontrack', event);
-      const el = document.createElement(event.track.kind) as any
-      el.srcObject = event.streams[0];
-      el.autoplay = true;
-      el.controls = true;
-
-      if (videoRef.current == null) {
-        console.error('videoRootRef.current is null while ontrack');
+      if (msg.type === "error") {
+        console.error("signaling error", msg.data);
         return;
       }
-      if (event.streams.length !== 1) {
-        console.error('event.streams.length !== 1 while ontrack ' + event.streams.length);
-        console.error(event.streams);
+
+      if (msg.type === "bandwidth") {
+        setBandwidth(msg.data);
         return;
       }
-      videoRef.current.srcObject = event.streams[0];
     };
 
-    pc.onicecandidate = (event) => {
-      if (event.candidate != null && event.candidate.candidate != null && event.candidate.candidate.length > 0) {
-        console.log("send candidate")
-        ws.send(JSON.stringify({
-          type: 'candidate',
-          data: event.candidate
-        }));
+    // 새 websocket 으로 보내도록 연결할 때마다 다시 붙인다.
+    const bindPeerConnection = () => {
+      pc.ontrack = (event) => {
+        console.log('ontrack', event);
+        const el = document.createElement(event.track.kind) as any
+        el.srcObject = event.streams[0];
+        el.autoplay = true;
+        el.controls = true;
+
+        if (videoRef.current == null) {
+          console.error('videoRootRef.current is null while ontrack');
+          return;
+        }
+        if (event.streams.length !== 1) {
+          console.error('event.streams.length !== 1 while ontrack ' + event.streams.length);
+          console.error(event.streams);
+          return;
+        }
+        // 영상과 소리가 같은 stream 으로 오므로 한 번만 붙인다.
+        if (videoRef.current.srcObject !== event.streams[0]) {
+          videoRef.current.srcObject = event.streams[0];
+        }
+      };
+
+      pc.onicecandidate = (event) => {
+        if (event.candidate != null && event.candidate.candidate != null && event.candidate.candidate.length > 0) {
+          console.log("send candidate")
+          ws.send(JSON.stringify({
+            type: 'candidate',
+            data: event.candidate
+          }));
+        }
       }
-    }
 
-    pc.oniceconnectionstatechange = (event) => {
-      console.log('oniceconnectionstatechange', ".");
-      console.log(event);
-      // do nothing
-      // console.log('oniceconnectionstatechange', event);
-      // setLogs((prev) => [...prev, `oniceconnectionstatechange: ${pc.iceConnectionState}`]);
-    };
+      pc.oniceconnectionstatechange = (event) => {
+        console.log('oniceconnectionstatechange', ".");
+        console.log(event);
+        // do nothing
+        // console.log('oniceconnectionstatechange', event);
+        // setLogs((prev) => [...prev, `oniceconnectionstatechange: ${pc.iceConnectionState}`]);
+      };
 
-    pc.addTransceiver('video', {
-      direction: 'sendrecv'
-    });
-
-    pc.createOffer()
-    .then(d => {
-      pc.setLocalDescription((d))
-      console.log("send offer")
-      ws.send(JSON.stringify({
-        type: 'offer',
-        data: d
-      }))
-    })
-    .catch(e => console.error(e));
+      if (pc.getTransceivers().length === 0) {
+        pc.addTransceiver('video', {
+          direction: 'sendrecv'
+        });
+        pc.addTransceiver('audio', {
+          direction: 'recvonly'
+        });
+      }
+    };
 
+    // offer 는 session 메시지를 받은 뒤에 보낸다.
   }, [ws])
 
+  if (wsOpen === false) {
+    return <div>
+      <h1>여섯번째 시도</h1>
+      <p>서버와 연결 중입니다.</p>
+    </div>
+  }
+
+
+  if (movies instanceof Error) {
+    return <div>
+      <h1>여섯번째 시도</h1>
+      <p>영화 목록을 받지 못했습니다: {movies.message}</p>
+    </div>
+  }
+  if (movies != null && !movies.some(movie => movie.id === props.id)) {
+    return <div>
+      <h1>여섯번째 시도</h1>
+      <p>잘못된 주소입니다. 주소에서 movie 뒤의 숫자는 {movies.map(movie => movie.id).join(",")} 중 하나여야 합니다.</p>
+      <p>현재 movie 뒤의 숫자: {props.id}</p>
+      </div>
+  }
 
   return <div>
     <h1>여섯번째 시도 {props.id}</h1>
@@ -184,5 +276,23 @@ export function SixthMovie({ ...props }) {
     <button style={{position: "absolute", top: "90%", left: "50%", transform: "translate(-50%, -50%)", zIndex: "9999"}} onClick={() => {
       videoRef.current?.play();
     }}>Play</button>
+    {layers.length > 0 &&
+      // 빈 값은 자동. 리소스 서버가 다음 keyframe 에서 바꾼다.
+      <select style={{position: "absolute", top: "85%", left: "50%", transform: "translate(-50%, -50%)", zIndex: "9999"}} onChange={(e) => {
+        ws?.send(JSON.stringify({
+          type: 'layer',
+          data: { layer: (e.target as HTMLSelectElement).value }
+        }));
+      }}>
+        <option value="">자동</option>
+        {layers.map(layer => <option value={layer.name}>{layer.name}</option>)}
+      </select>
+    }
+    {bandwidth != null &&
+      <p style={{position: "absolute", top: "80%", left: "50%", transform: "translate(-50%, -50%)", zIndex: "9999", color: "white", backgroundColor: "black"}}>
+        {(bandwidth.bitrate / 1_000_000).toFixed(1)} Mbps {bandwidth.layer}
+        {bandwidth.fecOverhead > 0 && ` FEC +${Math.round(bandwidth.fecOverhead * 100)}%`}
+      </p>
+    }
   </div>
 }
@@ -2,50 +2,25 @@ import { route } from "preact-router";
 import { useEffect, useRef, useState } from "preact/hooks";
 // @ts-ignore
 import { Entity, Scene } from "aframe-react";
+import { renditionUrl, useMovies, type Movie } from "../../movies";
 
 export function SixthMovie({ ...props }) {
   console.log("SixthMovie", props);
 
-  if (!["1", "2", "3", "4", "5"].includes(props.id)) {
-    return <div>
-      <h1>여섯번째 시도</h1>
-      <p>잘못된 주소입니다. 주소에서 movie 뒤의 숫자는 1,2,3,4,5 중 하나여야 합니다.</p>
-      <p>현재 movie 뒤의 숫자: {props.id}</p>
-      </div>
-  }
-
   const videoBaseUrl = props.videoBaseUrl;
-  if (!videoBaseUrl) {
-    return <div>
-      <h1>여섯번째 시도</h1>
-      <p>노트북에서 보이는 QR코드로 접속해주세요.</p>
-      <p>{videoBaseUrl}</p>
-    </div>
-  }
-
-  let decodedUrl;
-  try {
-   decodedUrl = atob(videoBaseUrl.replace(/_/g, '/').replace(/-/g, '+'))
-  } catch (err) {
-    if (err instanceof Error) {
-      decodedUrl = "올바르지 않은 주소입니다. " + err.message;
-    } else {
-      decodedUrl = "올바르지 않은 주소입니다. " + err;
+  let decodedUrl: string | null = null;
+  let decodeError = "";
+  if (videoBaseUrl) {
+    try {
+      decodedUrl = atob(videoBaseUrl.replace(/_/g, '/').replace(/-/g, '+'))
+    } catch (err) {
+      decodeError = err instanceof Error ? err.message : String(err);
     }
-
-    return <div>
-      <h1>여섯번째 시도</h1>
-      <p>
-        공유기 안에서 영상을 가져와서 재생합니다.
-      </p>
-      <p>
-        노트북 주소가 잘못되었습니다: {decodedUrl}
-      </p>
-    </div>
   }
 
-  const videoUrl = getMovieFileUrl(props.id as "1" | "2" | "3" | "4" | "5", decodedUrl);
-
+  // hook 은 아래의 return 들보다 먼저 불러서 render 마다 같은 수를 부른다.
+  // 노트북의 파일 서버가 영화 목록도 준다.
+  const movies = useMovies(decodedUrl);
   const [target, setTarget] = useState(0);
   const [current, setCurrent] = useState(0);
   const videoRef = useRef<HTMLVideoElement>(null);
@@ -91,6 +66,47 @@ export function SixthMovie({ ...props }) {
   }, [target]);
 
 
+  if (!videoBaseUrl) {
+    return <div>
+      <h1>여섯번째 시도</h1>
+      <p>노트북에서 보이는 QR코드로 접속해주세요.</p>
+      <p>{videoBaseUrl}</p>
+    </div>
+  }
+  if (decodedUrl == null) {
+    return <div>
+      <h1>여섯번째 시도</h1>
+      <p>
+        공유기 안에서 영상을 가져와서 재생합니다.
+      </p>
+      <p>
+        노트북 주소가 잘못되었습니다: 올바르지 않은 주소입니다. {decodeError}
+      </p>
+    </div>
+  }
+  if (movies == null) {
+    return <div>
+      <h1>여섯번째 시도</h1>
+      <p>영화 목록을 받는 중입니다.</p>
+    </div>
+  }
+  if (movies instanceof Error) {
+    return <div>
+      <h1>여섯번째 시도</h1>
+      <p>영화 목록을 받지 못했습니다: {movies.message}</p>
+    </div>
+  }
+  const movie = movies.find(movie => movie.id === props.id);
+  if (movie == null || movie.renditions.mp4 == null) {
+    return <div>
+      <h1>여섯번째 시도</h1>
+      <p>잘못된 주소입니다. 주소에서 movie 뒤의 숫자는 {movies.filter(movie => movie.renditions.mp4 != null).map(movie => movie.id).join(",")} 중 하나여야 합니다.</p>
+      <p>현재 movie 뒤의 숫자: {props.id}</p>
+      </div>
+  }
+
+  const videoUrl = getMovieFileUrl(movie, decodedUrl);
+
   return <div>
     <h1>여섯번째 시도 {props.id}</h1>
     <p>
@@ -122,6 +138,6 @@ export function SixthMovie({ ...props }) {
   </div>
 }
 
-function getMovieFileUrl(id: "1" | "2" | "3" | "4" | "5", videoBaseUrl: string) {
-  return `${videoBaseUrl}/videos/video-${id}.mp4`;
+function getMovieFileUrl(movie: Movie, videoBaseUrl: string) {
+  return renditionUrl(videoBaseUrl, movie.renditions.mp4!);
 }
@@ -41,7 +41,10 @@ export function WebRTCAutoSessionExchangeSample({ ...props }) {
         console.error(event.streams);
         return;
       }
-      videoRef.current.srcObject = event.streams[0];
+      // 영상과 소리가 같은 stream 으로 오므로 한 번만 붙인다.
+      if (videoRef.current.srcObject !== event.streams[0]) {
+        videoRef.current.srcObject = event.streams[0];
+      }
     };
 
     pc.oniceconnectionstatechange = (event) => {
@@ -94,6 +97,9 @@ export function WebRTCAutoSessionExchangeSample({ ...props }) {
     pc.addTransceiver('video', {
       direction: 'sendrecv'
     });
+    pc.addTransceiver('audio', {
+      direction: 'recvonly'
+    });
 
     pc.createOffer().then(d => pc.setLocalDescription((d))).catch(e => console.error(e));
 